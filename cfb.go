@@ -0,0 +1,362 @@
+package visio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// compoundFileSignature starts every OLE compound file, the container
+// format of legacy binary Visio drawings (.vsd)
+var compoundFileSignature = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+
+// Special sector numbers of the file allocation table
+const (
+	cfbMaxSector  = 0xFFFFFFFA
+	cfbEndOfChain = 0xFFFFFFFE
+)
+
+// Directory entry types
+const (
+	cfbStream = 2
+	cfbRoot   = 5
+)
+
+// compoundFile is an opened OLE compound file: a file system of storages
+// and streams stored in fixed-size sectors chained by an allocation table.
+// Streams smaller than the mini stream cutoff are stored in 64-byte mini
+// sectors inside the root entry's stream.
+type compoundFile struct {
+	data       []byte
+	sectorSize int
+	miniSize   int
+	miniCutoff uint64
+	fat        []uint32
+	miniFAT    []uint32
+	miniStream []byte
+	entries    []cfbEntry
+}
+
+// cfbEntry is a directory entry. Siblings form a binary tree through left
+// and right; child is the root of the tree of a storage's members.
+type cfbEntry struct {
+	name  string
+	kind  byte
+	left  uint32
+	right uint32
+	child uint32
+	start uint32
+	size  uint64
+}
+
+// isCompoundFile reports whether data starts with the compound file signature
+func isCompoundFile(data []byte) bool {
+	return bytes.HasPrefix(data, compoundFileSignature)
+}
+
+// openCompoundFile reads the allocation tables and directory of a compound
+// file held in memory
+func openCompoundFile(data []byte) (*compoundFile, error) {
+	if len(data) < 512 || !isCompoundFile(data) {
+		return nil, fmt.Errorf("not an OLE compound file")
+	}
+	le := binary.LittleEndian
+	major := le.Uint16(data[0x1A:])
+	shift := le.Uint16(data[0x1E:])
+	miniShift := le.Uint16(data[0x20:])
+	if shift != 9 && shift != 12 {
+		return nil, fmt.Errorf("unsupported sector size 2^%d", shift)
+	}
+	if miniShift == 0 || miniShift >= shift {
+		return nil, fmt.Errorf("unsupported mini sector size 2^%d", miniShift)
+	}
+	cf := &compoundFile{
+		data:       data,
+		sectorSize: 1 << shift,
+		miniSize:   1 << miniShift,
+		miniCutoff: uint64(le.Uint32(data[0x38:])),
+	}
+
+	// The header lists the first 109 FAT sectors; DIFAT sectors list the
+	// rest, each ending with the number of the next DIFAT sector. Counts
+	// are checked against the file size, as they size allocations.
+	maxSectors := len(data) / cf.sectorSize
+	numFAT := int(le.Uint32(data[0x2C:]))
+	if numFAT > maxSectors {
+		return nil, fmt.Errorf("FAT sector count %d exceeds the file size", numFAT)
+	}
+	fatSectors := make([]uint32, 0, numFAT)
+	for i := 0; i < 109 && len(fatSectors) < numFAT; i++ {
+		fatSectors = append(fatSectors, le.Uint32(data[0x4C+4*i:]))
+	}
+	next := le.Uint32(data[0x44:])
+	perSector := cf.sectorSize/4 - 1
+	numDIFAT := int(le.Uint32(data[0x48:]))
+	for i := 0; i < numDIFAT && i < maxSectors && next < cfbMaxSector && len(fatSectors) < numFAT; i++ {
+		sector, err := cf.sector(next)
+		if err != nil {
+			return nil, err
+		}
+		for j := 0; j < perSector && len(fatSectors) < numFAT; j++ {
+			fatSectors = append(fatSectors, le.Uint32(sector[4*j:]))
+		}
+		next = le.Uint32(sector[4*perSector:])
+	}
+	for _, s := range fatSectors {
+		sector, err := cf.sector(s)
+		if err != nil {
+			return nil, err
+		}
+		for j := 0; j+4 <= len(sector); j += 4 {
+			cf.fat = append(cf.fat, le.Uint32(sector[j:]))
+		}
+	}
+
+	dir, err := sectorChain(le.Uint32(data[0x30:]), cf.fat, cf.sectorSize, cf.sector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory: %w", err)
+	}
+	for off := 0; off+128 <= len(dir); off += 128 {
+		raw := dir[off : off+128]
+		nameLen := int(le.Uint16(raw[64:]))
+		if nameLen > 64 {
+			nameLen = 64
+		}
+		entry := cfbEntry{
+			name:  decodeUTF16(raw[:nameLen]),
+			kind:  raw[66],
+			left:  le.Uint32(raw[68:]),
+			right: le.Uint32(raw[72:]),
+			child: le.Uint32(raw[76:]),
+			start: le.Uint32(raw[116:]),
+			size:  le.Uint64(raw[120:]),
+		}
+		if major == 3 {
+			// Version 3 files may leave garbage in the high half of the size
+			entry.size &= 0xFFFFFFFF
+		}
+		cf.entries = append(cf.entries, entry)
+	}
+	if len(cf.entries) == 0 || cf.entries[0].kind != cfbRoot {
+		return nil, fmt.Errorf("compound file has no root entry")
+	}
+
+	if first := le.Uint32(data[0x3C:]); first < cfbMaxSector {
+		table, err := sectorChain(first, cf.fat, cf.sectorSize, cf.sector)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read mini FAT: %w", err)
+		}
+		for j := 0; j+4 <= len(table); j += 4 {
+			cf.miniFAT = append(cf.miniFAT, le.Uint32(table[j:]))
+		}
+	}
+	root := cf.entries[0]
+	if root.start < cfbMaxSector {
+		cf.miniStream, err = sectorChain(root.start, cf.fat, cf.sectorSize, cf.sector)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read mini stream: %w", err)
+		}
+	}
+	return cf, nil
+}
+
+// sector returns the contents of a sector
+func (cf *compoundFile) sector(n uint32) ([]byte, error) {
+	start := (int64(n) + 1) * int64(cf.sectorSize)
+	if n >= cfbMaxSector || start+int64(cf.sectorSize) > int64(len(cf.data)) {
+		return nil, fmt.Errorf("sector %d is out of range", n)
+	}
+	return cf.data[start : start+int64(cf.sectorSize)], nil
+}
+
+// miniSector returns the contents of a mini sector
+func (cf *compoundFile) miniSector(n uint32) ([]byte, error) {
+	start := int64(n) * int64(cf.miniSize)
+	if start+int64(cf.miniSize) > int64(len(cf.miniStream)) {
+		return nil, fmt.Errorf("mini sector %d is out of range", n)
+	}
+	return cf.miniStream[start : start+int64(cf.miniSize)], nil
+}
+
+// sectorChain concatenates the sectors of a chain starting at first,
+// following an allocation table. A chain that visits a sector twice is
+// cyclic.
+func sectorChain(first uint32, table []uint32, size int, sector func(uint32) ([]byte, error)) ([]byte, error) {
+	data := make([]byte, 0, size)
+	visited := make([]bool, len(table))
+	for n := first; n != cfbEndOfChain; n = table[n] {
+		if int(n) >= len(table) || visited[n] {
+			return nil, fmt.Errorf("broken sector chain at %d", n)
+		}
+		visited[n] = true
+		s, err := sector(n)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, s...)
+	}
+	return data, nil
+}
+
+// stream returns the contents of a stream in the root storage, matching
+// its name case-insensitively
+func (cf *compoundFile) stream(name string) ([]byte, error) {
+	for _, i := range cf.children(0) {
+		entry := cf.entries[i]
+		if entry.kind != cfbStream || !strings.EqualFold(entry.name, name) {
+			continue
+		}
+		var data []byte
+		var err error
+		if entry.size < cf.miniCutoff {
+			data, err = sectorChain(entry.start, cf.miniFAT, cf.miniSize, cf.miniSector)
+		} else {
+			data, err = sectorChain(entry.start, cf.fat, cf.sectorSize, cf.sector)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stream %s: %w", name, err)
+		}
+		if uint64(len(data)) < entry.size {
+			return nil, fmt.Errorf("stream %s is truncated", name)
+		}
+		return data[:entry.size], nil
+	}
+	return nil, fmt.Errorf("stream not found: %s", name)
+}
+
+// children returns the indexes of the members of a storage
+func (cf *compoundFile) children(storage int) []int {
+	members := make([]int, 0)
+	seen := make(map[uint32]bool)
+	var walk func(i uint32)
+	walk = func(i uint32) {
+		if int(i) >= len(cf.entries) || seen[i] {
+			return
+		}
+		seen[i] = true
+		walk(cf.entries[i].left)
+		members = append(members, int(i))
+		walk(cf.entries[i].right)
+	}
+	walk(cf.entries[storage].child)
+	return members
+}
+
+// Format IDs of the property sets of the summary information streams
+var (
+	summaryInformationFormat = [16]byte{0xE0, 0x85, 0x9F, 0xF2, 0xF9, 0x4F, 0x68, 0x10, 0xAB, 0x91, 0x08, 0x00, 0x2B, 0x27, 0xB3, 0xD9}
+	documentSummaryFormat    = [16]byte{0x02, 0xD5, 0xCD, 0xD5, 0x9C, 0x2E, 0x1B, 0x10, 0x93, 0x97, 0x08, 0x00, 0x2B, 0x2C, 0xF9, 0xAE}
+)
+
+// propertySet reads the first section of an OLE property set stream with
+// the given format ID. String, integer and date properties are returned by
+// property ID; dates are formatted as RFC 3339 timestamps.
+func propertySet(data []byte, format [16]byte) (map[uint32]string, error) {
+	le := binary.LittleEndian
+	if len(data) < 48 || le.Uint16(data) != 0xFFFE {
+		return nil, fmt.Errorf("not a property set stream")
+	}
+	if !bytes.Equal(data[28:44], format[:]) {
+		return nil, fmt.Errorf("unexpected property set format")
+	}
+	section := int(le.Uint32(data[44:]))
+	if section+8 > len(data) {
+		return nil, fmt.Errorf("property set section is out of range")
+	}
+	count := int(le.Uint32(data[section+4:]))
+	if limit := (len(data) - section - 8) / 8; count > limit {
+		// Each property takes an 8-byte entry of the section
+		count = limit
+	}
+
+	values := make(map[uint32]string)
+	codePage := 1252
+	type property struct{ id, offset uint32 }
+	properties := make([]property, 0, count)
+	for i := 0; i < count && section+8+8*i+8 <= len(data); i++ {
+		entry := data[section+8+8*i:]
+		properties = append(properties, property{le.Uint32(entry), le.Uint32(entry[4:])})
+	}
+	// The code page (property 1) applies to all 8-bit strings of the section
+	for _, p := range properties {
+		at := section + int(p.offset)
+		if p.id == 1 && at+6 <= len(data) && le.Uint16(data[at:]) == 2 {
+			codePage = int(le.Uint16(data[at+4:]))
+		}
+	}
+	for _, p := range properties {
+		at := section + int(p.offset)
+		if p.id < 2 || at+8 > len(data) {
+			continue
+		}
+		value := data[at+4:]
+		switch le.Uint16(data[at:]) {
+		case 2: // VT_I2
+			values[p.id] = fmt.Sprint(int16(le.Uint16(value)))
+		case 3: // VT_I4
+			values[p.id] = fmt.Sprint(int32(le.Uint32(value)))
+		case 0x1E: // VT_LPSTR
+			n := int(le.Uint32(value))
+			if n <= len(value)-4 {
+				values[p.id] = strings.TrimRight(decodeCodePage(value[4:4+n], codePage), "\x00")
+			}
+		case 0x1F: // VT_LPWSTR
+			n := int(le.Uint32(value)) * 2
+			if n <= len(value)-4 {
+				values[p.id] = strings.TrimRight(decodeUTF16(value[4:4+n]), "\x00")
+			}
+		case 0x40: // VT_FILETIME, in 100ns intervals since 1601
+			if len(value) >= 8 {
+				if t := le.Uint64(value); t != 0 {
+					values[p.id] = fileTime(t).Format(time.RFC3339)
+				}
+			}
+		}
+	}
+	return values, nil
+}
+
+// fileTime converts a Windows FILETIME to a UTC time
+func fileTime(t uint64) time.Time {
+	const epochDifference = 116444736000000000 // 1601-01-01 to 1970-01-01 in 100ns
+	return time.Unix(0, 0).Add(time.Duration(int64(t)-epochDifference) * 100).UTC()
+}
+
+// decodeUTF16 decodes little-endian UTF-16 text, dropping a trailing NUL
+func decodeUTF16(data []byte) string {
+	units := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		units = append(units, binary.LittleEndian.Uint16(data[i:]))
+	}
+	for len(units) > 0 && units[len(units)-1] == 0 {
+		units = units[:len(units)-1]
+	}
+	return string(utf16.Decode(units))
+}
+
+// windows1252 maps the bytes 0x80-0x9F of code page 1252 to runes; the
+// other bytes equal their Latin-1 code points
+var windows1252 = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8D, 'Ž', 0x8F,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9D, 'ž', 'Ÿ',
+}
+
+// decodeCodePage decodes 8-bit text. UTF-8 is decoded as such; other code
+// pages are read as Windows-1252, the code page of Western-language files.
+func decodeCodePage(data []byte, codePage int) string {
+	if codePage == 65001 {
+		return string(data)
+	}
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		if b >= 0x80 && b < 0xA0 {
+			runes[i] = windows1252[b-0x80]
+		} else {
+			runes[i] = rune(b)
+		}
+	}
+	return string(runes)
+}
@@ -0,0 +1,280 @@
+package visio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// Sector numbers used by the test compound file
+const (
+	testFreeSector = 0xFFFFFFFF
+	testFATSector  = 0xFFFFFFFD
+)
+
+// testCompoundFile is a compound file with a stream in regular sectors and
+// a stream in the mini stream, with the sector numbers tests corrupt
+type testCompoundFile struct {
+	data      []byte
+	bigStart  uint32
+	bigLast   uint32
+	miniFAT   uint32
+	directory uint32
+}
+
+// buildTestCompoundFile builds a version 3 compound file with 512-byte
+// sectors: one FAT sector followed by the streams, the mini FAT and the
+// directory
+func buildTestCompoundFile(big, little []byte) *testCompoundFile {
+	const sectorSize = 512
+	le := binary.LittleEndian
+	sectors := [][]byte{nil}
+	fat := []uint32{testFATSector}
+	chain := func(data []byte) (uint32, uint32) {
+		first := uint32(len(sectors))
+		for at := 0; at < len(data); at += sectorSize {
+			sector := make([]byte, sectorSize)
+			copy(sector, data[at:])
+			sectors = append(sectors, sector)
+			fat = append(fat, uint32(len(sectors)))
+		}
+		fat[len(fat)-1] = cfbEndOfChain
+		return first, uint32(len(sectors) - 1)
+	}
+
+	f := &testCompoundFile{}
+	f.bigStart, f.bigLast = chain(big)
+	miniStream, _ := chain(little)
+	miniFAT := make([]byte, 0)
+	miniSectors := (len(little) + 63) / 64
+	for i := 1; i <= miniSectors; i++ {
+		next := uint32(i)
+		if i == miniSectors {
+			next = cfbEndOfChain
+		}
+		miniFAT = binary.LittleEndian.AppendUint32(miniFAT, next)
+	}
+	f.miniFAT, _ = chain(miniFAT)
+
+	directory := make([]byte, 3*128)
+	entry := func(i int, name string, kind byte, child, start uint32, size int) {
+		e := directory[128*i:]
+		encoded := vsdTestUTF16(name)
+		copy(e, encoded)
+		le.PutUint16(e[64:], uint16(len(encoded)+2))
+		e[66] = kind
+		le.PutUint32(e[68:], testFreeSector)
+		le.PutUint32(e[72:], testFreeSector)
+		le.PutUint32(e[76:], child)
+		le.PutUint32(e[116:], start)
+		le.PutUint64(e[120:], uint64(size))
+	}
+	entry(0, "Root Entry", cfbRoot, 1, miniStream, 64*miniSectors)
+	entry(1, "VisioDocument", cfbStream, testFreeSector, f.bigStart, len(big))
+	entry(2, "Small", cfbStream, testFreeSector, 0, len(little))
+	le.PutUint32(directory[128+72:], 2)
+	f.directory, _ = chain(directory)
+
+	sectors[0] = make([]byte, sectorSize)
+	for i := 0; i < sectorSize/4; i++ {
+		next := uint32(testFreeSector)
+		if i < len(fat) {
+			next = fat[i]
+		}
+		le.PutUint32(sectors[0][4*i:], next)
+	}
+
+	header := make([]byte, sectorSize)
+	copy(header, compoundFileSignature)
+	le.PutUint16(header[0x1A:], 3)
+	le.PutUint16(header[0x1C:], 0xFFFE)
+	le.PutUint16(header[0x1E:], 9)
+	le.PutUint16(header[0x20:], 6)
+	le.PutUint32(header[0x2C:], 1)
+	le.PutUint32(header[0x30:], f.directory)
+	le.PutUint32(header[0x38:], 4096)
+	le.PutUint32(header[0x3C:], f.miniFAT)
+	le.PutUint32(header[0x40:], 1)
+	le.PutUint32(header[0x44:], cfbEndOfChain)
+	for i := 0; i < 109; i++ {
+		le.PutUint32(header[0x4C+4*i:], testFreeSector)
+	}
+	le.PutUint32(header[0x4C:], 0)
+
+	f.data = bytes.Join(append([][]byte{header}, sectors...), nil)
+	return f
+}
+
+// setFAT points a FAT entry of a test compound file at another sector
+func (f *testCompoundFile) setFAT(sector, next uint32) []byte {
+	data := append([]byte(nil), f.data...)
+	binary.LittleEndian.PutUint32(data[512+4*sector:], next)
+	return data
+}
+
+func testStreams() ([]byte, []byte) {
+	big := bytes.Repeat([]byte("VisioDocument "), 400)
+	return big, []byte("a stream short enough for the mini stream, spanning two mini sectors")
+}
+
+func TestOpenCompoundFile(t *testing.T) {
+	big, little := testStreams()
+	f := buildTestCompoundFile(big, little)
+	cf, err := openCompoundFile(f.data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want []byte
+	}{
+		{"VisioDocument", big},
+		{"visiodocument", big},
+		{"Small", little},
+	}
+	for _, tt := range tests {
+		got, err := cf.stream(tt.name)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: got %d bytes, want %d", tt.name, len(got), len(tt.want))
+		}
+	}
+	if _, err := cf.stream("Missing"); err == nil {
+		t.Error("missing stream: expected an error")
+	}
+}
+
+func TestOpenCompoundFileInvalid(t *testing.T) {
+	big, little := testStreams()
+	f := buildTestCompoundFile(big, little)
+	header := func(at int, value uint32) []byte {
+		data := append([]byte(nil), f.data...)
+		binary.LittleEndian.PutUint32(data[at:], value)
+		return data
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"not a compound file", bytes.Repeat([]byte{0xD0}, 1024)},
+		{"truncated header", f.data[:100]},
+		{"header only", f.data[:512]},
+		{"truncated directory", f.data[:512*(int(f.directory)+1)]},
+		{"sector size", func() []byte {
+			data := append([]byte(nil), f.data...)
+			binary.LittleEndian.PutUint16(data[0x1E:], 20)
+			return data
+		}()},
+		{"oversized FAT sector count", header(0x2C, 0xFFFFFFFF)},
+		{"FAT sector count beyond the file", header(0x2C, uint32(len(f.data)/512+1))},
+		{"FAT sector out of range", header(0x4C, 0x7FFFFFFF)},
+		{"cyclic directory chain", f.setFAT(f.directory, f.directory)},
+		{"cyclic FAT chain of the mini FAT", f.setFAT(f.miniFAT, f.miniFAT)},
+		{"directory chain out of range", header(0x30, 0x7FFFFFFF)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := openCompoundFile(tt.data); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestCompoundFileBrokenStreams(t *testing.T) {
+	big, little := testStreams()
+	f := buildTestCompoundFile(big, little)
+
+	cyclicMini := append([]byte(nil), f.data...)
+	miniFAT := 512 * (int(f.miniFAT) + 1)
+	binary.LittleEndian.PutUint32(cyclicMini[miniFAT:], 0)
+
+	tests := []struct {
+		name   string
+		data   []byte
+		stream string
+	}{
+		{"cyclic FAT chain", f.setFAT(f.bigLast, f.bigStart), "VisioDocument"},
+		{"chain into a free sector", f.setFAT(f.bigStart, testFreeSector), "VisioDocument"},
+		{"truncated chain", f.setFAT(f.bigStart, cfbEndOfChain), "VisioDocument"},
+		{"cyclic mini FAT chain", cyclicMini, "Small"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cf, err := openCompoundFile(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := cf.stream(tt.stream); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestOpenCompoundFileTruncated(t *testing.T) {
+	big, little := testStreams()
+	f := buildTestCompoundFile(big, little)
+	// Every prefix must fail cleanly or read a stream, never panic
+	for n := 0; n < len(f.data); n += 64 {
+		cf, err := openCompoundFile(f.data[:n])
+		if err != nil {
+			continue
+		}
+		for _, name := range []string{"VisioDocument", "Small"} {
+			if data, err := cf.stream(name); err == nil && len(data) == 0 {
+				t.Errorf("%d bytes: empty stream %s", n, name)
+			}
+		}
+	}
+}
+
+func TestPropertySet(t *testing.T) {
+	le := binary.LittleEndian
+	stream := make([]byte, 48)
+	le.PutUint16(stream, 0xFFFE)
+	copy(stream[28:], summaryInformationFormat[:])
+	le.PutUint32(stream[44:], 48)
+
+	section := make([]byte, 8+16)
+	le.PutUint32(section[4:], 2)
+	le.PutUint32(section[8:], 2) // Title
+	le.PutUint32(section[12:], 24)
+	le.PutUint32(section[16:], 4) // Creator
+	le.PutUint32(section[20:], 36)
+	title := []byte{0x1E, 0, 0, 0, 4, 0, 0, 0, 'F', 'l', 'o', 'w'}
+	creator := []byte{0x1E, 0, 0, 0, 3, 0, 0, 0, 'A', 'n', 0, 0}
+	data := append(append(append(stream, section...), title...), creator...)
+	le.PutUint32(data[48:], uint32(len(data)-48))
+
+	values, err := propertySet(data, summaryInformationFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values[2] != "Flow" || values[4] != "An" {
+		t.Errorf("values = %v, want title Flow and creator An", values)
+	}
+
+	if _, err := propertySet(data, documentSummaryFormat); err == nil {
+		t.Error("wrong format: expected an error")
+	}
+	if _, err := propertySet(data[:40], summaryInformationFormat); err == nil {
+		t.Error("truncated stream: expected an error")
+	}
+
+	// A property count far beyond the section is capped by its size
+	huge := append([]byte(nil), data...)
+	le.PutUint32(huge[48+4:], 0xFFFFFFFF)
+	if _, err := propertySet(huge, summaryInformationFormat); err != nil {
+		t.Fatal(err)
+	}
+	outOfRange := append([]byte(nil), data...)
+	le.PutUint32(outOfRange[44:], 0xFFFFFFF0)
+	if _, err := propertySet(outOfRange, summaryInformationFormat); err == nil {
+		t.Error("section out of range: expected an error")
+	}
+}
@@ -0,0 +1,317 @@
+package visio
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Namespace and content type of the comments part
+const (
+	visioNamespace      = "http://schemas.microsoft.com/office/visio/2012/main"
+	commentsContentType = "application/vnd.ms-visio.comments+xml"
+)
+
+// xmlComments is the root element of visio/comments.xml
+type xmlComments struct {
+	Authors []xmlCommentAuthor `xml:"AuthorList>AuthorEntry"`
+	Entries []xmlCommentEntry  `xml:"CommentList>CommentEntry"`
+}
+
+// xmlCommentAuthor is an AuthorEntry element
+type xmlCommentAuthor struct {
+	ID       string `xml:"ID,attr"`
+	Name     string `xml:"Name,attr"`
+	Initials string `xml:"Initials,attr"`
+}
+
+// xmlCommentEntry is a CommentEntry element; its text is the comment
+type xmlCommentEntry struct {
+	CommentID string `xml:"CommentID,attr"`
+	AuthorID  string `xml:"AuthorID,attr"`
+	PageID    string `xml:"PageID,attr"`
+	ShapeID   string `xml:"ShapeID,attr"`
+	Date      string `xml:"Date,attr"`
+	EditDate  string `xml:"EditDate,attr"`
+	Done      string `xml:"Done,attr"`
+	Text      string `xml:",chardata"`
+}
+
+// commentsPart returns the name of the comments part, which may not exist
+func (p *vsdxPackage) commentsPart() string {
+	if part, ok := relationshipByType(p.documentRels, relTypeComments); ok {
+		return part
+	}
+	return "visio/comments.xml"
+}
+
+// loadComments reads the comments of the document in the order they are
+// stored. A document without a comments part has no comments.
+func (p *vsdxPackage) loadComments() ([]Comment, error) {
+	if p.comments != nil {
+		return p.comments, nil
+	}
+
+	comments := make([]Comment, 0)
+	part := p.commentsPart()
+	if findZipFile(p.zip, part) != nil {
+		data, err := readZipFile(p.zip, part)
+		if err != nil {
+			return nil, err
+		}
+		var xc xmlComments
+		if err := xml.Unmarshal(data, &xc); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", part, err)
+		}
+
+		authors := make(map[string]xmlCommentAuthor, len(xc.Authors))
+		for _, author := range xc.Authors {
+			authors[author.ID] = author
+		}
+		pageNames := make(map[string]string, len(p.pages))
+		for i := range p.pages {
+			pageNames[p.pages[i].xml.ID] = p.pages[i].name()
+		}
+		for _, entry := range xc.Entries {
+			comments = append(comments, Comment{
+				ID:       entry.CommentID,
+				Author:   authors[entry.AuthorID].Name,
+				Initials: authors[entry.AuthorID].Initials,
+				PageID:   entry.PageID,
+				Page:     pageNames[entry.PageID],
+				ShapeID:  entry.ShapeID,
+				Date:     entry.Date,
+				EditDate: entry.EditDate,
+				Done:     parseBool(entry.Done),
+				Text:     entry.Text,
+			})
+		}
+	}
+
+	p.comments = comments
+	return comments, nil
+}
+
+// pageComments returns the comments on a page and its shapes
+func pageComments(comments []Comment, pageID string) []Comment {
+	result := make([]Comment, 0)
+	for _, c := range comments {
+		if c.PageID == pageID {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// ReadComments returns the comments of the document. A page name limits
+// them to that page, and a shape ID further to that shape of the page.
+func (r *Reader) ReadComments(pageName, shapeID string) ([]Comment, error) {
+	pkg, err := r.openPackage()
+	if err != nil {
+		return nil, err
+	}
+
+	comments, err := pkg.loadComments()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read comments: %w", err)
+	}
+	if pageName == "" {
+		if shapeID != "" {
+			return nil, fmt.Errorf("a page name is required to select comments by shape")
+		}
+		return comments, nil
+	}
+
+	entry, ok := pkg.findPage(pageName)
+	if !ok {
+		return nil, fmt.Errorf("page not found: %s", pageName)
+	}
+	comments = pageComments(comments, entry.xml.ID)
+	if shapeID == "" {
+		return comments, nil
+	}
+	result := make([]Comment, 0)
+	for _, c := range comments {
+		if c.ShapeID == shapeID {
+			result = append(result, c)
+		}
+	}
+	return result, nil
+}
+
+// AddComment adds a comment to a page, or to one of its shapes when shapeID
+// is set. The author, initials and text are taken from comment; initials
+// default to those of the author's name. Returns the ID of the comment.
+func (w *Writer) AddComment(pageName, shapeID string, comment Comment) (string, error) {
+	var id string
+	err := w.updatePackage(func(e *packageEdit) error {
+		entry, ok := e.pkg.findPage(pageName)
+		if !ok {
+			return fmt.Errorf("%w: %s", errPageNotFound, pageName)
+		}
+		if shapeID != "" {
+			data, err := readZipFile(e.pkg.zip, entry.part)
+			if err != nil {
+				return err
+			}
+			tree, err := parseXMLTree(data)
+			if err != nil {
+				return fmt.Errorf("failed to parse %s: %w", entry.part, err)
+			}
+			if _, err := shapeElement(tree, shapeID); err != nil {
+				return err
+			}
+		}
+
+		root, err := commentsTree(e)
+		if err != nil {
+			return err
+		}
+		id, err = addCommentEntry(root, entry.xml.ID, shapeID, comment)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// ReplyToComment adds a reply to a comment: a comment on the same page or
+// shape, which Visio shows in the same conversation. Returns the ID of the
+// reply.
+func (w *Writer) ReplyToComment(commentID string, reply Comment) (string, error) {
+	var id string
+	err := w.updatePackage(func(e *packageEdit) error {
+		root, err := commentsTree(e)
+		if err != nil {
+			return err
+		}
+		original, err := findCommentEntry(root, commentID)
+		if err != nil {
+			return err
+		}
+		id, err = addCommentEntry(root, original.attr("PageID"), original.attr("ShapeID"), reply)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// ResolveComment marks a comment as resolved, or reopens it when done is
+// false
+func (w *Writer) ResolveComment(commentID string, done bool) error {
+	return w.updatePackage(func(e *packageEdit) error {
+		root, err := commentsTree(e)
+		if err != nil {
+			return err
+		}
+		entry, err := findCommentEntry(root, commentID)
+		if err != nil {
+			return err
+		}
+		entry.setAttr("Done", boolCellValue(done))
+		return nil
+	})
+}
+
+// commentsTree returns the tree of the comments part, adding an empty part
+// when the document has none
+func commentsTree(e *packageEdit) (*xmlNode, error) {
+	part := e.pkg.commentsPart()
+	if e.hasPart(part) {
+		return e.part(part)
+	}
+	root := newXMLElement("Comments", "xmlns", visioNamespace)
+	root.addElement("AuthorList", nil)
+	root.addElement("CommentList", nil)
+	tree := newXMLDocument(root)
+	if _, err := e.addPart(part, commentsContentType, e.pkg.documentPart, relTypeComments, tree); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// addCommentEntry appends a comment to the comments part, registering its
+// author as needed, and returns the ID of the comment
+func addCommentEntry(tree *xmlNode, pageID, shapeID string, comment Comment) (string, error) {
+	if strings.TrimSpace(comment.Author) == "" {
+		return "", fmt.Errorf("comment author is required")
+	}
+	if strings.TrimSpace(comment.Text) == "" {
+		return "", fmt.Errorf("comment text is required")
+	}
+
+	root := tree.root()
+	authorID := commentAuthorID(root, comment.Author, comment.Initials)
+	list := root.child("CommentList")
+	if list == nil {
+		list = root.addElement("CommentList", nil)
+	}
+	id := nextCommentID(list)
+
+	attrs := []string{"AuthorID", authorID, "PageID", pageID}
+	if shapeID != "" {
+		attrs = append(attrs, "ShapeID", shapeID)
+	}
+	attrs = append(attrs, "Date", time.Now().UTC().Format("2006-01-02T15:04:05"), "CommentID", id)
+	list.addElement("CommentEntry", nil, attrs...).setText(comment.Text)
+	return id, nil
+}
+
+// commentAuthorID returns the ID of the author with the given name, adding
+// an AuthorEntry for new authors
+func commentAuthorID(root *xmlNode, name, initials string) string {
+	list := root.child("AuthorList")
+	if list == nil {
+		list = root.addElement("AuthorList", []string{"CommentList"})
+	}
+	next := 0
+	for _, author := range list.elements("AuthorEntry") {
+		if strings.EqualFold(author.attr("Name"), name) {
+			return author.attr("ID")
+		}
+		if n, err := strconv.Atoi(author.attr("ID")); err == nil && n >= next {
+			next = n + 1
+		}
+	}
+	if initials == "" {
+		initials = nameInitials(name)
+	}
+	id := strconv.Itoa(next)
+	list.addElement("AuthorEntry", nil, "Name", name, "Initials", initials, "ID", id)
+	return id
+}
+
+// nameInitials returns the upper-cased first letters of the words of a name
+func nameInitials(name string) string {
+	var initials strings.Builder
+	for _, word := range strings.Fields(name) {
+		initials.WriteString(strings.ToUpper(string([]rune(word)[:1])))
+	}
+	return initials.String()
+}
+
+// nextCommentID returns the next free comment ID
+func nextCommentID(list *xmlNode) string {
+	next := 0
+	for _, entry := range list.elements("CommentEntry") {
+		if n, err := strconv.Atoi(entry.attr("CommentID")); err == nil && n >= next {
+			next = n + 1
+		}
+	}
+	return strconv.Itoa(next)
+}
+
+// findCommentEntry returns the CommentEntry element with the given ID
+func findCommentEntry(tree *xmlNode, id string) (*xmlNode, error) {
+	if list := tree.root().child("CommentList"); list != nil {
+		if entry := list.findElement("CommentEntry", "CommentID", id); entry != nil {
+			return entry, nil
+		}
+	}
+	return nil, fmt.Errorf("comment not found: %s", id)
+}
@@ -0,0 +1,464 @@
+package visio
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Namespaces and content types of the document properties parts
+const (
+	corePropertiesNamespace     = "http://schemas.openxmlformats.org/package/2006/metadata/core-properties"
+	extendedPropertiesNamespace = "http://schemas.openxmlformats.org/officeDocument/2006/extended-properties"
+	customPropertiesNamespace   = "http://schemas.openxmlformats.org/officeDocument/2006/custom-properties"
+	variantTypesNamespace       = "http://schemas.openxmlformats.org/officeDocument/2006/docPropsVTypes"
+	dublinCoreNamespace         = "http://purl.org/dc/elements/1.1/"
+	dublinCoreTermsNamespace    = "http://purl.org/dc/terms/"
+	schemaInstanceNamespace     = "http://www.w3.org/2001/XMLSchema-instance"
+
+	corePropertiesContentType     = "application/vnd.openxmlformats-package.core-properties+xml"
+	extendedPropertiesContentType = "application/vnd.openxmlformats-officedocument.extended-properties+xml"
+	customPropertiesContentType   = "application/vnd.openxmlformats-officedocument.custom-properties+xml"
+
+	// customPropertyFormatID is the format ID Office writes for user-defined properties
+	customPropertyFormatID = "{D5CDD505-2E9C-101B-9397-08002B2CF9AE}"
+)
+
+// xmlCoreProperties is the root element of docProps/core.xml
+type xmlCoreProperties struct {
+	Title          string `xml:"title"`
+	Subject        string `xml:"subject"`
+	Creator        string `xml:"creator"`
+	Keywords       string `xml:"keywords"`
+	Description    string `xml:"description"`
+	Category       string `xml:"category"`
+	ContentStatus  string `xml:"contentStatus"`
+	Language       string `xml:"language"`
+	LastModifiedBy string `xml:"lastModifiedBy"`
+	Revision       string `xml:"revision"`
+	Created        string `xml:"created"`
+	Modified       string `xml:"modified"`
+	LastPrinted    string `xml:"lastPrinted"`
+}
+
+// xmlExtendedProperties is the root element of docProps/app.xml
+type xmlExtendedProperties struct {
+	Application   string `xml:"Application"`
+	AppVersion    string `xml:"AppVersion"`
+	Company       string `xml:"Company"`
+	Manager       string `xml:"Manager"`
+	Template      string `xml:"Template"`
+	HyperlinkBase string `xml:"HyperlinkBase"`
+}
+
+// xmlCustomProperties is the root element of docProps/custom.xml
+type xmlCustomProperties struct {
+	Properties []xmlCustomProperty `xml:"property"`
+}
+
+// xmlCustomProperty is a custom property holding a single typed value
+type xmlCustomProperty struct {
+	Name  string     `xml:"name,attr"`
+	Value xmlVariant `xml:",any"`
+}
+
+// xmlVariant is a value element such as vt:lpwstr or vt:i4
+type xmlVariant struct {
+	XMLName xml.Name
+	Value   string `xml:",chardata"`
+}
+
+// coreProperties lists the core properties that can be written, with the
+// namespace and preferred prefix of their elements
+var coreProperties = []struct{ name, namespace, prefix string }{
+	{"title", dublinCoreNamespace, "dc"},
+	{"subject", dublinCoreNamespace, "dc"},
+	{"creator", dublinCoreNamespace, "dc"},
+	{"keywords", corePropertiesNamespace, "cp"},
+	{"description", dublinCoreNamespace, "dc"},
+	{"category", corePropertiesNamespace, "cp"},
+	{"contentStatus", corePropertiesNamespace, "cp"},
+	{"language", dublinCoreNamespace, "dc"},
+	{"lastModifiedBy", corePropertiesNamespace, "cp"},
+	{"revision", corePropertiesNamespace, "cp"},
+	{"created", dublinCoreTermsNamespace, "dcterms"},
+	{"modified", dublinCoreTermsNamespace, "dcterms"},
+	{"lastPrinted", corePropertiesNamespace, "cp"},
+}
+
+// extendedProperties lists the extended properties that can be written
+var extendedProperties = []string{"Application", "AppVersion", "Company", "Manager", "Template", "HyperlinkBase"}
+
+// customPropertyTypes maps custom property types to the variant element
+// written for them
+var customPropertyTypes = map[string]string{
+	"string":  "lpwstr",
+	"integer": "i4",
+	"number":  "r8",
+	"boolean": "bool",
+	"date":    "filetime",
+}
+
+// readDocumentProperties reads the core, extended and custom properties
+// parts. Missing parts leave their properties empty.
+func readDocumentProperties(pkg *vsdxPackage) (DocumentProperties, error) {
+	props := DocumentProperties{
+		Custom: make([]CustomProperty, 0),
+	}
+
+	core := xmlCoreProperties{}
+	if err := readPropertiesPart(pkg, relTypeCoreProperties, "docProps/core.xml", &core); err != nil {
+		return props, err
+	}
+	props.Title = strings.TrimSpace(core.Title)
+	props.Subject = strings.TrimSpace(core.Subject)
+	props.Creator = strings.TrimSpace(core.Creator)
+	props.Keywords = strings.TrimSpace(core.Keywords)
+	props.Description = strings.TrimSpace(core.Description)
+	props.Category = strings.TrimSpace(core.Category)
+	props.ContentStatus = strings.TrimSpace(core.ContentStatus)
+	props.Language = strings.TrimSpace(core.Language)
+	props.LastModifiedBy = strings.TrimSpace(core.LastModifiedBy)
+	props.Revision = strings.TrimSpace(core.Revision)
+	props.Created = strings.TrimSpace(core.Created)
+	props.Modified = strings.TrimSpace(core.Modified)
+	props.LastPrinted = strings.TrimSpace(core.LastPrinted)
+
+	extended := xmlExtendedProperties{}
+	if err := readPropertiesPart(pkg, relTypeExtendedProperties, "docProps/app.xml", &extended); err != nil {
+		return props, err
+	}
+	props.Extended = ExtendedProperties(extended)
+
+	custom := xmlCustomProperties{}
+	if err := readPropertiesPart(pkg, relTypeCustomProperties, "docProps/custom.xml", &custom); err != nil {
+		return props, err
+	}
+	for _, p := range custom.Properties {
+		props.Custom = append(props.Custom, CustomProperty{
+			Name:  p.Name,
+			Type:  customPropertyType(p.Value.XMLName.Local),
+			Value: p.Value.Value,
+		})
+	}
+
+	return props, nil
+}
+
+// readPropertiesPart decodes a properties part referenced from the package
+// relationships, falling back to its usual name
+func readPropertiesPart(pkg *vsdxPackage, relType, defaultPart string, v interface{}) error {
+	part, ok := relationshipByType(pkg.rootRels, relType)
+	if !ok {
+		part = defaultPart
+	}
+	if findZipFile(pkg.zip, part) == nil {
+		return nil
+	}
+	data, err := readZipFile(pkg.zip, part)
+	if err != nil {
+		return err
+	}
+	if err := xml.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", part, err)
+	}
+	return nil
+}
+
+// customPropertyType returns the type of a custom property from the name
+// of its variant element
+func customPropertyType(variant string) string {
+	switch variant {
+	case "lpwstr", "lpstr", "bstr":
+		return "string"
+	case "i1", "i2", "i4", "i8", "int", "ui1", "ui2", "ui4", "ui8", "uint":
+		return "integer"
+	case "r4", "r8", "decimal", "cy":
+		return "number"
+	case "bool":
+		return "boolean"
+	case "filetime", "date":
+		return "date"
+	}
+	return variant
+}
+
+// SetDocumentProperties updates the core, extended and custom properties
+// of the document, creating the properties parts as needed. The modified
+// date is set to the current time unless the update sets it.
+func (w *Writer) SetDocumentProperties(update DocumentPropertiesUpdate) error {
+	core := make(map[string]string, len(update.Core)+1)
+	for name, value := range update.Core {
+		core[name] = value
+	}
+	if _, ok := lookupIgnoreCase(core, "modified"); !ok {
+		core["modified"] = time.Now().UTC().Format(time.RFC3339)
+	}
+
+	return w.updatePackage(func(e *packageEdit) error {
+		if err := setCoreProperties(e, core); err != nil {
+			return err
+		}
+		if len(update.Extended) > 0 {
+			if err := setExtendedProperties(e, update.Extended); err != nil {
+				return err
+			}
+		}
+		if len(update.Custom) > 0 {
+			if err := setCustomProperties(e, update.Custom); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// setCoreProperties writes core properties to docProps/core.xml
+func setCoreProperties(e *packageEdit, values map[string]string) error {
+	tree, err := propertiesTree(e, relTypeCoreProperties, "docProps/core.xml", corePropertiesContentType, func() *xmlNode {
+		return newXMLElement("cp:coreProperties",
+			"xmlns:cp", corePropertiesNamespace,
+			"xmlns:dc", dublinCoreNamespace,
+			"xmlns:dcterms", dublinCoreTermsNamespace,
+			"xmlns:xsi", schemaInstanceNamespace,
+		)
+	})
+	if err != nil {
+		return err
+	}
+	root := tree.root()
+
+	for _, name := range sortedKeys(values) {
+		value := values[name]
+		found := false
+		for _, p := range coreProperties {
+			if !strings.EqualFold(p.name, name) {
+				continue
+			}
+			found = true
+			if p.namespace == dublinCoreTermsNamespace && value != "" {
+				if value, err = formatPropertyDate(value); err != nil {
+					return fmt.Errorf("invalid %s: %w", p.name, err)
+				}
+			}
+			prefix := namespacePrefix(root, p.namespace, p.prefix)
+			element := setPropertyElement(root, prefix, p.name, value)
+			if element != nil && p.namespace == dublinCoreTermsNamespace {
+				// W3CDTF dates are marked with their schema type
+				xsi := namespacePrefix(root, schemaInstanceNamespace, "xsi")
+				element.setAttr(xsi+":type", prefix+":W3CDTF")
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown core property: %s", name)
+		}
+	}
+	return nil
+}
+
+// setExtendedProperties writes extended properties to docProps/app.xml
+func setExtendedProperties(e *packageEdit, values map[string]string) error {
+	tree, err := propertiesTree(e, relTypeExtendedProperties, "docProps/app.xml", extendedPropertiesContentType, func() *xmlNode {
+		return newXMLElement("Properties", "xmlns", extendedPropertiesNamespace, "xmlns:vt", variantTypesNamespace)
+	})
+	if err != nil {
+		return err
+	}
+	root := tree.root()
+	prefix := namespacePrefix(root, extendedPropertiesNamespace, "ep")
+
+	for _, name := range sortedKeys(values) {
+		found := false
+		for _, p := range extendedProperties {
+			if strings.EqualFold(p, name) {
+				setPropertyElement(root, prefix, p, values[name])
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown extended property: %s", name)
+		}
+	}
+	return nil
+}
+
+// setCustomProperties sets and deletes properties in docProps/custom.xml
+func setCustomProperties(e *packageEdit, edits []CustomPropertyEdit) error {
+	tree, err := propertiesTree(e, relTypeCustomProperties, "docProps/custom.xml", customPropertiesContentType, func() *xmlNode {
+		return newXMLElement("Properties", "xmlns", customPropertiesNamespace, "xmlns:vt", variantTypesNamespace)
+	})
+	if err != nil {
+		return err
+	}
+	root := tree.root()
+	vt := namespacePrefix(root, variantTypesNamespace, "vt")
+
+	for _, edit := range edits {
+		if edit.Name == "" {
+			return fmt.Errorf("custom property name is required")
+		}
+		property := findCustomProperty(root, edit.Name)
+		if edit.Delete {
+			if property == nil {
+				return fmt.Errorf("custom property not found: %s", edit.Name)
+			}
+			root.removeChild(property)
+			continue
+		}
+
+		propertyType := edit.Type
+		if propertyType == "" && property != nil {
+			for _, value := range property.children {
+				if value.kind == elementNode {
+					propertyType = customPropertyType(value.name.Local)
+					break
+				}
+			}
+		}
+		if propertyType == "" {
+			propertyType = "string"
+		}
+		variant, ok := customPropertyTypes[propertyType]
+		if !ok {
+			return fmt.Errorf("unsupported custom property type %q, expected string, integer, number, boolean or date", propertyType)
+		}
+		value, err := formatCustomPropertyValue(propertyType, edit.Value)
+		if err != nil {
+			return fmt.Errorf("invalid value for custom property %s: %w", edit.Name, err)
+		}
+
+		if property == nil {
+			property = root.addElement("property", nil,
+				"fmtid", customPropertyFormatID,
+				"pid", nextPropertyID(root),
+				"name", edit.Name,
+			)
+		}
+		element := newXMLElement(variant)
+		element.name.Space = vt
+		element.setText(value)
+		property.children = []*xmlNode{element}
+	}
+	return nil
+}
+
+// propertiesTree returns the tree of a properties part, adding the part
+// with the root element from create when the package has none
+func propertiesTree(e *packageEdit, relType, defaultPart, contentType string, create func() *xmlNode) (*xmlNode, error) {
+	part, ok := relationshipByType(e.pkg.rootRels, relType)
+	if ok || e.hasPart(defaultPart) {
+		if !ok {
+			part = defaultPart
+		}
+		return e.part(part)
+	}
+	tree := newXMLDocument(create())
+	if _, err := e.addPart(defaultPart, contentType, "", relType, tree); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// setPropertyElement sets the text of a property element, creating it as
+// needed. An empty value removes the element.
+func setPropertyElement(root *xmlNode, prefix, name, value string) *xmlNode {
+	element := root.child(name)
+	if value == "" {
+		if element != nil {
+			root.removeChild(element)
+		}
+		return nil
+	}
+	if element == nil {
+		element = newXMLElement(name)
+		element.name.Space = prefix
+		root.appendChild(element)
+	}
+	element.setText(value)
+	return element
+}
+
+// findCustomProperty returns the property element with the given name
+func findCustomProperty(root *xmlNode, name string) *xmlNode {
+	for _, property := range root.elements("property") {
+		if strings.EqualFold(property.attr("name"), name) {
+			return property
+		}
+	}
+	return nil
+}
+
+// nextPropertyID returns the next free property ID. IDs 0 and 1 are
+// reserved, so user-defined properties start at 2.
+func nextPropertyID(root *xmlNode) string {
+	next := 2
+	for _, property := range root.elements("property") {
+		if n, err := strconv.Atoi(property.attr("pid")); err == nil && n >= next {
+			next = n + 1
+		}
+	}
+	return strconv.Itoa(next)
+}
+
+// formatCustomPropertyValue validates a value for a custom property type
+// and returns it in the form the variant element expects
+func formatCustomPropertyValue(propertyType, value string) (string, error) {
+	switch propertyType {
+	case "integer":
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 32)
+		if err != nil {
+			return "", fmt.Errorf("%q is not an integer", value)
+		}
+		return strconv.FormatInt(n, 10), nil
+	case "number":
+		n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return "", fmt.Errorf("%q is not a number", value)
+		}
+		return strconv.FormatFloat(n, 'g', -1, 64), nil
+	case "boolean":
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return "", fmt.Errorf("%q is not a boolean", value)
+		}
+		return strconv.FormatBool(b), nil
+	case "date":
+		return formatPropertyDate(value)
+	}
+	return value, nil
+}
+
+// formatPropertyDate normalizes a date to UTC in the W3CDTF form Office
+// writes. Dates without a time are taken as midnight UTC.
+func formatPropertyDate(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC().Format(time.RFC3339), nil
+		}
+	}
+	return "", fmt.Errorf("%q is not a date, expected the form 2006-01-02T15:04:05Z", value)
+}
+
+// lookupIgnoreCase returns a map value by key, ignoring case
+func lookupIgnoreCase(m map[string]string, key string) (string, bool) {
+	for k, v := range m {
+		if strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// sortedKeys returns the keys of a map in sorted order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
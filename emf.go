@@ -0,0 +1,881 @@
+package visio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg" // Decoders for JPEG and PNG bitmaps embedded in DIBs
+	_ "image/png"
+	"math"
+)
+
+// The functions below render an enhanced metafile (EMF), the format Visio
+// stores document thumbnails in, using the rasterizer in raster.go. Lines,
+// shapes, paths and bitmaps are drawn; text, dashed pen styles and EMF+
+// records are not.
+
+// EMF record types
+const (
+	emrHeader                  = 1
+	emrPolyBezier              = 2
+	emrPolygon                 = 3
+	emrPolyline                = 4
+	emrPolyBezierTo            = 5
+	emrPolylineTo              = 6
+	emrPolyPolyline            = 7
+	emrPolyPolygon             = 8
+	emrSetWindowExtEx          = 9
+	emrSetWindowOrgEx          = 10
+	emrSetViewportExtEx        = 11
+	emrSetViewportOrgEx        = 12
+	emrEOF                     = 14
+	emrSetMapMode              = 17
+	emrSetPolyFillMode         = 19
+	emrMoveToEx                = 27
+	emrIntersectClipRect       = 30
+	emrScaleViewportExtEx      = 31
+	emrScaleWindowExtEx        = 32
+	emrSaveDC                  = 33
+	emrRestoreDC               = 34
+	emrSetWorldTransform       = 35
+	emrModifyWorldTransform    = 36
+	emrSelectObject            = 37
+	emrCreatePen               = 38
+	emrCreateBrushIndirect     = 39
+	emrDeleteObject            = 40
+	emrAngleArc                = 41
+	emrEllipse                 = 42
+	emrRectangle               = 43
+	emrRoundRect               = 44
+	emrArc                     = 45
+	emrChord                   = 46
+	emrPie                     = 47
+	emrLineTo                  = 54
+	emrArcTo                   = 55
+	emrSetArcDirection         = 57
+	emrBeginPath               = 59
+	emrEndPath                 = 60
+	emrCloseFigure             = 61
+	emrFillPath                = 62
+	emrStrokeAndFillPath       = 63
+	emrStrokePath              = 64
+	emrSelectClipPath          = 67
+	emrAbortPath               = 68
+	emrExtSelectClipRgn        = 75
+	emrBitBlt                  = 76
+	emrStretchBlt              = 77
+	emrStretchDIBits           = 81
+	emrPolyBezier16            = 85
+	emrPolygon16               = 86
+	emrPolyline16              = 87
+	emrPolyBezierTo16          = 88
+	emrPolylineTo16            = 89
+	emrPolyPolyline16          = 90
+	emrPolyPolygon16           = 91
+	emrCreateMonoBrush         = 93
+	emrCreateDIBPatternBrushPt = 94
+	emrExtCreatePen            = 95
+)
+
+// emfSignature is the " EMF" signature of the header record
+const emfSignature = 0x464D4520
+
+// emfPen is a selected pen. Cosmetic pens are one device pixel wide.
+type emfPen struct {
+	null     bool
+	cosmetic bool
+	width    float64 // In logical units
+	color    color.RGBA
+}
+
+// emfBrush is a selected brush. Hatched and pattern brushes are drawn as
+// solid fills.
+type emfBrush struct {
+	null  bool
+	color color.RGBA
+}
+
+// emfObject is an object in the handle table; objects other than pens
+// and brushes are kept as empty placeholders
+type emfObject struct {
+	pen   *emfPen
+	brush *emfBrush
+}
+
+// emfDC is the state of the playback device context that SaveDC saves
+type emfDC struct {
+	world       transform
+	mapMode     uint32
+	windowOrg   point
+	windowExt   point
+	viewportOrg point
+	viewportExt point
+	pen         emfPen
+	brush       emfBrush
+	evenOdd     bool
+	clockwise   bool
+	position    point // Current position in logical units
+	clip        image.Rectangle
+}
+
+// emfFigure is a subpath or shape outline in output pixels
+type emfFigure struct {
+	points []point
+	closed bool
+}
+
+// emfRenderer plays back EMF records onto an image
+type emfRenderer struct {
+	raster      rasterizer
+	dc          emfDC
+	saved       []emfDC
+	objects     map[uint32]emfObject
+	pixelsPerMM point     // Device pixels per millimeter
+	output      transform // Device pixels to output pixels
+	inPath      bool
+	path        []emfFigure
+}
+
+// emfRecord is a single record, including its type and size fields
+type emfRecord []byte
+
+func (r emfRecord) u32(offset int) uint32 {
+	if offset < 0 || offset+4 > len(r) {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(r[offset:])
+}
+
+func (r emfRecord) i32(offset int) float64 {
+	return float64(int32(r.u32(offset)))
+}
+
+func (r emfRecord) i16(offset int) float64 {
+	if offset < 0 || offset+2 > len(r) {
+		return 0
+	}
+	return float64(int16(binary.LittleEndian.Uint16(r[offset:])))
+}
+
+func (r emfRecord) f32(offset int) float64 {
+	return float64(math.Float32frombits(r.u32(offset)))
+}
+
+func (r emfRecord) point32(offset int) point {
+	return point{r.i32(offset), r.i32(offset + 4)}
+}
+
+func (r emfRecord) color(offset int) color.RGBA {
+	if offset < 0 || offset+3 > len(r) {
+		return color.RGBA{A: 255}
+	}
+	return color.RGBA{R: r[offset], G: r[offset+1], B: r[offset+2], A: 255}
+}
+
+// points reads n points of 32-bit or 16-bit coordinates, limited to the
+// points the record actually holds
+func (r emfRecord) points(offset, n int, short bool) []point {
+	size := 8
+	if short {
+		size = 4
+	}
+	if available := (len(r) - offset) / size; n > available {
+		n = available
+	}
+	if n < 0 {
+		return nil
+	}
+	points := make([]point, n)
+	for i := range points {
+		if short {
+			points[i] = point{r.i16(offset + i*4), r.i16(offset + i*4 + 2)}
+		} else {
+			points[i] = r.point32(offset + i*8)
+		}
+	}
+	return points
+}
+
+// renderEMF renders an enhanced metafile onto a white image whose longer
+// side is size pixels
+func renderEMF(data []byte, size int) (*image.RGBA, error) {
+	header := emfRecord(data)
+	if len(data) < 88 || header.u32(0) != emrHeader || header.u32(40) != emfSignature {
+		return nil, fmt.Errorf("not an enhanced metafile")
+	}
+
+	r := &emfRenderer{
+		objects: make(map[uint32]emfObject),
+	}
+	r.pixelsPerMM = point{1, 1}
+	if mmX, mmY := header.i32(80), header.i32(84); mmX > 0 && mmY > 0 {
+		r.pixelsPerMM = point{header.i32(72) / mmX, header.i32(76) / mmY}
+	}
+
+	// The picture frame is given in hundredths of a millimeter; fall back to
+	// the inclusive bounds in device pixels when it is empty
+	left, top := header.i32(24)/100*r.pixelsPerMM.x, header.i32(28)/100*r.pixelsPerMM.y
+	right, bottom := header.i32(32)/100*r.pixelsPerMM.x, header.i32(36)/100*r.pixelsPerMM.y
+	if right <= left || bottom <= top {
+		left, top = header.i32(8), header.i32(12)
+		right, bottom = header.i32(16)+1, header.i32(20)+1
+	}
+	width, height := right-left, bottom-top
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("metafile has an empty frame")
+	}
+	scale := float64(size) / math.Max(width, height)
+	r.output = transform{a: scale, d: scale, e: -left * scale, f: -top * scale}
+
+	img := image.NewRGBA(image.Rect(0, 0, int(math.Max(1, math.Round(width*scale))), int(math.Max(1, math.Round(height*scale)))))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	r.raster = rasterizer{img: img, clip: img.Bounds()}
+	r.dc = emfDC{
+		world:       identityTransform(),
+		mapMode:     1,
+		windowExt:   point{1, 1},
+		viewportExt: point{1, 1},
+		pen:         emfPen{cosmetic: true, color: color.RGBA{A: 255}},
+		brush:       emfBrush{color: color.RGBA{R: 255, G: 255, B: 255, A: 255}},
+		evenOdd:     true,
+		clip:        img.Bounds(),
+	}
+
+	for offset := 0; offset+8 <= len(data); {
+		recordType := binary.LittleEndian.Uint32(data[offset:])
+		recordSize := int(binary.LittleEndian.Uint32(data[offset+4:]))
+		if recordSize < 8 || offset+recordSize > len(data) {
+			return nil, fmt.Errorf("malformed metafile record at offset %d", offset)
+		}
+		if recordType == emrEOF {
+			break
+		}
+		r.play(recordType, emfRecord(data[offset:offset+recordSize]))
+		offset += recordSize
+	}
+	return img, nil
+}
+
+// play applies a single record
+func (r *emfRenderer) play(recordType uint32, rec emfRecord) {
+	switch recordType {
+	case emrPolygon, emrPolygon16:
+		points := r.transformAll(rec.points(28, int(rec.u32(24)), recordType == emrPolygon16))
+		r.draw([]emfFigure{{points, true}}, true)
+	case emrPolyline, emrPolyline16:
+		points := r.transformAll(rec.points(28, int(rec.u32(24)), recordType == emrPolyline16))
+		r.draw([]emfFigure{{points, false}}, false)
+	case emrPolyBezier, emrPolyBezier16:
+		points := rec.points(28, int(rec.u32(24)), recordType == emrPolyBezier16)
+		if len(points) > 0 {
+			r.draw([]emfFigure{{r.bezierFigure(points[0], points[1:]), false}}, false)
+		}
+	case emrPolylineTo, emrPolylineTo16:
+		points := rec.points(28, int(rec.u32(24)), recordType == emrPolylineTo16)
+		r.lineTo(r.transformAll(points), points)
+	case emrPolyBezierTo, emrPolyBezierTo16:
+		points := rec.points(28, int(rec.u32(24)), recordType == emrPolyBezierTo16)
+		if len(points) >= 3 {
+			figure := r.bezierFigure(r.dc.position, points)
+			r.lineTo(figure[1:], points)
+		}
+	case emrPolyPolyline, emrPolyPolyline16, emrPolyPolygon, emrPolyPolygon16:
+		short := recordType == emrPolyPolyline16 || recordType == emrPolyPolygon16
+		closed := recordType == emrPolyPolygon || recordType == emrPolyPolygon16
+		count := int(rec.u32(24))
+		if count < 0 || 32+count*4 > len(rec) {
+			return
+		}
+		points := rec.points(32+count*4, int(rec.u32(28)), short)
+		figures := make([]emfFigure, 0, count)
+		for i := 0; i < count; i++ {
+			n := int(rec.u32(32 + i*4))
+			if n > len(points) {
+				n = len(points)
+			}
+			figures = append(figures, emfFigure{r.transformAll(points[:n]), closed})
+			points = points[n:]
+		}
+		r.draw(figures, closed)
+	case emrLineTo:
+		p := rec.point32(8)
+		r.lineTo([]point{r.transform(p)}, []point{p})
+	case emrMoveToEx:
+		r.dc.position = rec.point32(8)
+		if r.inPath {
+			r.path = append(r.path, emfFigure{points: []point{r.transform(r.dc.position)}})
+		}
+	case emrRectangle:
+		box := r.box(rec, 8)
+		r.draw([]emfFigure{{r.transformAll([]point{{box[0], box[1]}, {box[2], box[1]}, {box[2], box[3]}, {box[0], box[3]}}), true}}, true)
+	case emrRoundRect:
+		r.draw([]emfFigure{{r.transformAll(r.roundRectPoints(r.box(rec, 8), rec.i32(24), rec.i32(28))), true}}, true)
+	case emrEllipse:
+		box := r.box(rec, 8)
+		r.draw([]emfFigure{{r.transformAll(r.arcPoints(box, 0, 2*math.Pi, false)), true}}, true)
+	case emrArc, emrChord, emrPie, emrArcTo:
+		box := r.box(rec, 8)
+		start, sweep := r.arcAngles(box, rec.point32(24), rec.point32(32))
+		points := r.arcPoints(box, start, sweep, true)
+		switch recordType {
+		case emrArc:
+			r.draw([]emfFigure{{r.transformAll(points), false}}, false)
+		case emrChord:
+			r.draw([]emfFigure{{r.transformAll(points), true}}, true)
+		case emrPie:
+			center := point{(box[0] + box[2]) / 2, (box[1] + box[3]) / 2}
+			r.draw([]emfFigure{{r.transformAll(append(points, center)), true}}, true)
+		case emrArcTo:
+			r.lineTo(r.transformAll(points), points)
+		}
+	case emrAngleArc:
+		center, radius := rec.point32(8), float64(rec.u32(16))
+		start, sweep := rec.f32(20)*math.Pi/180, rec.f32(24)*math.Pi/180
+		// Angles are counterclockwise with y up, so negate them for y-down logical space
+		box := [4]float64{center.x - radius, center.y - radius, center.x + radius, center.y + radius}
+		points := r.arcPoints(box, -start, sweep, true)
+		r.lineTo(r.transformAll(points), points)
+	case emrBeginPath:
+		r.inPath = true
+		r.path = nil
+	case emrEndPath:
+		r.inPath = false
+	case emrAbortPath:
+		r.inPath = false
+		r.path = nil
+	case emrCloseFigure:
+		if len(r.path) > 0 {
+			r.path[len(r.path)-1].closed = true
+		}
+	case emrFillPath, emrStrokeAndFillPath, emrStrokePath:
+		path := r.path
+		r.path = nil
+		pen := r.dc.pen
+		if recordType == emrFillPath {
+			r.dc.pen.null = true
+		}
+		r.paint(path, recordType != emrStrokePath)
+		r.dc.pen = pen
+	case emrSelectClipPath:
+		r.dc.clip = r.dc.clip.Intersect(figureBounds(r.path))
+		r.path = nil
+	case emrIntersectClipRect:
+		box := r.box(rec, 8)
+		r.dc.clip = r.dc.clip.Intersect(figureBounds([]emfFigure{{points: r.transformAll([]point{{box[0], box[1]}, {box[2], box[1]}, {box[2], box[3]}, {box[0], box[3]}})}}))
+	case emrExtSelectClipRgn:
+		// Only copying a region is supported; its bounding box is used as the clip
+		if rec.u32(12) == 5 {
+			r.dc.clip = r.raster.img.Bounds()
+			if rec.u32(8) >= 32 {
+				bounds := []point{r.output.applyPoint(rec.point32(32)), r.output.applyPoint(rec.point32(40))}
+				r.dc.clip = r.dc.clip.Intersect(figureBounds([]emfFigure{{points: bounds}}))
+			}
+		}
+	case emrSaveDC:
+		r.saved = append(r.saved, r.dc)
+	case emrRestoreDC:
+		n := len(r.saved) + int(int32(rec.u32(8)))
+		if n >= 0 && n < len(r.saved) {
+			r.dc = r.saved[n]
+			r.saved = r.saved[:n]
+		}
+	case emrSetWorldTransform:
+		r.dc.world = r.xform(rec, 8)
+	case emrModifyWorldTransform:
+		switch rec.u32(32) {
+		case 1:
+			r.dc.world = identityTransform()
+		case 2:
+			r.dc.world = r.dc.world.then(r.xform(rec, 8))
+		case 3:
+			r.dc.world = r.xform(rec, 8).then(r.dc.world)
+		case 4:
+			r.dc.world = r.xform(rec, 8)
+		}
+	case emrSetMapMode:
+		r.dc.mapMode = rec.u32(8)
+	case emrSetWindowExtEx:
+		r.dc.windowExt = rec.point32(8)
+	case emrSetWindowOrgEx:
+		r.dc.windowOrg = rec.point32(8)
+	case emrSetViewportExtEx:
+		r.dc.viewportExt = rec.point32(8)
+	case emrSetViewportOrgEx:
+		r.dc.viewportOrg = rec.point32(8)
+	case emrScaleViewportExtEx, emrScaleWindowExtEx:
+		ext := &r.dc.viewportExt
+		if recordType == emrScaleWindowExtEx {
+			ext = &r.dc.windowExt
+		}
+		if rec.i32(12) != 0 && rec.i32(20) != 0 {
+			ext.x = ext.x * rec.i32(8) / rec.i32(12)
+			ext.y = ext.y * rec.i32(16) / rec.i32(20)
+		}
+	case emrSetPolyFillMode:
+		r.dc.evenOdd = rec.u32(8) != 2
+	case emrSetArcDirection:
+		r.dc.clockwise = rec.u32(8) == 2
+	case emrCreatePen:
+		style := rec.u32(12)
+		r.objects[rec.u32(8)] = emfObject{pen: &emfPen{
+			null:     style&0x0F == 5,
+			cosmetic: rec.i32(16) == 0,
+			width:    rec.i32(16),
+			color:    rec.color(24),
+		}}
+	case emrExtCreatePen:
+		style := rec.u32(28)
+		r.objects[rec.u32(8)] = emfObject{pen: &emfPen{
+			null:     style&0x0F == 5 || rec.u32(36) == 1,
+			cosmetic: style&0x00010000 == 0 || rec.u32(32) == 0,
+			width:    float64(rec.u32(32)),
+			color:    rec.color(40),
+		}}
+	case emrCreateBrushIndirect:
+		r.objects[rec.u32(8)] = emfObject{brush: &emfBrush{
+			null:  rec.u32(12) == 1,
+			color: rec.color(16),
+		}}
+	case emrCreateMonoBrush, emrCreateDIBPatternBrushPt:
+		r.objects[rec.u32(8)] = emfObject{brush: &emfBrush{color: color.RGBA{R: 128, G: 128, B: 128, A: 255}}}
+	case emrSelectObject:
+		r.selectObject(rec.u32(8))
+	case emrDeleteObject:
+		delete(r.objects, rec.u32(8))
+	case emrStretchDIBits:
+		dest := [4]float64{rec.i32(24), rec.i32(28), rec.i32(72), rec.i32(76)}
+		src := [4]float64{rec.i32(32), rec.i32(36), rec.i32(40), rec.i32(44)}
+		r.drawBitmap(rec, dest, src, 48)
+	case emrBitBlt, emrStretchBlt:
+		dest := [4]float64{rec.i32(24), rec.i32(28), rec.i32(32), rec.i32(36)}
+		if rec.u32(88) == 0 {
+			// Without a source bitmap the operation fills with the brush
+			r.fillRect(dest)
+			return
+		}
+		src := [4]float64{rec.i32(44), rec.i32(48), dest[2], dest[3]}
+		if recordType == emrStretchBlt {
+			src[2], src[3] = rec.i32(100), rec.i32(104)
+		}
+		r.drawBitmap(rec, dest, src, 84)
+	}
+}
+
+// selectObject selects a created or stock object into the device context
+func (r *emfRenderer) selectObject(handle uint32) {
+	if handle&0x80000000 == 0 {
+		object := r.objects[handle]
+		if object.pen != nil {
+			r.dc.pen = *object.pen
+		}
+		if object.brush != nil {
+			r.dc.brush = *object.brush
+		}
+		return
+	}
+	gray := func(v uint8) color.RGBA { return color.RGBA{R: v, G: v, B: v, A: 255} }
+	switch handle & 0x7FFFFFFF {
+	case 0:
+		r.dc.brush = emfBrush{color: gray(255)}
+	case 1:
+		r.dc.brush = emfBrush{color: gray(192)}
+	case 2:
+		r.dc.brush = emfBrush{color: gray(128)}
+	case 3:
+		r.dc.brush = emfBrush{color: gray(64)}
+	case 4:
+		r.dc.brush = emfBrush{color: gray(0)}
+	case 5:
+		r.dc.brush = emfBrush{null: true}
+	case 6:
+		r.dc.pen = emfPen{cosmetic: true, color: gray(255)}
+	case 7:
+		r.dc.pen = emfPen{cosmetic: true, color: gray(0)}
+	case 8:
+		r.dc.pen = emfPen{null: true}
+	}
+}
+
+// pageTransform maps logical units to device pixels according to the
+// mapping mode
+func (r *emfRenderer) pageTransform() transform {
+	dc := &r.dc
+	var sx, sy float64
+	switch dc.mapMode {
+	case 2, 3, 4, 5, 6:
+		// Fixed mapping modes have y pointing up
+		mm := map[uint32]float64{2: 0.1, 3: 0.01, 4: 0.254, 5: 0.0254, 6: 25.4 / 1440}[dc.mapMode]
+		sx, sy = mm*r.pixelsPerMM.x, -mm*r.pixelsPerMM.y
+	case 7, 8:
+		if dc.windowExt.x == 0 || dc.windowExt.y == 0 {
+			return identityTransform()
+		}
+		sx, sy = dc.viewportExt.x/dc.windowExt.x, dc.viewportExt.y/dc.windowExt.y
+		if dc.mapMode == 7 {
+			m := math.Min(math.Abs(sx), math.Abs(sy))
+			sx, sy = math.Copysign(m, sx), math.Copysign(m, sy)
+		}
+	default:
+		return identityTransform()
+	}
+	return transform{
+		a: sx,
+		d: sy,
+		e: dc.viewportOrg.x - dc.windowOrg.x*sx,
+		f: dc.viewportOrg.y - dc.windowOrg.y*sy,
+	}
+}
+
+// fullTransform maps logical units to output pixels
+func (r *emfRenderer) fullTransform() transform {
+	return r.output.then(r.pageTransform()).then(r.dc.world)
+}
+
+// transform maps a logical point to output pixels
+func (r *emfRenderer) transform(p point) point {
+	return r.fullTransform().applyPoint(p)
+}
+
+// transformAll maps logical points to output pixels
+func (r *emfRenderer) transformAll(points []point) []point {
+	t := r.fullTransform()
+	mapped := make([]point, len(points))
+	for i, p := range points {
+		mapped[i] = t.applyPoint(p)
+	}
+	return mapped
+}
+
+// applyPoint maps a point through the transform
+func (t transform) applyPoint(p point) point {
+	x, y := t.apply(p.x, p.y)
+	return point{x, y}
+}
+
+// xform reads an XFORM structure
+func (r *emfRenderer) xform(rec emfRecord, offset int) transform {
+	return transform{
+		a: rec.f32(offset),
+		b: rec.f32(offset + 4),
+		c: rec.f32(offset + 8),
+		d: rec.f32(offset + 12),
+		e: rec.f32(offset + 16),
+		f: rec.f32(offset + 20),
+	}
+}
+
+// box reads a RECTL as left, top, right, bottom
+func (r *emfRenderer) box(rec emfRecord, offset int) [4]float64 {
+	return [4]float64{rec.i32(offset), rec.i32(offset + 4), rec.i32(offset + 8), rec.i32(offset + 12)}
+}
+
+// bezierFigure flattens a sequence of cubic Bézier segments in logical
+// units into output pixels, starting with the start point
+func (r *emfRenderer) bezierFigure(start point, controls []point) []point {
+	mapped := r.transformAll(append([]point{start}, controls...))
+	figure := []point{mapped[0]}
+	for i := 1; i+2 < len(mapped); i += 3 {
+		figure = append(figure, bezierPoints(mapped[i-1], mapped[i], mapped[i+1], mapped[i+2])...)
+	}
+	return figure
+}
+
+// arcAngles returns the start angle and sweep of an arc of the ellipse in
+// box running from the radial through start to the radial through end in
+// the current arc direction
+func (r *emfRenderer) arcAngles(box [4]float64, start, end point) (float64, float64) {
+	cx, cy := (box[0]+box[2])/2, (box[1]+box[3])/2
+	rx, ry := math.Max(math.Abs(box[2]-box[0])/2, 1e-9), math.Max(math.Abs(box[3]-box[1])/2, 1e-9)
+	a0 := math.Atan2((start.y-cy)/ry, (start.x-cx)/rx)
+	a1 := math.Atan2((end.y-cy)/ry, (end.x-cx)/rx)
+
+	// Counterclockwise on the device is decreasing angles unless the
+	// mapping flips the y axis
+	t := r.fullTransform()
+	decreasing := t.a*t.d-t.b*t.c > 0
+	if r.dc.clockwise {
+		decreasing = !decreasing
+	}
+	var sweep float64
+	if decreasing {
+		sweep = -math.Mod(a0-a1+4*math.Pi, 2*math.Pi)
+	} else {
+		sweep = math.Mod(a1-a0+4*math.Pi, 2*math.Pi)
+	}
+	if sweep == 0 {
+		sweep = 2 * math.Pi
+		if decreasing {
+			sweep = -sweep
+		}
+	}
+	return a0, sweep
+}
+
+// arcPoints returns points along the ellipse in box from the start angle
+// through the sweep, in logical units. Closed ellipses omit the end point.
+func (r *emfRenderer) arcPoints(box [4]float64, start, sweep float64, includeEnd bool) []point {
+	cx, cy := (box[0]+box[2])/2, (box[1]+box[3])/2
+	rx, ry := math.Abs(box[2]-box[0])/2, math.Abs(box[3]-box[1])/2
+	t := r.fullTransform()
+	radius := (rx + ry) / 2 * math.Sqrt(math.Abs(t.a*t.d-t.b*t.c))
+	n := int(math.Max(8, math.Min(256, radius*math.Abs(sweep)/2)))
+	count := n
+	if includeEnd {
+		count++
+	}
+	points := make([]point, count)
+	for i := range points {
+		sin, cos := math.Sincos(start + sweep*float64(i)/float64(n))
+		points[i] = point{cx + rx*cos, cy + ry*sin}
+	}
+	return points
+}
+
+// roundRectPoints returns the outline of a rectangle with corners rounded
+// by ellipses of the given size, in logical units
+func (r *emfRenderer) roundRectPoints(box [4]float64, width, height float64) []point {
+	rx, ry := math.Abs(width)/2, math.Abs(height)/2
+	corners := []struct{ cx, cy, start float64 }{
+		{box[2] - rx, box[1] + ry, -math.Pi / 2},
+		{box[2] - rx, box[3] - ry, 0},
+		{box[0] + rx, box[3] - ry, math.Pi / 2},
+		{box[0] + rx, box[1] + ry, math.Pi},
+	}
+	points := make([]point, 0)
+	for _, c := range corners {
+		points = append(points, r.arcPoints([4]float64{c.cx - rx, c.cy - ry, c.cx + rx, c.cy + ry}, c.start, math.Pi/2, true)...)
+	}
+	return points
+}
+
+// lineTo continues the current figure from the current position through
+// the given output points, then moves the current position to the last of
+// the corresponding logical points
+func (r *emfRenderer) lineTo(points []point, logical []point) {
+	if len(points) == 0 {
+		return
+	}
+	if r.inPath {
+		if len(r.path) == 0 || r.path[len(r.path)-1].closed {
+			r.path = append(r.path, emfFigure{points: []point{r.transform(r.dc.position)}})
+		}
+		figure := &r.path[len(r.path)-1]
+		figure.points = append(figure.points, points...)
+	} else {
+		r.draw([]emfFigure{{append([]point{r.transform(r.dc.position)}, points...), false}}, false)
+	}
+	r.dc.position = logical[len(logical)-1]
+}
+
+// draw paints figures with the current brush and pen, or adds them to the
+// path while a path is being recorded
+func (r *emfRenderer) draw(figures []emfFigure, fill bool) {
+	if r.inPath {
+		r.path = append(r.path, figures...)
+		return
+	}
+	r.paint(figures, fill)
+}
+
+// paint fills and outlines figures with the current brush and pen
+func (r *emfRenderer) paint(figures []emfFigure, fill bool) {
+	r.raster.clip = r.dc.clip
+	defer func() { r.raster.clip = r.raster.img.Bounds() }()
+
+	if fill && !r.dc.brush.null {
+		polygons := make([][]point, 0, len(figures))
+		for _, figure := range figures {
+			if len(figure.points) >= 3 {
+				polygons = append(polygons, figure.points)
+			}
+		}
+		r.raster.fill(polygons, r.dc.brush.color, r.dc.evenOdd)
+	}
+	if !r.dc.pen.null {
+		lines := make([][]point, 0, len(figures))
+		for _, figure := range figures {
+			line := figure.points
+			if figure.closed && len(line) > 2 {
+				line = append(append([]point(nil), line...), line[0])
+			}
+			lines = append(lines, line)
+		}
+		r.raster.stroke(lines, r.penWidth(), r.dc.pen.color)
+	}
+}
+
+// penWidth returns the width of the current pen in output pixels
+func (r *emfRenderer) penWidth() float64 {
+	if r.dc.pen.cosmetic {
+		return math.Max(1, r.output.a)
+	}
+	t := r.fullTransform()
+	return math.Max(1, r.dc.pen.width*math.Sqrt(math.Abs(t.a*t.d-t.b*t.c)))
+}
+
+// fillRect fills a rectangle given by position and size with the brush
+func (r *emfRenderer) fillRect(dest [4]float64) {
+	if r.dc.brush.null {
+		return
+	}
+	points := r.transformAll([]point{
+		{dest[0], dest[1]}, {dest[0] + dest[2], dest[1]},
+		{dest[0] + dest[2], dest[1] + dest[3]}, {dest[0], dest[1] + dest[3]},
+	})
+	r.raster.clip = r.dc.clip
+	r.raster.fill([][]point{points}, r.dc.brush.color, false)
+	r.raster.clip = r.raster.img.Bounds()
+}
+
+// drawBitmap draws the source rectangle of a record's DIB into the
+// destination rectangle, both given as position and size. Rotation is
+// ignored; the bitmap fills the bounding box of the destination.
+func (r *emfRenderer) drawBitmap(rec emfRecord, dest, src [4]float64, offset int) {
+	offBmi, cbBmi := int(rec.u32(offset)), int(rec.u32(offset+4))
+	offBits, cbBits := int(rec.u32(offset+8)), int(rec.u32(offset+12))
+	if offBmi+cbBmi > len(rec) || offBits+cbBits > len(rec) || cbBmi == 0 {
+		return
+	}
+	bitmap, err := decodeDIB(rec[offBmi:offBmi+cbBmi], rec[offBits:offBits+cbBits])
+	if err != nil {
+		return
+	}
+
+	corners := r.transformAll([]point{
+		{dest[0], dest[1]}, {dest[0] + dest[2], dest[1]},
+		{dest[0] + dest[2], dest[1] + dest[3]}, {dest[0], dest[1] + dest[3]},
+	})
+	target := figureBounds([]emfFigure{{points: corners}})
+	area := target.Intersect(r.dc.clip)
+	if area.Empty() || src[2] == 0 || src[3] == 0 {
+		return
+	}
+
+	// Negative destination sizes mirror the bitmap
+	flipX := (corners[1].x < corners[0].x) != (src[2] < 0)
+	flipY := (corners[3].y < corners[0].y) != (src[3] < 0)
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		fy := (float64(y-target.Min.Y) + 0.5) / float64(target.Dy())
+		if flipY {
+			fy = 1 - fy
+		}
+		sy := int(math.Floor(math.Min(src[1], src[1]+src[3]) + fy*math.Abs(src[3])))
+		for x := area.Min.X; x < area.Max.X; x++ {
+			fx := (float64(x-target.Min.X) + 0.5) / float64(target.Dx())
+			if flipX {
+				fx = 1 - fx
+			}
+			sx := int(math.Floor(math.Min(src[0], src[0]+src[2]) + fx*math.Abs(src[2])))
+			if !(image.Point{sx, sy}.In(bitmap.Bounds())) {
+				continue
+			}
+			c := bitmap.RGBAAt(sx, sy)
+			r.raster.img.SetRGBA(x, y, color.RGBA{R: c.R, G: c.G, B: c.B, A: 255})
+		}
+	}
+}
+
+// figureBounds returns the pixel rectangle covering figures
+func figureBounds(figures []emfFigure) image.Rectangle {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, figure := range figures {
+		for _, p := range figure.points {
+			minX, maxX = math.Min(minX, p.x), math.Max(maxX, p.x)
+			minY, maxY = math.Min(minY, p.y), math.Max(maxY, p.y)
+		}
+	}
+	if minX > maxX {
+		return image.Rectangle{}
+	}
+	return image.Rect(int(math.Floor(minX)), int(math.Floor(minY)), int(math.Ceil(maxX)), int(math.Ceil(maxY)))
+}
+
+// decodeDIB decodes a device-independent bitmap from its BITMAPINFO and
+// pixel data. Uncompressed bitmaps of 1 to 32 bits per pixel and embedded
+// JPEG and PNG images are supported.
+func decodeDIB(bmi, bits []byte) (*image.RGBA, error) {
+	header := emfRecord(bmi)
+	if len(bmi) < 40 {
+		return nil, fmt.Errorf("bitmap header too short")
+	}
+	width, height := int(int32(header.u32(4))), int(int32(header.u32(8)))
+	bitCount := int(binary.LittleEndian.Uint16(bmi[14:]))
+	compression := header.u32(16)
+
+	if compression == 4 || compression == 5 {
+		decoded, _, err := image.Decode(bytes.NewReader(bits))
+		if err != nil {
+			return nil, err
+		}
+		img := image.NewRGBA(decoded.Bounds())
+		for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+			for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+				img.Set(x, y, decoded.At(x, y))
+			}
+		}
+		return img, nil
+	}
+	if compression != 0 && compression != 3 {
+		return nil, fmt.Errorf("unsupported bitmap compression %d", compression)
+	}
+
+	topDown := height < 0
+	if topDown {
+		height = -height
+	}
+	if width <= 0 || height <= 0 || width*height > 1<<26 {
+		return nil, fmt.Errorf("invalid bitmap size %dx%d", width, height)
+	}
+
+	// Palettes follow the header for bitmaps of up to 8 bits per pixel
+	palette := make([]color.RGBA, 0)
+	if bitCount <= 8 {
+		colors := int(header.u32(32))
+		if colors == 0 {
+			colors = 1 << bitCount
+		}
+		headerSize := int(header.u32(0))
+		for i := 0; i < colors && headerSize+i*4+3 <= len(bmi); i++ {
+			entry := bmi[headerSize+i*4:]
+			palette = append(palette, color.RGBA{R: entry[2], G: entry[1], B: entry[0], A: 255})
+		}
+	}
+
+	stride := (width*bitCount + 31) / 32 * 4
+	if stride*height > len(bits) {
+		return nil, fmt.Errorf("bitmap data too short")
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		row := bits[(height-1-y)*stride:]
+		if topDown {
+			row = bits[y*stride:]
+		}
+		for x := 0; x < width; x++ {
+			var c color.RGBA
+			switch bitCount {
+			case 1, 4, 8:
+				index := int(row[x*bitCount/8]>>(8-bitCount-(x*bitCount%8))) & (1<<bitCount - 1)
+				if index < len(palette) {
+					c = palette[index]
+				}
+			case 16:
+				v := binary.LittleEndian.Uint16(row[x*2:])
+				c = color.RGBA{R: uint8(v>>10&31) * 255 / 31, G: uint8(v>>5&31) * 255 / 31, B: uint8(v&31) * 255 / 31, A: 255}
+			case 24:
+				c = color.RGBA{R: row[x*3+2], G: row[x*3+1], B: row[x*3], A: 255}
+			case 32:
+				c = color.RGBA{R: row[x*4+2], G: row[x*4+1], B: row[x*4], A: 255}
+			default:
+				return nil, fmt.Errorf("unsupported bitmap depth %d", bitCount)
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img, nil
+}
@@ -0,0 +1,187 @@
+package visio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// testEMF builds enhanced metafile records
+type testEMF struct {
+	bytes.Buffer
+}
+
+// record appends a record whose fields are written in little-endian order
+func (m *testEMF) record(recordType uint32, fields ...interface{}) {
+	body := &bytes.Buffer{}
+	for _, f := range fields {
+		binary.Write(body, binary.LittleEndian, f)
+	}
+	binary.Write(m, binary.LittleEndian, []uint32{recordType, uint32(8 + body.Len())})
+	m.Write(body.Bytes())
+}
+
+// header appends a header record with the given inclusive bounds and an
+// empty frame, so the bounds give the picture size in logical units
+func (m *testEMF) header(right, bottom int32) {
+	m.record(emrHeader,
+		[]int32{0, 0, right, bottom}, // bounds
+		[]int32{0, 0, 0, 0},          // frame
+		[]uint32{emfSignature, 0x10000, 0, 0},
+		[]uint16{0, 0},
+		[]uint32{0, 0, 0},
+		[]int32{0, 0, 0, 0}, // device and millimeters
+	)
+}
+
+// buildTestEMF builds a 100x50 metafile with a red rectangle on the left
+// and a green triangle on the right, both without outlines
+func buildTestEMF() []byte {
+	m := &testEMF{}
+	m.header(99, 49)
+	m.record(emrSelectObject, uint32(0x80000008)) // NULL_PEN
+	m.record(emrCreateBrushIndirect, uint32(1), uint32(0), []byte{255, 0, 0, 0}, uint32(0))
+	m.record(emrSelectObject, uint32(1))
+	m.record(emrRectangle, []int32{10, 10, 40, 40})
+	m.record(emrCreateBrushIndirect, uint32(2), uint32(0), []byte{0, 255, 0, 0}, uint32(0))
+	m.record(emrSelectObject, uint32(2))
+	m.record(emrPolygon16, []int32{60, 10, 90, 40}, uint32(3), []int16{60, 10, 90, 10, 75, 40})
+	m.record(emrEOF, []uint32{0, 16, 20})
+	return m.Bytes()
+}
+
+func TestRenderEMF(t *testing.T) {
+	img, err := renderEMF(buildTestEMF(), 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != image.Rect(0, 0, 200, 100) {
+		t.Fatalf("bounds = %v, want 200x100", img.Bounds())
+	}
+
+	white := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	red := color.RGBA{R: 255, A: 255}
+	green := color.RGBA{G: 255, A: 255}
+	tests := []struct {
+		name string
+		x, y int
+		want color.RGBA
+	}{
+		{"background", 5, 5, white},
+		{"rectangle", 50, 50, red},
+		{"rectangle corner", 22, 22, red},
+		{"below the rectangle", 50, 90, white},
+		{"triangle", 150, 40, green},
+		{"beside the triangle tip", 125, 75, white},
+		{"between the shapes", 100, 50, white},
+	}
+	for _, tt := range tests {
+		if got := img.RGBAAt(tt.x, tt.y); got != tt.want {
+			t.Errorf("%s: pixel (%d, %d) = %v, want %v", tt.name, tt.x, tt.y, got, tt.want)
+		}
+	}
+}
+
+func TestRenderEMFInvalid(t *testing.T) {
+	valid := buildTestEMF()
+	withRecord := func(recordType, size uint32) []byte {
+		m := &testEMF{}
+		m.header(99, 49)
+		binary.Write(m, binary.LittleEndian, []uint32{recordType, size})
+		m.Write(make([]byte, 16))
+		return m.Bytes()
+	}
+	emptyFrame := &testEMF{}
+	emptyFrame.header(-1, 49)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short header", valid[:60]},
+		{"not a header", withRecord(emrEOF, 20)[88:]},
+		{"bad signature", func() []byte {
+			b := append([]byte(nil), valid...)
+			b[40] = 0
+			return b
+		}()},
+		{"empty frame", emptyFrame.Bytes()},
+		{"record shorter than its header", withRecord(emrRectangle, 4)},
+		{"record beyond the data", withRecord(emrRectangle, 1000)},
+		{"truncated record", valid[:len(valid)-30]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := renderEMF(tt.data, 100); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestRenderEMFShortRecords(t *testing.T) {
+	// Records too short for their fields read zeros instead of panicking
+	m := &testEMF{}
+	m.header(99, 49)
+	for _, recordType := range []uint32{
+		emrRectangle, emrPolygon16, emrPolyline, emrPolyPolygon16,
+		emrCreateBrushIndirect, emrExtCreatePen, emrStretchDIBits, emrBitBlt,
+	} {
+		m.record(recordType, uint32(1))
+	}
+	m.record(emrPolygon16, []int32{0, 0, 0, 0}, uint32(0xFFFFFFFF), []int16{1, 2})
+	if _, err := renderEMF(m.Bytes(), 100); err != nil {
+		t.Fatal(err)
+	}
+
+	valid := buildTestEMF()
+	for n := 0; n < len(valid); n++ {
+		renderEMF(valid[:n], 100)
+	}
+}
+
+func TestRasterizerFill(t *testing.T) {
+	square := func(x0, y0, x1, y1 float64) []point {
+		return []point{{x0, y0}, {x1, y0}, {x1, y1}, {x0, y1}}
+	}
+	black := color.RGBA{A: 255}
+	tests := []struct {
+		name    string
+		evenOdd bool
+		center  uint8
+	}{
+		{"even-odd leaves a hole", true, 255},
+		{"nonzero fills the hole", false, 0},
+	}
+	for _, tt := range tests {
+		img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+		for i := range img.Pix {
+			img.Pix[i] = 255
+		}
+		r := &rasterizer{img: img, clip: img.Bounds()}
+		r.fill([][]point{square(1, 1, 9, 9), square(3, 3, 7, 7)}, black, tt.evenOdd)
+		if got := img.RGBAAt(5, 5).R; got != tt.center {
+			t.Errorf("%s: center = %d, want %d", tt.name, got, tt.center)
+		}
+		if got := img.RGBAAt(2, 2).R; got != 0 {
+			t.Errorf("%s: ring = %d, want 0", tt.name, got)
+		}
+		if got := img.RGBAAt(0, 0).R; got != 255 {
+			t.Errorf("%s: outside = %d, want 255", tt.name, got)
+		}
+	}
+
+	// A pixel half covered by an edge is blended half way
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	r := &rasterizer{img: img, clip: img.Bounds()}
+	r.fill([][]point{square(0, 0, 1.5, 4)}, black, true)
+	if got := img.RGBAAt(1, 1).R; got < 120 || got > 135 {
+		t.Errorf("half covered pixel = %d, want about 128", got)
+	}
+}
@@ -0,0 +1,94 @@
+package visio
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// foreignMIMETypes gives the MIME type of foreign data parts whose content
+// type is missing or generic, by file extension
+var foreignMIMETypes = map[string]string{
+	"png":  "image/png",
+	"jpg":  "image/jpeg",
+	"jpeg": "image/jpeg",
+	"gif":  "image/gif",
+	"bmp":  "image/bmp",
+	"tif":  "image/tiff",
+	"tiff": "image/tiff",
+	"emf":  "image/x-emf",
+	"wmf":  "image/x-wmf",
+	"svg":  "image/svg+xml",
+	"bin":  "application/vnd.openxmlformats-officedocument.oleObject",
+}
+
+// resolveForeignData locates the part holding the data of a foreign shape.
+// source is the page or master part whose relationships the data refers to.
+func (p *vsdxPackage) resolveForeignData(fd *xmlForeignData, source string) (*ForeignData, error) {
+	foreign := &ForeignData{
+		Type:        fd.ForeignType,
+		Compression: fd.CompressionType,
+		ObjectType:  fd.ObjectType,
+	}
+	if fd.Rel.ID == "" {
+		return foreign, nil
+	}
+
+	rels, err := p.relationships(source)
+	if err != nil {
+		return nil, err
+	}
+	part, ok := relationshipByID(rels, fd.Rel.ID)
+	if !ok {
+		return foreign, nil
+	}
+	file := findZipFile(p.zip, part)
+	if file == nil {
+		return foreign, nil
+	}
+
+	foreign.Part = file.Name
+	foreign.MIMEType = p.contentType(file.Name)
+	if foreign.MIMEType == "" || foreign.MIMEType == "application/octet-stream" {
+		if mimeType, ok := foreignMIMETypes[strings.ToLower(strings.TrimPrefix(path.Ext(file.Name), "."))]; ok {
+			foreign.MIMEType = mimeType
+		}
+	}
+	foreign.Size = int64(file.UncompressedSize64)
+	return foreign, nil
+}
+
+// ReadForeignData returns the data of a foreign shape's image or embedded
+// object together with its description
+func (r *Reader) ReadForeignData(pageName, shapeID string) ([]byte, *ForeignData, error) {
+	pkg, err := r.openPackage()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entry, ok := pkg.findPage(pageName)
+	if !ok {
+		return nil, nil, fmt.Errorf("page not found: %s", pageName)
+	}
+	page, err := r.readPage(pkg, entry)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read page %s: %w", pageName, err)
+	}
+
+	shape, ok := FindShape(page.Shapes, shapeID)
+	if !ok {
+		return nil, nil, fmt.Errorf("shape not found: %s", shapeID)
+	}
+	if shape.Foreign == nil {
+		return nil, nil, fmt.Errorf("shape %s has no foreign data", shapeID)
+	}
+	if shape.Foreign.Part == "" {
+		return nil, nil, fmt.Errorf("foreign data of shape %s is not stored in the package", shapeID)
+	}
+
+	data, err := readZipFile(pkg.zip, shape.Foreign.Part)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, shape.Foreign, nil
+}
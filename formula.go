@@ -0,0 +1,1040 @@
+package visio
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The evaluator below covers the subset of the ShapeSheet formula language
+// that shapes written by this package and most stencils rely on:
+// arithmetic, comparison and concatenation operators, numbers with unit
+// suffixes, strings, GUARD, IF and the common math and string functions,
+// and references to cells of the same shape, of other shapes (Sheet.5!Width),
+// of the page (ThePage!PageWidth) and of the document (TheDoc!...).
+// Formulas outside the subset fail to evaluate and keep their cached value.
+
+// formulaValue is the result of evaluating a formula. Numbers are in
+// internal units: inches for lengths and radians for angles.
+type formulaValue struct {
+	num   float64
+	str   string
+	isStr bool
+}
+
+// numberValue returns a numeric formula value
+func numberValue(v float64) formulaValue {
+	return formulaValue{num: v}
+}
+
+// stringValue returns a string formula value
+func stringValue(s string) formulaValue {
+	return formulaValue{str: s, isStr: true}
+}
+
+// boolValue returns TRUE or FALSE as 1 or 0
+func boolValue(b bool) formulaValue {
+	if b {
+		return numberValue(1)
+	}
+	return numberValue(0)
+}
+
+// number converts the value to a number. Empty strings count as 0.
+func (v formulaValue) number() (float64, error) {
+	if !v.isStr {
+		return v.num, nil
+	}
+	s := strings.TrimSpace(v.str)
+	switch strings.ToUpper(s) {
+	case "", "FALSE":
+		return 0, nil
+	case "TRUE":
+		return 1, nil
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", v.str)
+	}
+	return n, nil
+}
+
+// text converts the value to a string
+func (v formulaValue) text() string {
+	if v.isStr {
+		return v.str
+	}
+	return formatFormulaNumber(v.num)
+}
+
+// formatFormulaNumber formats a number the way cached values are written:
+// 15 significant digits, no exponent
+func formatFormulaNumber(v float64) string {
+	if rounded, err := strconv.ParseFloat(strconv.FormatFloat(v, 'g', 15, 64), 64); err == nil {
+		v = rounded
+	}
+	if v == 0 {
+		return "0"
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// formulaUnits converts the unit suffixes of numeric literals to internal units
+var formulaUnits = map[string]float64{
+	"in":     1,
+	"in.":    1,
+	"inch":   1,
+	"inches": 1,
+	"ft":     12,
+	"ft.":    12,
+	"yd":     36,
+	"mi":     63360,
+	"mm":     1 / unitsPerInch[UnitMillimeters],
+	"cm":     1 / unitsPerInch[UnitCentimeters],
+	"m":      1 / 0.0254,
+	"km":     1000 / 0.0254,
+	"pt":     1 / unitsPerInch[UnitPoints],
+	"deg":    math.Pi / 180,
+	"rad":    1,
+}
+
+// formulaTokenKind classifies formula tokens
+type formulaTokenKind int
+
+const (
+	tokenEnd formulaTokenKind = iota
+	tokenNumber
+	tokenString
+	tokenName
+	tokenOperator
+)
+
+// formulaToken is a lexical token of a formula
+type formulaToken struct {
+	kind  formulaTokenKind
+	text  string // Operator, name or string literal
+	sheet string // Qualifier of a reference written as Sheet!Cell
+	num   float64
+}
+
+// tokenizeFormula splits a formula into tokens. Numeric literals with a
+// unit suffix are converted to internal units.
+func tokenizeFormula(formula string) ([]formulaToken, error) {
+	runes := []rune(formula)
+	tokens := make([]formulaToken, 0)
+	isNameStart := func(r rune) bool { return unicode.IsLetter(r) || r == '_' }
+	isNamePart := func(r rune) bool { return isNameStart(r) || unicode.IsDigit(r) || r == '.' }
+	readName := func(i int) (string, int) {
+		start := i
+		for i < len(runes) && isNamePart(runes[i]) {
+			i++
+		}
+		return string(runes[start:i]), i
+	}
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsDigit(r) || (r == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			if i < len(runes) && (runes[i] == 'e' || runes[i] == 'E') {
+				j := i + 1
+				if j < len(runes) && (runes[j] == '+' || runes[j] == '-') {
+					j++
+				}
+				if j < len(runes) && unicode.IsDigit(runes[j]) {
+					for j < len(runes) && unicode.IsDigit(runes[j]) {
+						j++
+					}
+					i = j
+				}
+			}
+			n, err := strconv.ParseFloat(string(runes[start:i]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q", string(runes[start:i]))
+			}
+			// A unit suffix may follow, separated by optional spaces
+			j := i
+			for j < len(runes) && runes[j] == ' ' {
+				j++
+			}
+			if j < len(runes) && unicode.IsLetter(runes[j]) {
+				unit, end := readName(j)
+				if factor, ok := formulaUnits[strings.ToLower(unit)]; ok && (end == len(runes) || (runes[end] != '(' && runes[end] != '!')) {
+					n *= factor
+					i = end
+				}
+			}
+			tokens = append(tokens, formulaToken{kind: tokenNumber, num: n})
+		case r == '"':
+			text := strings.Builder{}
+			i++
+			for {
+				if i >= len(runes) {
+					return nil, fmt.Errorf("unterminated string")
+				}
+				if runes[i] == '"' {
+					if i+1 < len(runes) && runes[i+1] == '"' {
+						text.WriteRune('"')
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				text.WriteRune(runes[i])
+				i++
+			}
+			tokens = append(tokens, formulaToken{kind: tokenString, text: text.String()})
+		case isNameStart(r) || r == '\'':
+			var sheet, name string
+			if r == '\'' {
+				end := i + 1
+				for end < len(runes) && runes[end] != '\'' {
+					end++
+				}
+				if end >= len(runes) {
+					return nil, fmt.Errorf("unterminated sheet name")
+				}
+				sheet = string(runes[i+1 : end])
+				i = end + 1
+				if i >= len(runes) || runes[i] != '!' {
+					return nil, fmt.Errorf("expected ! after '%s'", sheet)
+				}
+			} else {
+				name, i = readName(i)
+			}
+			if i < len(runes) && runes[i] == '!' {
+				if sheet == "" {
+					sheet = name
+				}
+				i++
+				if i >= len(runes) || !isNameStart(runes[i]) {
+					return nil, fmt.Errorf("expected cell name after %s!", sheet)
+				}
+				name, i = readName(i)
+			}
+			tokens = append(tokens, formulaToken{kind: tokenName, text: name, sheet: sheet})
+		default:
+			op := string(r)
+			if i+1 < len(runes) {
+				switch two := string(runes[i : i+2]); two {
+				case "<>", "<=", ">=":
+					op = two
+				}
+			}
+			if !strings.Contains("+-*/^&=<>(),%", string(r)) {
+				return nil, fmt.Errorf("unexpected character %q", r)
+			}
+			tokens = append(tokens, formulaToken{kind: tokenOperator, text: op})
+			i += len(op)
+		}
+	}
+	return append(tokens, formulaToken{kind: tokenEnd}), nil
+}
+
+// formulaExpr is a node of a parsed formula. Op is "num", "str", "ref",
+// "call", "neg", "pct" or a binary operator. References and calls may be
+// qualified by a sheet.
+type formulaExpr struct {
+	op    string
+	value formulaValue
+	name  string
+	sheet string
+	args  []*formulaExpr
+}
+
+// formulaParser is a recursive descent parser over formula tokens.
+// Precedence from lowest to highest: comparison, &, + -, * /, unary
+// minus, ^, %.
+type formulaParser struct {
+	tokens []formulaToken
+	pos    int
+}
+
+// parseFormula parses a formula into an expression tree
+func parseFormula(formula string) (*formulaExpr, error) {
+	tokens, err := tokenizeFormula(formula)
+	if err != nil {
+		return nil, err
+	}
+	p := &formulaParser{tokens: tokens}
+	expr, err := p.comparison()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokenEnd {
+		return nil, fmt.Errorf("unexpected %s", p.describe(p.peek()))
+	}
+	return expr, nil
+}
+
+func (p *formulaParser) peek() formulaToken {
+	return p.tokens[p.pos]
+}
+
+func (p *formulaParser) next() formulaToken {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEnd {
+		p.pos++
+	}
+	return t
+}
+
+// acceptOperator consumes the next token if it is one of the given operators
+func (p *formulaParser) acceptOperator(ops ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != tokenOperator {
+		return "", false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *formulaParser) describe(t formulaToken) string {
+	switch t.kind {
+	case tokenEnd:
+		return "end of formula"
+	case tokenNumber:
+		return "number"
+	case tokenString:
+		return fmt.Sprintf("string %q", t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// binary parses a left-associative chain of operators
+func (p *formulaParser) binary(operand func() (*formulaExpr, error), ops ...string) (*formulaExpr, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.acceptOperator(ops...)
+		if !ok {
+			return left, nil
+		}
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = &formulaExpr{op: op, args: []*formulaExpr{left, right}}
+	}
+}
+
+func (p *formulaParser) comparison() (*formulaExpr, error) {
+	return p.binary(p.concatenation, "=", "<>", "<", ">", "<=", ">=")
+}
+
+func (p *formulaParser) concatenation() (*formulaExpr, error) {
+	return p.binary(p.additive, "&")
+}
+
+func (p *formulaParser) additive() (*formulaExpr, error) {
+	return p.binary(p.multiplicative, "+", "-")
+}
+
+func (p *formulaParser) multiplicative() (*formulaExpr, error) {
+	return p.binary(p.unary, "*", "/")
+}
+
+func (p *formulaParser) unary() (*formulaExpr, error) {
+	if op, ok := p.acceptOperator("-", "+"); ok {
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		if op == "+" {
+			return operand, nil
+		}
+		return &formulaExpr{op: "neg", args: []*formulaExpr{operand}}, nil
+	}
+	return p.power()
+}
+
+func (p *formulaParser) power() (*formulaExpr, error) {
+	base, err := p.postfix()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.acceptOperator("^"); ok {
+		exponent, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &formulaExpr{op: "^", args: []*formulaExpr{base, exponent}}, nil
+	}
+	return base, nil
+}
+
+func (p *formulaParser) postfix() (*formulaExpr, error) {
+	expr, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.acceptOperator("%"); !ok {
+			return expr, nil
+		}
+		expr = &formulaExpr{op: "pct", args: []*formulaExpr{expr}}
+	}
+}
+
+func (p *formulaParser) primary() (*formulaExpr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenNumber:
+		return &formulaExpr{op: "num", value: numberValue(t.num)}, nil
+	case tokenString:
+		return &formulaExpr{op: "str", value: stringValue(t.text)}, nil
+	case tokenName:
+		if _, ok := p.acceptOperator("("); ok {
+			// Functions can be qualified by a sheet, as in Sheet.5!SheetRef()
+			expr, err := p.call(t.text)
+			if err != nil {
+				return nil, err
+			}
+			expr.sheet = t.sheet
+			return expr, nil
+		}
+		if t.sheet == "" {
+			switch strings.ToUpper(t.text) {
+			case "TRUE":
+				return &formulaExpr{op: "num", value: boolValue(true)}, nil
+			case "FALSE":
+				return &formulaExpr{op: "num", value: boolValue(false)}, nil
+			}
+		}
+		return &formulaExpr{op: "ref", name: t.text, sheet: t.sheet}, nil
+	case tokenOperator:
+		if t.text == "(" {
+			expr, err := p.comparison()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := p.acceptOperator(")"); !ok {
+				return nil, fmt.Errorf("expected ) but found %s", p.describe(p.peek()))
+			}
+			return expr, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected %s", p.describe(t))
+}
+
+// call parses the argument list of a function after its opening parenthesis
+func (p *formulaParser) call(name string) (*formulaExpr, error) {
+	expr := &formulaExpr{op: "call", name: strings.ToUpper(name)}
+	if _, ok := p.acceptOperator(")"); ok {
+		return expr, nil
+	}
+	for {
+		arg, err := p.comparison()
+		if err != nil {
+			return nil, err
+		}
+		expr.args = append(expr.args, arg)
+		if _, ok := p.acceptOperator(")"); ok {
+			return expr, nil
+		}
+		if _, ok := p.acceptOperator(","); !ok {
+			return nil, fmt.Errorf("expected , or ) but found %s", p.describe(p.peek()))
+		}
+	}
+}
+
+// formulaEnv evaluates formulas against the shapes of a page, the page
+// sheet and the document sheet. Referenced cells are recomputed on demand,
+// so formulas can be evaluated in any order. Cells that are part of a
+// reference cycle fail to evaluate; references to cells that fail for
+// other reasons contribute their cached value.
+type formulaEnv struct {
+	shapes  map[string]*xmlSheet // By ID
+	names   map[string]*xmlSheet // By lower-cased name and universal name
+	page    *xmlSheet
+	doc     *xmlSheet
+	values  map[*xmlCell]formulaValue
+	pending map[*xmlCell]bool
+	// Whether each formula being evaluated comes from a master. Shape
+	// references in master formulas address master shapes, not page shapes.
+	fromMaster []bool
+}
+
+// newFormulaEnv creates an evaluation environment. The page and document
+// sheets may be nil.
+func newFormulaEnv(shapes []*xmlShape, page, doc *xmlSheet) *formulaEnv {
+	env := &formulaEnv{
+		shapes:  make(map[string]*xmlSheet, len(shapes)),
+		names:   make(map[string]*xmlSheet, len(shapes)),
+		page:    page,
+		doc:     doc,
+		values:  make(map[*xmlCell]formulaValue),
+		pending: make(map[*xmlCell]bool),
+	}
+	for _, shape := range shapes {
+		env.shapes[shape.ID] = &shape.xmlSheet
+		for _, name := range []string{shape.Name, shape.NameU} {
+			if name != "" {
+				env.names[strings.ToLower(name)] = &shape.xmlSheet
+			}
+		}
+	}
+	return env
+}
+
+// formula returns the formula a cell's value is computed from, or an empty
+// string when the value is a constant
+func (c *xmlCell) formula() string {
+	f := c.F
+	if isInheritedFormula(f) {
+		f = c.inheritedF
+	}
+	f = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(f), "="))
+	if strings.EqualFold(f, "No Formula") {
+		return ""
+	}
+	return f
+}
+
+// cachedValue returns the value stored in the V attribute of a cell
+func cachedValue(c *xmlCell) formulaValue {
+	if c.U == "STR" {
+		return stringValue(c.V)
+	}
+	if n, err := strconv.ParseFloat(c.V, 64); err == nil {
+		return numberValue(n)
+	}
+	return stringValue(c.V)
+}
+
+// errCircularReference is returned for a formula that depends on its own
+// value
+var errCircularReference = errors.New("circular reference")
+
+// evaluateCell returns the value of a cell in the given sheet. The boolean
+// is true when the value was recomputed from the cell's formula; otherwise
+// the cached value is returned, with the error if the formula failed.
+func (env *formulaEnv) evaluateCell(sheet *xmlSheet, c *xmlCell) (formulaValue, bool, error) {
+	if v, ok := env.values[c]; ok {
+		return v, true, nil
+	}
+	formula := c.formula()
+	if formula == "" {
+		return cachedValue(c), false, nil
+	}
+	if env.pending[c] {
+		return cachedValue(c), false, fmt.Errorf("%w to %s", errCircularReference, c.N)
+	}
+	env.pending[c] = true
+	env.fromMaster = append(env.fromMaster, c.inherited || isInheritedFormula(c.F))
+	v, err := env.evaluate(formula, sheet)
+	env.fromMaster = env.fromMaster[:len(env.fromMaster)-1]
+	delete(env.pending, c)
+	if err != nil {
+		return cachedValue(c), false, err
+	}
+	env.values[c] = v
+	return v, true, nil
+}
+
+// evaluate evaluates a formula in the context of a sheet
+func (env *formulaEnv) evaluate(formula string, sheet *xmlSheet) (formulaValue, error) {
+	expr, err := parseFormula(formula)
+	if err != nil {
+		return formulaValue{}, err
+	}
+	return env.eval(expr, sheet)
+}
+
+// eval evaluates an expression tree
+func (env *formulaEnv) eval(e *formulaExpr, sheet *xmlSheet) (formulaValue, error) {
+	switch e.op {
+	case "num", "str":
+		return e.value, nil
+	case "ref":
+		return env.reference(sheet, e.sheet, e.name)
+	case "call":
+		return env.call(e, sheet)
+	}
+
+	args := make([]formulaValue, len(e.args))
+	for i, arg := range e.args {
+		v, err := env.eval(arg, sheet)
+		if err != nil {
+			return formulaValue{}, err
+		}
+		args[i] = v
+	}
+
+	switch e.op {
+	case "&":
+		return stringValue(args[0].text() + args[1].text()), nil
+	case "=", "<>", "<", ">", "<=", ">=":
+		return boolValue(compareValues(e.op, args[0], args[1])), nil
+	}
+
+	nums, err := numbers(args)
+	if err != nil {
+		return formulaValue{}, err
+	}
+	switch e.op {
+	case "neg":
+		return numberValue(-nums[0]), nil
+	case "pct":
+		return numberValue(nums[0] / 100), nil
+	case "+":
+		return numberValue(nums[0] + nums[1]), nil
+	case "-":
+		return numberValue(nums[0] - nums[1]), nil
+	case "*":
+		return numberValue(nums[0] * nums[1]), nil
+	case "/":
+		if nums[1] == 0 {
+			return formulaValue{}, fmt.Errorf("division by zero")
+		}
+		return numberValue(nums[0] / nums[1]), nil
+	case "^":
+		return numberValue(math.Pow(nums[0], nums[1])), nil
+	}
+	return formulaValue{}, fmt.Errorf("unknown operator %s", e.op)
+}
+
+// compareValues compares two values numerically when both are numbers,
+// and as case-insensitive strings otherwise
+func compareValues(op string, a, b formulaValue) bool {
+	x, errA := a.number()
+	y, errB := b.number()
+	cmp := 0
+	if errA == nil && errB == nil {
+		switch {
+		case x < y:
+			cmp = -1
+		case x > y:
+			cmp = 1
+		}
+	} else {
+		cmp = strings.Compare(strings.ToLower(a.text()), strings.ToLower(b.text()))
+	}
+	switch op {
+	case "=":
+		return cmp == 0
+	case "<>":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case ">":
+		return cmp > 0
+	case "<=":
+		return cmp <= 0
+	}
+	return cmp >= 0
+}
+
+// numbers converts values to numbers
+func numbers(values []formulaValue) ([]float64, error) {
+	nums := make([]float64, len(values))
+	for i, v := range values {
+		n, err := v.number()
+		if err != nil {
+			return nil, err
+		}
+		nums[i] = n
+	}
+	return nums, nil
+}
+
+// call evaluates a function call. IF, AND and OR only evaluate the
+// arguments they need; DEPENDSON does not evaluate its arguments at all.
+func (env *formulaEnv) call(e *formulaExpr, sheet *xmlSheet) (formulaValue, error) {
+	if e.sheet != "" {
+		return formulaValue{}, fmt.Errorf("unsupported function %s!%s", e.sheet, e.name)
+	}
+	arg := func(i int) (formulaValue, error) {
+		return env.eval(e.args[i], sheet)
+	}
+	truth := func(i int) (bool, error) {
+		v, err := arg(i)
+		if err != nil {
+			return false, err
+		}
+		n, err := v.number()
+		return n != 0, err
+	}
+
+	switch e.name {
+	case "GUARD", "THEMEGUARD":
+		if len(e.args) != 1 {
+			return formulaValue{}, fmt.Errorf("%s takes 1 argument", e.name)
+		}
+		return arg(0)
+	case "IF":
+		if len(e.args) < 2 || len(e.args) > 3 {
+			return formulaValue{}, fmt.Errorf("IF takes 2 or 3 arguments")
+		}
+		cond, err := truth(0)
+		if err != nil {
+			return formulaValue{}, err
+		}
+		if cond {
+			return arg(1)
+		}
+		if len(e.args) == 3 {
+			return arg(2)
+		}
+		return boolValue(false), nil
+	case "AND", "OR":
+		for i := range e.args {
+			t, err := truth(i)
+			if err != nil {
+				return formulaValue{}, err
+			}
+			if t == (e.name == "OR") {
+				return boolValue(t), nil
+			}
+		}
+		return boolValue(e.name == "AND"), nil
+	case "DEPENDSON":
+		return numberValue(0), nil
+	}
+
+	fn, ok := formulaFunctions[e.name]
+	if !ok {
+		return formulaValue{}, fmt.Errorf("unsupported function %s", e.name)
+	}
+	if len(e.args) < fn.minArgs || (fn.maxArgs >= 0 && len(e.args) > fn.maxArgs) {
+		return formulaValue{}, fmt.Errorf("wrong number of arguments to %s", e.name)
+	}
+	args := make([]formulaValue, len(e.args))
+	for i := range e.args {
+		v, err := arg(i)
+		if err != nil {
+			return formulaValue{}, err
+		}
+		args[i] = v
+	}
+	return fn.eval(args)
+}
+
+// formulaFunction is a function whose arguments are all evaluated before
+// the call. A maxArgs of -1 means any number of arguments.
+type formulaFunction struct {
+	minArgs, maxArgs int
+	eval             func(args []formulaValue) (formulaValue, error)
+}
+
+// mathFunction adapts a function of numeric arguments
+func mathFunction(minArgs, maxArgs int, fn func(x []float64) (float64, error)) formulaFunction {
+	return formulaFunction{minArgs, maxArgs, func(args []formulaValue) (formulaValue, error) {
+		nums, err := numbers(args)
+		if err != nil {
+			return formulaValue{}, err
+		}
+		v, err := fn(nums)
+		if err != nil {
+			return formulaValue{}, err
+		}
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return formulaValue{}, fmt.Errorf("result out of range")
+		}
+		return numberValue(v), nil
+	}}
+}
+
+// unaryFunction adapts a math function of one argument
+func unaryFunction(fn func(float64) float64) formulaFunction {
+	return mathFunction(1, 1, func(x []float64) (float64, error) { return fn(x[0]), nil })
+}
+
+// roundTo rounds x to n decimal places using rounder
+func roundTo(x []float64, rounder func(float64) float64) (float64, error) {
+	if len(x) == 1 {
+		return rounder(x[0]), nil
+	}
+	scale := math.Pow(10, math.Trunc(x[1]))
+	return rounder(x[0]*scale) / scale, nil
+}
+
+// roundToMultiple rounds x to a multiple of the optional second argument using rounder
+func roundToMultiple(x []float64, rounder func(float64) float64) (float64, error) {
+	if len(x) == 1 || x[1] == 0 {
+		return rounder(x[0]), nil
+	}
+	return rounder(x[0]/x[1]) * x[1], nil
+}
+
+// formulaFunctions are the supported functions besides GUARD, IF, AND, OR
+// and DEPENDSON
+var formulaFunctions = map[string]formulaFunction{
+	"ABS":   unaryFunction(math.Abs),
+	"SQRT":  unaryFunction(math.Sqrt),
+	"INT":   unaryFunction(math.Floor),
+	"EXP":   unaryFunction(math.Exp),
+	"LN":    unaryFunction(math.Log),
+	"LOG10": unaryFunction(math.Log10),
+	"SIN":   unaryFunction(math.Sin),
+	"COS":   unaryFunction(math.Cos),
+	"TAN":   unaryFunction(math.Tan),
+	"ASIN":  unaryFunction(math.Asin),
+	"ACOS":  unaryFunction(math.Acos),
+	"ATAN":  unaryFunction(math.Atan),
+	"SIGN": unaryFunction(func(x float64) float64 {
+		switch {
+		case x > 0:
+			return 1
+		case x < 0:
+			return -1
+		}
+		return 0
+	}),
+	"RAD":     unaryFunction(func(x float64) float64 { return x * math.Pi / 180 }),
+	"DEG":     unaryFunction(func(x float64) float64 { return x * 180 / math.Pi }),
+	"ANG360":  unaryFunction(func(x float64) float64 { return x - 2*math.Pi*math.Floor(x/(2*math.Pi)) }),
+	"NOT":     unaryFunction(func(x float64) float64 { return boolValue(x == 0).num }),
+	"PI":      mathFunction(0, 0, func([]float64) (float64, error) { return math.Pi, nil }),
+	"ATAN2":   mathFunction(2, 2, func(x []float64) (float64, error) { return math.Atan2(x[0], x[1]), nil }),
+	"POW":     mathFunction(2, 2, func(x []float64) (float64, error) { return math.Pow(x[0], x[1]), nil }),
+	"ROUND":   mathFunction(1, 2, func(x []float64) (float64, error) { return roundTo(x, math.Round) }),
+	"TRUNC":   mathFunction(1, 2, func(x []float64) (float64, error) { return roundTo(x, math.Trunc) }),
+	"CEILING": mathFunction(1, 2, func(x []float64) (float64, error) { return roundToMultiple(x, math.Ceil) }),
+	"FLOOR":   mathFunction(1, 2, func(x []float64) (float64, error) { return roundToMultiple(x, math.Floor) }),
+	"MODULUS": mathFunction(2, 2, func(x []float64) (float64, error) {
+		if x[1] == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		// The result takes the sign of the divisor
+		return x[0] - x[1]*math.Floor(x[0]/x[1]), nil
+	}),
+	"MAX": mathFunction(1, -1, func(x []float64) (float64, error) {
+		m := x[0]
+		for _, v := range x[1:] {
+			m = math.Max(m, v)
+		}
+		return m, nil
+	}),
+	"MIN": mathFunction(1, -1, func(x []float64) (float64, error) {
+		m := x[0]
+		for _, v := range x[1:] {
+			m = math.Min(m, v)
+		}
+		return m, nil
+	}),
+	"SUM": mathFunction(0, -1, func(x []float64) (float64, error) {
+		sum := 0.0
+		for _, v := range x {
+			sum += v
+		}
+		return sum, nil
+	}),
+	"BITAND": mathFunction(2, 2, func(x []float64) (float64, error) { return float64(int64(x[0]) & int64(x[1])), nil }),
+	"BITOR":  mathFunction(2, 2, func(x []float64) (float64, error) { return float64(int64(x[0]) | int64(x[1])), nil }),
+	"BITXOR": mathFunction(2, 2, func(x []float64) (float64, error) { return float64(int64(x[0]) ^ int64(x[1])), nil }),
+	"LEN": {1, 1, func(args []formulaValue) (formulaValue, error) {
+		return numberValue(float64(len([]rune(args[0].text())))), nil
+	}},
+	"UPPER": {1, 1, func(args []formulaValue) (formulaValue, error) {
+		return stringValue(strings.ToUpper(args[0].text())), nil
+	}},
+	"LOWER": {1, 1, func(args []formulaValue) (formulaValue, error) {
+		return stringValue(strings.ToLower(args[0].text())), nil
+	}},
+	"CHAR": {1, 1, func(args []formulaValue) (formulaValue, error) {
+		n, err := args[0].number()
+		if err != nil {
+			return formulaValue{}, err
+		}
+		return stringValue(string(rune(int(n)))), nil
+	}},
+	"STRSAME": {2, 3, func(args []formulaValue) (formulaValue, error) {
+		ignoreCase := false
+		if len(args) == 3 {
+			n, err := args[2].number()
+			if err != nil {
+				return formulaValue{}, err
+			}
+			ignoreCase = n != 0
+		}
+		if ignoreCase {
+			return boolValue(strings.EqualFold(args[0].text(), args[1].text())), nil
+		}
+		return boolValue(args[0].text() == args[1].text()), nil
+	}},
+}
+
+// reference evaluates a cell reference, optionally qualified by a sheet:
+// Sheet.ID, a shape name, ThePage or TheDoc
+func (env *formulaEnv) reference(sheet *xmlSheet, qualifier, name string) (formulaValue, error) {
+	target := sheet
+	if qualifier != "" {
+		switch {
+		case strings.EqualFold(qualifier, "ThePage"):
+			target = env.page
+		case strings.EqualFold(qualifier, "TheDoc"):
+			target = env.doc
+		case len(env.fromMaster) > 0 && env.fromMaster[len(env.fromMaster)-1]:
+			return formulaValue{}, fmt.Errorf("cannot resolve %s in a master formula", qualifier)
+		case len(qualifier) > 6 && strings.EqualFold(qualifier[:6], "Sheet."):
+			target = env.shapes[qualifier[6:]]
+		default:
+			target = env.names[strings.ToLower(qualifier)]
+		}
+		if target == nil {
+			return formulaValue{}, fmt.Errorf("unknown sheet %s", qualifier)
+		}
+	}
+	c := findFormulaCell(target, name)
+	if c == nil {
+		return formulaValue{}, fmt.Errorf("unknown cell %s", name)
+	}
+	v, _, err := env.evaluateCell(target, c)
+	if errors.Is(err, errCircularReference) {
+		return formulaValue{}, err
+	}
+	return v, nil
+}
+
+// formulaSections maps the section prefixes used in cell references to
+// section names and the cell read by a reference to a whole named row
+var formulaSections = map[string]struct{ section, cell string }{
+	"prop":        {"Property", "Value"},
+	"user":        {"User", "Value"},
+	"hyperlink":   {"Hyperlink", "Address"},
+	"actions":     {"Actions", "Action"},
+	"controls":    {"Control", "X"},
+	"connections": {"Connection", "X"},
+	"scratch":     {"Scratch", "X"},
+	"fields":      {"Field", "Value"},
+	"char":        {"Character", ""},
+	"para":        {"Paragraph", ""},
+}
+
+// findFormulaCell resolves a cell name as written in a formula: Width,
+// Prop.Cost, Prop.Cost.Label, User.Row_1, Controls.X1, Connections.X1,
+// Scratch.A1, Char.Size, Geometry1.X2 or Geometry1.NoFill
+func findFormulaCell(sheet *xmlSheet, name string) *xmlCell {
+	parts := strings.Split(name, ".")
+	if len(parts) == 1 {
+		return findCell(sheet.Cells, name)
+	}
+	prefix := strings.ToLower(parts[0])
+
+	if strings.HasPrefix(prefix, "geometry") && len(parts) == 2 {
+		n, err := strconv.Atoi(prefix[len("geometry"):])
+		if err != nil {
+			return nil
+		}
+		section := findSheetSection(sheet, "Geometry", n-1)
+		if section == nil {
+			return nil
+		}
+		if c := findCell(section.Cells, parts[1]); c != nil {
+			return c
+		}
+		cell, ix, ok := splitIndexedCell(parts[1])
+		if !ok {
+			return nil
+		}
+		return findRowCell(findRowAt(section, ix, 1), cell)
+	}
+
+	spec, ok := formulaSections[prefix]
+	if !ok || len(parts) > 3 {
+		return nil
+	}
+	section := findSheetSection(sheet, spec.section, 0)
+	if section == nil {
+		return nil
+	}
+	if len(parts) == 3 {
+		return findRowCell(findNamedRow(section, parts[1]), parts[2])
+	}
+	if row := findNamedRow(section, parts[1]); row != nil && spec.cell != "" {
+		return findRowCell(row, spec.cell)
+	}
+	if spec.cell == "" {
+		// Character and Paragraph references address the first row
+		return findRowCell(findRowAt(section, 0, 0), parts[1])
+	}
+	cell, ix, ok := splitIndexedCell(parts[1])
+	if !ok {
+		return nil
+	}
+	return findRowCell(findRowAt(section, ix-1, 0), cell)
+}
+
+// findCell returns a pointer to the named cell, matched case-insensitively
+func findCell(cells []xmlCell, name string) *xmlCell {
+	for i := range cells {
+		if strings.EqualFold(cells[i].N, name) {
+			return &cells[i]
+		}
+	}
+	return nil
+}
+
+// findRowCell returns a pointer to the named cell of a row, or nil
+func findRowCell(row *xmlRow, name string) *xmlCell {
+	if row == nil {
+		return nil
+	}
+	return findCell(row.Cells, name)
+}
+
+// findSheetSection returns the section with the given name and index.
+// Sections without an IX attribute count as index 0.
+func findSheetSection(sheet *xmlSheet, name string, ix int) *xmlSection {
+	for i := range sheet.Sections {
+		if sheet.Sections[i].N == name && atoiOrZero(sheet.Sections[i].IX) == ix {
+			return &sheet.Sections[i]
+		}
+	}
+	return nil
+}
+
+// findNamedRow returns the row with the given name
+func findNamedRow(section *xmlSection, name string) *xmlRow {
+	for i := range section.Rows {
+		if strings.EqualFold(section.Rows[i].N, name) {
+			return &section.Rows[i]
+		}
+	}
+	return nil
+}
+
+// findRowAt returns the row with the given index. Rows written without an
+// IX attribute are matched by position, the first row having index base.
+func findRowAt(section *xmlSection, ix, base int) *xmlRow {
+	for i := range section.Rows {
+		if section.Rows[i].IX != "" && atoiOrZero(section.Rows[i].IX) == ix {
+			return &section.Rows[i]
+		}
+	}
+	if i := ix - base; i >= 0 && i < len(section.Rows) && section.Rows[i].IX == "" {
+		return &section.Rows[i]
+	}
+	return nil
+}
+
+// splitIndexedCell splits a reference such as X12 into the cell name and row number
+func splitIndexedCell(s string) (string, int, bool) {
+	i := len(s)
+	for i > 0 && s[i-1] >= '0' && s[i-1] <= '9' {
+		i--
+	}
+	if i == 0 || i == len(s) {
+		return "", 0, false
+	}
+	n, err := strconv.Atoi(s[i:])
+	return s[:i], n, err == nil
+}
@@ -0,0 +1,176 @@
+package visio
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+// testFormulaEnv returns an environment with two shapes, a page sheet and
+// a document sheet, and the sheet of the first shape
+func testFormulaEnv() (*formulaEnv, *xmlSheet) {
+	shapes := []*xmlShape{
+		{ID: "1", Name: "Box", xmlSheet: xmlSheet{
+			Cells: []xmlCell{
+				{N: "Width", V: "2"},
+				{N: "Height", V: "0", F: "Width*0.5"},
+				{N: "PinX", V: "3"},
+			},
+			Sections: []xmlSection{
+				{N: "Property", Rows: []xmlRow{{N: "Cost", Cells: []xmlCell{{N: "Value", V: "5"}}}}},
+				{N: "User", Rows: []xmlRow{{N: "Label", Cells: []xmlCell{{N: "Value", V: "Total", U: "STR"}}}}},
+			},
+		}},
+		{ID: "2", Name: "Line", xmlSheet: xmlSheet{Cells: []xmlCell{{N: "Width", V: "7"}}}},
+	}
+	page := &xmlSheet{Cells: []xmlCell{{N: "PageWidth", V: "8.5"}}}
+	doc := &xmlSheet{Cells: []xmlCell{{N: "DocLangID", V: "1033"}}}
+	return newFormulaEnv(shapes, page, doc), &shapes[0].xmlSheet
+}
+
+func TestEvaluateFormula(t *testing.T) {
+	tests := []struct {
+		formula string
+		want    float64
+	}{
+		// Operators and precedence
+		{"1+2*3", 7},
+		{"(1+2)*3", 9},
+		{"10-4-3", 3},
+		{"7/2", 3.5},
+		{"2*3^2", 18},
+		{"2^3^2", 512},
+		{"-2^2", -4},
+		{"50%", 0.5},
+		{"1+2=3", 1},
+		{"3>2", 1},
+		{"2<>2", 0},
+		{"2<=1", 0},
+		// Unit literals convert to inches and radians
+		{"2 in", 2},
+		{"25.4 mm", 1},
+		{"1 ft", 12},
+		{"2 in+25.4 mm", 3},
+		{"180 deg", math.Pi},
+		// Functions
+		{"GUARD(Width*2)", 4},
+		{"IF(Width>1,10,20)", 10},
+		{"IF(Width>5,10,20)", 20},
+		{"IF(Width>5,10)", 0},
+		{"IF(TRUE,2,1/0)", 2},
+		{"DEPENDSON(Height,PinX)", 0},
+		{"MAX(1,Width,PinX)", 3},
+		{"MODULUS(-7,3)", 2},
+		{"ROUND(2.345,2)", 2.35},
+		// References
+		{"Height", 1},
+		{"Prop.Cost*2", 10},
+		{"Sheet.2!Width", 7},
+		{"Line!Width+Box!Height", 8},
+		{"ThePage!PageWidth", 8.5},
+		{"TheDoc!DocLangID", 1033},
+	}
+	for _, tt := range tests {
+		env, sheet := testFormulaEnv()
+		got, err := env.evaluate(tt.formula, sheet)
+		if err != nil {
+			t.Errorf("%s: %v", tt.formula, err)
+			continue
+		}
+		if got.isStr || math.Abs(got.num-tt.want) > 1e-9 {
+			t.Errorf("%s = %+v, want %v", tt.formula, got, tt.want)
+		}
+	}
+}
+
+func TestEvaluateFormulaString(t *testing.T) {
+	tests := []struct {
+		formula string
+		want    string
+	}{
+		{`"a"&"b"`, "ab"},
+		{`1&2`, "12"},
+		{`User.Label&": "&Prop.Cost`, "Total: 5"},
+		{`UPPER("abc")`, "ABC"},
+	}
+	for _, tt := range tests {
+		env, sheet := testFormulaEnv()
+		got, err := env.evaluate(tt.formula, sheet)
+		if err != nil {
+			t.Errorf("%s: %v", tt.formula, err)
+			continue
+		}
+		if got.text() != tt.want {
+			t.Errorf("%s = %q, want %q", tt.formula, got.text(), tt.want)
+		}
+	}
+}
+
+func TestEvaluateFormulaErrors(t *testing.T) {
+	for _, formula := range []string{
+		"1/0",
+		"1+",
+		"(1",
+		"IF(1)",
+		"GUARD(1,2)",
+		"UNKNOWN(1)",
+		"Sheet.9!Width",
+		"Nowhere!Width",
+		"NoSuchCell",
+		`"a"*2`,
+		`"unterminated`,
+	} {
+		env, sheet := testFormulaEnv()
+		if v, err := env.evaluate(formula, sheet); err == nil {
+			t.Errorf("%s = %+v, want an error", formula, v)
+		}
+	}
+}
+
+func TestEvaluateCircularReference(t *testing.T) {
+	shapes := []*xmlShape{{ID: "1", xmlSheet: xmlSheet{Cells: []xmlCell{
+		{N: "PinX", V: "1", F: "PinY+1"},
+		{N: "PinY", V: "2", F: "PinX+1"},
+		{N: "Width", V: "3", F: "Width*2"},
+		{N: "Height", V: "4", F: "PinX"},
+		{N: "Angle", V: "0", F: "5"},
+	}}}}
+	env := newFormulaEnv(shapes, nil, nil)
+	sheet := &shapes[0].xmlSheet
+
+	for _, c := range sheet.Cells[:4] {
+		c := c
+		v, ok, err := env.evaluateCell(sheet, &c)
+		if !errors.Is(err, errCircularReference) {
+			t.Errorf("%s: error %v, want a circular reference", c.N, err)
+		}
+		if ok || v.num != cachedValue(&c).num {
+			t.Errorf("%s = %+v (recomputed %v), want the cached value", c.N, v, ok)
+		}
+	}
+
+	// Only the cell outside the cycle is updated
+	env = newFormulaEnv(shapes, nil, nil)
+	updates := env.recalculate(shapes)
+	if len(updates) != 1 || updates[0].path.cell != "Angle" || updates[0].value != "5" {
+		t.Errorf("updates = %+v, want Angle = 5 only", updates)
+	}
+}
+
+func TestFormatFormulaNumber(t *testing.T) {
+	tests := []struct {
+		v    float64
+		want string
+	}{
+		{0, "0"},
+		{1.5, "1.5"},
+		{0.1 + 0.2, "0.3"},
+		{-2, "-2"},
+		{1e20, "100000000000000000000"},
+	}
+	for _, tt := range tests {
+		if got := formatFormulaNumber(tt.v); got != tt.want {
+			t.Errorf("formatFormulaNumber(%v) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
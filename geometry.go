@@ -0,0 +1,229 @@
+package visio
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// parseGeometry decodes the Geometry sections of a shape into paths. Rows
+// are normalized: relative rows (RelMoveTo, RelCubBezTo, ...) are scaled by
+// the shape size, and POLYLINE and NURBS formulas are expanded into points,
+// so every coordinate is in the shape's local coordinates, in inches.
+func parseGeometry(shape *xmlShape) []GeometryPath {
+	width := shape.cellFloat("Width")
+	height := shape.cellFloat("Height")
+
+	sections := make([]*xmlSection, 0)
+	for i := range shape.Sections {
+		if shape.Sections[i].N == "Geometry" {
+			sections = append(sections, &shape.Sections[i])
+		}
+	}
+	sort.SliceStable(sections, func(i, j int) bool {
+		return atoiOrZero(sections[i].IX) < atoiOrZero(sections[j].IX)
+	})
+
+	paths := make([]GeometryPath, 0, len(sections))
+	for _, section := range sections {
+		path := GeometryPath{
+			Index:    atoiOrZero(section.IX),
+			Segments: make([]PathSegment, 0, len(section.Rows)),
+		}
+		for _, c := range section.Cells {
+			switch c.N {
+			case "NoFill":
+				path.NoFill = parseBool(c.V)
+			case "NoLine":
+				path.NoLine = parseBool(c.V)
+			case "NoShow":
+				path.NoShow = parseBool(c.V)
+			case "NoSnap":
+				path.NoSnap = parseBool(c.V)
+			}
+		}
+
+		rows := append([]xmlRow(nil), section.Rows...)
+		sort.SliceStable(rows, func(i, j int) bool {
+			return atoiOrZero(rows[i].IX) < atoiOrZero(rows[j].IX)
+		})
+		for i := range rows {
+			if segment, ok := parseGeometryRow(&rows[i], width, height); ok {
+				path.Segments = append(path.Segments, segment)
+			}
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// parseGeometryRow normalizes a single geometry row. Rows of unknown type
+// are skipped.
+func parseGeometryRow(row *xmlRow, width, height float64) (PathSegment, bool) {
+	value := func(name string) float64 {
+		c, _ := row.cell(name)
+		return c.float()
+	}
+	formula := func(name string) string {
+		c, _ := row.cell(name)
+		return c.F
+	}
+	x, y := value("X"), value("Y")
+
+	switch row.T {
+	case "MoveTo", "LineTo", "InfiniteLine":
+		segment := PathSegment{Type: row.T, X: x, Y: y}
+		if row.T == "InfiniteLine" {
+			segment.Points = []Point{{X: value("A"), Y: value("B")}}
+		}
+		return segment, true
+	case "RelMoveTo":
+		return PathSegment{Type: "MoveTo", X: x * width, Y: y * height}, true
+	case "RelLineTo":
+		return PathSegment{Type: "LineTo", X: x * width, Y: y * height}, true
+	case "ArcTo":
+		return PathSegment{Type: "ArcTo", X: x, Y: y, Bow: value("A")}, true
+	case "EllipticalArcTo":
+		return PathSegment{
+			Type:    "EllipticalArcTo",
+			X:       x,
+			Y:       y,
+			Control: []Point{{X: value("A"), Y: value("B")}},
+			Angle:   value("C"),
+			Ratio:   value("D"),
+		}, true
+	case "RelEllipticalArcTo":
+		return PathSegment{
+			Type:    "EllipticalArcTo",
+			X:       x * width,
+			Y:       y * height,
+			Control: []Point{{X: value("A") * width, Y: value("B") * height}},
+			Angle:   value("C"),
+			Ratio:   value("D"),
+		}, true
+	case "RelCubBezTo":
+		return PathSegment{
+			Type: "CubicBezTo",
+			X:    x * width,
+			Y:    y * height,
+			Control: []Point{
+				{X: value("A") * width, Y: value("B") * height},
+				{X: value("C") * width, Y: value("D") * height},
+			},
+		}, true
+	case "RelQuadBezTo":
+		return PathSegment{
+			Type:    "QuadBezTo",
+			X:       x * width,
+			Y:       y * height,
+			Control: []Point{{X: value("A") * width, Y: value("B") * height}},
+		}, true
+	case "Ellipse":
+		// X, Y is the center; A, B and C, D are points on the major and minor axes
+		return PathSegment{
+			Type: "Ellipse",
+			X:    x,
+			Y:    y,
+			Control: []Point{
+				{X: value("A"), Y: value("B")},
+				{X: value("C"), Y: value("D")},
+			},
+		}, true
+	case "PolylineTo":
+		return PathSegment{
+			Type:   "PolylineTo",
+			X:      x,
+			Y:      y,
+			Points: parsePolyline(formula("A"), width, height),
+		}, true
+	case "NURBSTo":
+		segment := PathSegment{Type: "NURBSTo", X: x, Y: y}
+		knotLast, ok := parseNURBS(&segment, formula("E"), width, height)
+		// C and D hold the first knot and weight, A the second-to-last knot
+		// and B the last weight; the last knot is the first argument of E
+		segment.Knots = append([]float64{value("C")}, segment.Knots...)
+		segment.Knots = append(segment.Knots, value("A"))
+		if ok {
+			segment.Knots = append(segment.Knots, knotLast)
+		}
+		segment.Weights = append([]float64{value("D")}, segment.Weights...)
+		segment.Weights = append(segment.Weights, value("B"))
+		return segment, true
+	case "SplineStart":
+		return PathSegment{
+			Type:   "SplineStart",
+			X:      x,
+			Y:      y,
+			Knots:  []float64{value("B"), value("A"), value("C")},
+			Degree: int(value("D")),
+		}, true
+	case "SplineKnot":
+		return PathSegment{Type: "SplineKnot", X: x, Y: y, Knots: []float64{value("A")}}, true
+	}
+	return PathSegment{}, false
+}
+
+// parsePolyline expands POLYLINE(xType, yType, x1, y1, ...) into points.
+// A type of 0 means the coordinates are fractions of the shape size.
+func parsePolyline(formula string, width, height float64) []Point {
+	args := formulaArgs(formula, "POLYLINE")
+	if len(args) < 2 {
+		return nil
+	}
+	xScale, yScale := axisScale(args[0], width), axisScale(args[1], height)
+	points := make([]Point, 0, (len(args)-2)/2)
+	for i := 2; i+1 < len(args); i += 2 {
+		points = append(points, Point{X: args[i] * xScale, Y: args[i+1] * yScale})
+	}
+	return points
+}
+
+// parseNURBS expands NURBS(knotLast, degree, xType, yType, x1, y1, knot1,
+// weight1, ...) into control points, knots and weights, and returns
+// knotLast. It reports false if the formula is not a NURBS formula.
+func parseNURBS(segment *PathSegment, formula string, width, height float64) (float64, bool) {
+	args := formulaArgs(formula, "NURBS")
+	if len(args) < 4 {
+		return 0, false
+	}
+	segment.Degree = int(args[1])
+	xScale, yScale := axisScale(args[2], width), axisScale(args[3], height)
+	for i := 4; i+3 < len(args); i += 4 {
+		segment.Points = append(segment.Points, Point{X: args[i] * xScale, Y: args[i+1] * yScale})
+		segment.Knots = append(segment.Knots, args[i+2])
+		segment.Weights = append(segment.Weights, args[i+3])
+	}
+	return args[0], true
+}
+
+// axisScale returns the factor for a POLYLINE or NURBS coordinate type
+func axisScale(coordinateType, size float64) float64 {
+	if coordinateType == 0 {
+		return size
+	}
+	return 1
+}
+
+// formulaArgs parses the numeric arguments of a NAME(a, b, ...) formula
+func formulaArgs(formula, name string) []float64 {
+	formula = strings.TrimSpace(formula)
+	if !strings.HasPrefix(strings.ToUpper(formula), name+"(") || !strings.HasSuffix(formula, ")") {
+		return nil
+	}
+	inner := formula[len(name)+1 : len(formula)-1]
+	args := make([]float64, 0)
+	for _, arg := range strings.Split(inner, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(arg), 64)
+		if err != nil {
+			return nil
+		}
+		args = append(args, v)
+	}
+	return args
+}
+
+// atoiOrZero parses a row or section index, treating missing indices as 0
+func atoiOrZero(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
@@ -0,0 +1,77 @@
+package visio
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseGeometryRowNURBSTo(t *testing.T) {
+	row := &xmlRow{T: "NURBSTo", Cells: []xmlCell{
+		{N: "X", V: "2"},
+		{N: "Y", V: "4"},
+		{N: "A", V: "0.6"},
+		{N: "B", V: "1"},
+		{N: "C", V: "0"},
+		{N: "D", V: "1"},
+		{N: "E", F: "NURBS(1, 3, 0, 0, 0.25, 0.5, 0.2, 1, 0.75, 0.5, 0.4, 2)"},
+	}}
+	segment, ok := parseGeometryRow(row, 2, 4)
+	if !ok {
+		t.Fatal("NURBSTo row was skipped")
+	}
+
+	if segment.Degree != 3 {
+		t.Errorf("degree = %d, want 3", segment.Degree)
+	}
+	wantPoints := []Point{{X: 0.5, Y: 2}, {X: 1.5, Y: 2}}
+	if !reflect.DeepEqual(segment.Points, wantPoints) {
+		t.Errorf("points = %v, want %v", segment.Points, wantPoints)
+	}
+	// One knot per control point, counting the start and end points, and
+	// the last knot from the NURBS formula
+	wantKnots := []float64{0, 0.2, 0.4, 0.6, 1}
+	if !reflect.DeepEqual(segment.Knots, wantKnots) {
+		t.Errorf("knots = %v, want %v", segment.Knots, wantKnots)
+	}
+	wantWeights := []float64{1, 1, 2, 1}
+	if !reflect.DeepEqual(segment.Weights, wantWeights) {
+		t.Errorf("weights = %v, want %v", segment.Weights, wantWeights)
+	}
+	if len(segment.Knots) != len(segment.Points)+3 || len(segment.Weights) != len(segment.Points)+2 {
+		t.Errorf("got %d knots and %d weights for %d inner control points", len(segment.Knots), len(segment.Weights), len(segment.Points))
+	}
+}
+
+func TestParseGeometryRow(t *testing.T) {
+	tests := []struct {
+		name string
+		row  xmlRow
+		want PathSegment
+	}{
+		{
+			"relative line",
+			xmlRow{T: "RelLineTo", Cells: []xmlCell{{N: "X", V: "0.5"}, {N: "Y", V: "1"}}},
+			PathSegment{Type: "LineTo", X: 1, Y: 4},
+		},
+		{
+			"polyline in shape fractions",
+			xmlRow{T: "PolylineTo", Cells: []xmlCell{{N: "X", V: "2"}, {N: "Y", V: "0"}, {N: "A", F: "POLYLINE(0, 1, 0.5, 1, 1, 3)"}}},
+			PathSegment{Type: "PolylineTo", X: 2, Y: 0, Points: []Point{{X: 1, Y: 1}, {X: 2, Y: 3}}},
+		},
+		{
+			"NURBS without formula",
+			xmlRow{T: "NURBSTo", Cells: []xmlCell{{N: "X", V: "1"}, {N: "A", V: "0.5"}, {N: "B", V: "1"}, {N: "D", V: "1"}}},
+			PathSegment{Type: "NURBSTo", X: 1, Knots: []float64{0, 0.5}, Weights: []float64{1, 1}},
+		},
+	}
+	for _, tt := range tests {
+		got, ok := parseGeometryRow(&tt.row, 2, 4)
+		if !ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	if _, ok := parseGeometryRow(&xmlRow{T: "Unknown"}, 2, 4); ok {
+		t.Error("row of unknown type was not skipped")
+	}
+}
@@ -1,8 +1,13 @@
 package tools
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 
 	"github.com/negokaz/visio-mcp-server/internal/visio"
 )
@@ -26,9 +31,20 @@ func DescribePagesHandler(arguments map[string]interface{}) (*string, error) {
 		return nil, fmt.Errorf("failed to list pages: %w", err)
 	}
 
+	unit := ""
+	for i := range pages {
+		converter, err := getUnitConverter(arguments, pages[i].PageScale, pages[i].DrawingScale)
+		if err != nil {
+			return nil, err
+		}
+		converter.ConvertPageInfo(&pages[i])
+		unit = converter.Unit
+	}
+
 	// Format response
 	response := map[string]interface{}{
 		"file":      fileAbsolutePath,
+		"unit":      unit,
 		"pageCount": len(pages),
 		"pages":     pages,
 	}
@@ -54,6 +70,21 @@ func ReadPageHandler(arguments map[string]interface{}) (*string, error) {
 		return nil, fmt.Errorf("pageName is required")
 	}
 
+	includeConnections := false
+	if ic, ok := arguments["includeConnections"].(bool); ok {
+		includeConnections = ic
+	}
+
+	layout := "tree"
+	if l, ok := arguments["layout"].(string); ok && l != "" {
+		layout = l
+	}
+	if layout != "tree" && layout != "flat" {
+		return nil, fmt.Errorf("layout must be \"tree\" or \"flat\"")
+	}
+
+	layer := getStringValue(arguments, "layer")
+
 	// Check if file exists
 	if !visio.FileExists(fileAbsolutePath) {
 		return nil, fmt.Errorf("file not found: %s", fileAbsolutePath)
@@ -66,14 +97,36 @@ func ReadPageHandler(arguments map[string]interface{}) (*string, error) {
 		return nil, fmt.Errorf("failed to read page: %w", err)
 	}
 
+	converter, err := getUnitConverter(arguments, page.PageScale, page.DrawingScale)
+	if err != nil {
+		return nil, err
+	}
+	converter.ConvertPage(page)
+
+	shapes := page.Shapes
+	if layer != "" {
+		shapes = visio.FilterShapesByLayer(shapes, layer)
+	}
+	shapeCount := len(visio.FlattenShapes(shapes))
+	if layout == "flat" {
+		shapes = visio.FlattenShapes(shapes)
+	}
+
 	// Format response
 	response := map[string]interface{}{
 		"file":       fileAbsolutePath,
 		"pageName":   page.Name,
+		"unit":       converter.Unit,
 		"width":      page.Width,
 		"height":     page.Height,
-		"shapeCount": len(page.Shapes),
-		"shapes":     page.Shapes,
+		"layers":     page.Layers,
+		"shapeCount": shapeCount,
+		"shapes":     shapes,
+		"comments":   page.Comments,
+		"structures": page.Structures,
+	}
+	if includeConnections {
+		response["connections"] = page.Connections
 	}
 
 	jsonData, err := json.MarshalIndent(response, "", "  ")
@@ -97,6 +150,19 @@ func ListShapesHandler(arguments map[string]interface{}) (*string, error) {
 		return nil, fmt.Errorf("pageName is required")
 	}
 
+	layer := getStringValue(arguments, "layer")
+
+	shapeDataFilter := make(map[string]string)
+	if raw, ok := arguments["shapeData"]; ok {
+		filterMap, ok := raw.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("shapeData must be an object")
+		}
+		for key, value := range filterMap {
+			shapeDataFilter[key] = fmt.Sprint(value)
+		}
+	}
+
 	// Check if file exists
 	if !visio.FileExists(fileAbsolutePath) {
 		return nil, fmt.Errorf("file not found: %s", fileAbsolutePath)
@@ -109,15 +175,32 @@ func ListShapesHandler(arguments map[string]interface{}) (*string, error) {
 		return nil, fmt.Errorf("failed to read page: %w", err)
 	}
 
-	// Create simplified shape list
-	shapeList := make([]map[string]interface{}, 0, len(page.Shapes))
-	for _, shape := range page.Shapes {
+	converter, err := getUnitConverter(arguments, page.PageScale, page.DrawingScale)
+	if err != nil {
+		return nil, err
+	}
+	converter.ConvertShapes(page.Shapes)
+
+	// Create simplified shape list with page-absolute positions
+	shapes := visio.FlattenShapes(page.Shapes)
+	shapeList := make([]map[string]interface{}, 0, len(shapes))
+	for i := range shapes {
+		shape := &shapes[i]
+		if !visio.MatchShapeData(shape, shapeDataFilter) {
+			continue
+		}
+		if layer != "" && !visio.OnLayer(shape, layer) {
+			continue
+		}
 		shapeList = append(shapeList, map[string]interface{}{
-			"id":   shape.ID,
-			"text": shape.Text,
-			"type": shape.Type,
-			"x":    shape.PinX,
-			"y":    shape.PinY,
+			"id":         shape.ID,
+			"parentId":   shape.ParentID,
+			"text":       shape.Text,
+			"type":       shape.Type,
+			"x":          shape.PageX,
+			"y":          shape.PageY,
+			"layers":     shape.Layers,
+			"properties": shape.Properties,
 		})
 	}
 
@@ -125,6 +208,7 @@ func ListShapesHandler(arguments map[string]interface{}) (*string, error) {
 	response := map[string]interface{}{
 		"file":       fileAbsolutePath,
 		"pageName":   page.Name,
+		"unit":       converter.Unit,
 		"shapeCount": len(shapeList),
 		"shapes":     shapeList,
 	}
@@ -138,6 +222,85 @@ func ListShapesHandler(arguments map[string]interface{}) (*string, error) {
 	return &result, nil
 }
 
+// ReadShapeSheetHandler handles the visio_read_shapesheet tool
+func ReadShapeSheetHandler(arguments map[string]interface{}) (*string, error) {
+	fileAbsolutePath, ok := arguments["fileAbsolutePath"].(string)
+	if !ok {
+		return nil, fmt.Errorf("fileAbsolutePath is required")
+	}
+
+	pageName, ok := arguments["pageName"].(string)
+	if !ok {
+		return nil, fmt.Errorf("pageName is required")
+	}
+
+	shapeID, ok := arguments["shapeId"].(string)
+	if !ok {
+		return nil, fmt.Errorf("shapeId is required")
+	}
+
+	sectionFilter := make(map[string]bool)
+	if raw, ok := arguments["sections"].([]interface{}); ok {
+		for _, name := range raw {
+			if name, ok := name.(string); ok {
+				sectionFilter[name] = true
+			}
+		}
+	}
+
+	// Check if file exists
+	if !visio.FileExists(fileAbsolutePath) {
+		return nil, fmt.Errorf("file not found: %s", fileAbsolutePath)
+	}
+
+	// Read page
+	reader := visio.NewReader(fileAbsolutePath)
+	page, err := reader.ReadPage(pageName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read page: %w", err)
+	}
+
+	shape, ok := visio.FindShape(page.Shapes, shapeID)
+	if !ok {
+		return nil, fmt.Errorf("shape not found: %s", shapeID)
+	}
+
+	converter, err := getUnitConverter(arguments, page.PageScale, page.DrawingScale)
+	if err != nil {
+		return nil, err
+	}
+	converted := []visio.Shape{*shape}
+	converter.ConvertShapes(converted)
+	shape = &converted[0]
+
+	sections := make([]visio.SheetSection, 0, len(shape.Sections))
+	for _, section := range shape.Sections {
+		if len(sectionFilter) == 0 || sectionFilter[section.Name] {
+			sections = append(sections, section)
+		}
+	}
+
+	// Format response
+	response := map[string]interface{}{
+		"file":      fileAbsolutePath,
+		"pageName":  page.Name,
+		"shapeId":   shape.ID,
+		"shapeName": shape.Name,
+		"master":    shape.Master,
+		"unit":      converter.Unit,
+		"cells":     shape.Cells,
+		"sections":  sections,
+	}
+
+	jsonData, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal response: %w", err)
+	}
+
+	result := string(jsonData)
+	return &result, nil
+}
+
 // WriteShapeHandler handles the visio_write_shape tool
 func WriteShapeHandler(arguments map[string]interface{}) (*string, error) {
 	fileAbsolutePath, ok := arguments["fileAbsolutePath"].(string)
@@ -167,11 +330,54 @@ func WriteShapeHandler(arguments map[string]interface{}) (*string, error) {
 	}
 
 	shapeData := visio.ShapeData{
-		Text:   getStringValue(shapeDataMap, "text"),
-		PinX:   getFloatValue(shapeDataMap, "pinX"),
-		PinY:   getFloatValue(shapeDataMap, "pinY"),
-		Width:  getFloatValue(shapeDataMap, "width"),
-		Height: getFloatValue(shapeDataMap, "height"),
+		ID:   getStringValue(shapeDataMap, "id"),
+		Name: getStringValue(shapeDataMap, "name"),
+		Text: getStringValue(shapeDataMap, "text"),
+	}
+	for _, field := range []struct {
+		key   string
+		value **float64
+	}{
+		{"pinX", &shapeData.PinX},
+		{"pinY", &shapeData.PinY},
+		{"width", &shapeData.Width},
+		{"height", &shapeData.Height},
+	} {
+		value, err := getFloatPointer(shapeDataMap, field.key)
+		if err != nil {
+			return nil, err
+		}
+		*field.value = value
+	}
+
+	// Parse user cell edits
+	if userCellsRaw, ok := shapeDataMap["userCells"]; ok {
+		userCells, ok := userCellsRaw.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("userCells must be an array")
+		}
+		for _, raw := range userCells {
+			cell, ok := raw.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("userCells entries must be objects")
+			}
+			edit := visio.UserCellEdit{
+				Name:    getStringValue(cell, "name"),
+				Value:   getStringValue(cell, "value"),
+				Formula: getStringValue(cell, "formula"),
+				Prompt:  getStringValue(cell, "prompt"),
+			}
+			if number, ok := cell["value"].(float64); ok {
+				edit.Value = strconv.FormatFloat(number, 'f', -1, 64)
+			}
+			if del, ok := cell["delete"].(bool); ok {
+				edit.Delete = del
+			}
+			if edit.Name == "" {
+				return nil, fmt.Errorf("userCells entries require a name")
+			}
+			shapeData.User = append(shapeData.User, edit)
+		}
 	}
 
 	// Check if file exists
@@ -179,9 +385,20 @@ func WriteShapeHandler(arguments map[string]interface{}) (*string, error) {
 		return nil, fmt.Errorf("file not found: %s", fileAbsolutePath)
 	}
 
+	// Convert the position and size to inches using the page's drawing scale
+	pageScale, drawingScale := 1.0, 1.0
+	if info, err := visio.NewReader(fileAbsolutePath).ReadPageInfo(pageName); err == nil {
+		pageScale, drawingScale = info.PageScale, info.DrawingScale
+	}
+	converter, err := getUnitConverter(arguments, pageScale, drawingScale)
+	if err != nil {
+		return nil, err
+	}
+	converter.ConvertShapeData(&shapeData)
+
 	// Write shape
 	writer := visio.NewWriter(fileAbsolutePath)
-	err := writer.WriteShape(pageName, shapeData, createPage)
+	err = writer.WriteShape(pageName, shapeData, createPage)
 	if err != nil {
 		return nil, fmt.Errorf("failed to write shape: %w", err)
 	}
@@ -203,8 +420,814 @@ func WriteShapeHandler(arguments map[string]interface{}) (*string, error) {
 	return &result, nil
 }
 
+// EditHyperlinkHandler handles the visio_edit_hyperlink tool
+func EditHyperlinkHandler(arguments map[string]interface{}) (*string, error) {
+	fileAbsolutePath, ok := arguments["fileAbsolutePath"].(string)
+	if !ok {
+		return nil, fmt.Errorf("fileAbsolutePath is required")
+	}
+
+	pageName, ok := arguments["pageName"].(string)
+	if !ok {
+		return nil, fmt.Errorf("pageName is required")
+	}
+
+	shapeID, ok := arguments["shapeId"].(string)
+	if !ok {
+		return nil, fmt.Errorf("shapeId is required")
+	}
+
+	action := "set"
+	if a, ok := arguments["action"].(string); ok && a != "" {
+		action = a
+	}
+	if action != "set" && action != "remove" {
+		return nil, fmt.Errorf("action must be \"set\" or \"remove\"")
+	}
+
+	name := getStringValue(arguments, "name")
+	if action == "remove" && name == "" {
+		return nil, fmt.Errorf("name is required to remove a hyperlink")
+	}
+
+	// Check if file exists
+	if !visio.FileExists(fileAbsolutePath) {
+		return nil, fmt.Errorf("file not found: %s", fileAbsolutePath)
+	}
+
+	writer := visio.NewWriter(fileAbsolutePath)
+	if action == "remove" {
+		if err := writer.RemoveHyperlink(pageName, shapeID, name); err != nil {
+			return nil, fmt.Errorf("failed to remove hyperlink: %w", err)
+		}
+	} else {
+		// Start from the existing hyperlink so omitted fields are kept
+		reader := visio.NewReader(fileAbsolutePath)
+		page, err := reader.ReadPage(pageName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read page: %w", err)
+		}
+		shape, ok := visio.FindShape(page.Shapes, shapeID)
+		if !ok {
+			return nil, fmt.Errorf("shape not found: %s", shapeID)
+		}
+		link := visio.Hyperlink{Name: name}
+		if existing, ok := visio.FindHyperlink(shape, name); ok {
+			link = *existing
+		}
+		for key, field := range map[string]*string{
+			"address":     &link.Address,
+			"subAddress":  &link.SubAddress,
+			"description": &link.Description,
+			"extraInfo":   &link.ExtraInfo,
+			"frame":       &link.Frame,
+		} {
+			if value, ok := arguments[key].(string); ok {
+				*field = value
+			}
+		}
+		for key, field := range map[string]*bool{
+			"newWindow": &link.NewWindow,
+			"default":   &link.Default,
+			"invisible": &link.Invisible,
+		} {
+			if value, ok := arguments[key].(bool); ok {
+				*field = value
+			}
+		}
+
+		name, err = writer.SetHyperlink(pageName, shapeID, link)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set hyperlink: %w", err)
+		}
+	}
+
+	// Format response
+	response := map[string]interface{}{
+		"success": true,
+		"file":    fileAbsolutePath,
+		"page":    pageName,
+		"shapeId": shapeID,
+		"name":    name,
+		"action":  action,
+	}
+
+	jsonData, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal response: %w", err)
+	}
+
+	result := string(jsonData)
+	return &result, nil
+}
+
+// ListCommentsHandler handles the visio_list_comments tool
+func ListCommentsHandler(arguments map[string]interface{}) (*string, error) {
+	fileAbsolutePath, ok := arguments["fileAbsolutePath"].(string)
+	if !ok {
+		return nil, fmt.Errorf("fileAbsolutePath is required")
+	}
+
+	pageName := getStringValue(arguments, "pageName")
+	shapeID := getStringValue(arguments, "shapeId")
+	includeResolved := true
+	if ir, ok := arguments["includeResolved"].(bool); ok {
+		includeResolved = ir
+	}
+
+	// Check if file exists
+	if !visio.FileExists(fileAbsolutePath) {
+		return nil, fmt.Errorf("file not found: %s", fileAbsolutePath)
+	}
+
+	comments, err := visio.NewReader(fileAbsolutePath).ReadComments(pageName, shapeID)
+	if err != nil {
+		return nil, err
+	}
+	if !includeResolved {
+		open := make([]visio.Comment, 0, len(comments))
+		for _, c := range comments {
+			if !c.Done {
+				open = append(open, c)
+			}
+		}
+		comments = open
+	}
+
+	// Format response
+	response := map[string]interface{}{
+		"file":     fileAbsolutePath,
+		"count":    len(comments),
+		"comments": comments,
+	}
+	if pageName != "" {
+		response["page"] = pageName
+	}
+	if shapeID != "" {
+		response["shapeId"] = shapeID
+	}
+
+	jsonData, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal response: %w", err)
+	}
+
+	result := string(jsonData)
+	return &result, nil
+}
+
+// EditCommentHandler handles the visio_edit_comment tool
+func EditCommentHandler(arguments map[string]interface{}) (*string, error) {
+	fileAbsolutePath, ok := arguments["fileAbsolutePath"].(string)
+	if !ok {
+		return nil, fmt.Errorf("fileAbsolutePath is required")
+	}
+
+	action, ok := arguments["action"].(string)
+	if !ok {
+		return nil, fmt.Errorf("action is required")
+	}
+
+	pageName := getStringValue(arguments, "pageName")
+	shapeID := getStringValue(arguments, "shapeId")
+	commentID := getStringValue(arguments, "commentId")
+	comment := visio.Comment{
+		Author:   getStringValue(arguments, "author"),
+		Initials: getStringValue(arguments, "initials"),
+		Text:     getStringValue(arguments, "text"),
+	}
+	switch action {
+	case "add":
+		if pageName == "" {
+			return nil, fmt.Errorf("pageName is required to add a comment")
+		}
+	case "reply", "resolve", "reopen":
+		if commentID == "" {
+			return nil, fmt.Errorf("commentId is required to %s a comment", action)
+		}
+	default:
+		return nil, fmt.Errorf("action must be \"add\", \"reply\", \"resolve\" or \"reopen\"")
+	}
+
+	// Check if file exists
+	if !visio.FileExists(fileAbsolutePath) {
+		return nil, fmt.Errorf("file not found: %s", fileAbsolutePath)
+	}
+
+	writer := visio.NewWriter(fileAbsolutePath)
+	var err error
+	switch action {
+	case "add":
+		if commentID, err = writer.AddComment(pageName, shapeID, comment); err != nil {
+			return nil, fmt.Errorf("failed to add comment: %w", err)
+		}
+	case "reply":
+		if commentID, err = writer.ReplyToComment(commentID, comment); err != nil {
+			return nil, fmt.Errorf("failed to reply to comment: %w", err)
+		}
+	default:
+		if err = writer.ResolveComment(commentID, action == "resolve"); err != nil {
+			return nil, fmt.Errorf("failed to %s comment: %w", action, err)
+		}
+	}
+
+	// Format response
+	response := map[string]interface{}{
+		"success":   true,
+		"file":      fileAbsolutePath,
+		"commentId": commentID,
+		"action":    action,
+	}
+
+	jsonData, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal response: %w", err)
+	}
+
+	result := string(jsonData)
+	return &result, nil
+}
+
+// ListDataRecordsetsHandler handles the visio_list_data_recordsets tool
+func ListDataRecordsetsHandler(arguments map[string]interface{}) (*string, error) {
+	fileAbsolutePath, ok := arguments["fileAbsolutePath"].(string)
+	if !ok {
+		return nil, fmt.Errorf("fileAbsolutePath is required")
+	}
+
+	// Check if file exists
+	if !visio.FileExists(fileAbsolutePath) {
+		return nil, fmt.Errorf("file not found: %s", fileAbsolutePath)
+	}
+
+	recordsets, err := visio.NewReader(fileAbsolutePath).ListDataRecordsets()
+	if err != nil {
+		return nil, err
+	}
+
+	// Format response
+	response := map[string]interface{}{
+		"file":       fileAbsolutePath,
+		"count":      len(recordsets),
+		"recordsets": recordsets,
+	}
+
+	jsonData, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal response: %w", err)
+	}
+
+	result := string(jsonData)
+	return &result, nil
+}
+
+// RefreshDataRecordsetHandler handles the visio_refresh_data_recordset tool
+func RefreshDataRecordsetHandler(arguments map[string]interface{}) (*string, error) {
+	fileAbsolutePath, ok := arguments["fileAbsolutePath"].(string)
+	if !ok {
+		return nil, fmt.Errorf("fileAbsolutePath is required")
+	}
+
+	recordset, ok := arguments["recordset"].(string)
+	if !ok {
+		return nil, fmt.Errorf("recordset is required")
+	}
+
+	dataFileAbsolutePath, ok := arguments["dataFileAbsolutePath"].(string)
+	if !ok {
+		return nil, fmt.Errorf("dataFileAbsolutePath is required")
+	}
+
+	keyColumn := getStringValue(arguments, "keyColumn")
+
+	// Check if files exist
+	if !visio.FileExists(fileAbsolutePath) {
+		return nil, fmt.Errorf("file not found: %s", fileAbsolutePath)
+	}
+	if !visio.FileExists(dataFileAbsolutePath) {
+		return nil, fmt.Errorf("data file not found: %s", dataFileAbsolutePath)
+	}
+
+	columns, rows, err := visio.ReadDataFile(dataFileAbsolutePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data file: %w", err)
+	}
+
+	refresh, err := visio.NewWriter(fileAbsolutePath).RefreshDataRecordset(recordset, columns, rows, keyColumn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh data recordset: %w", err)
+	}
+
+	// Format response
+	response := map[string]interface{}{
+		"success":  true,
+		"file":     fileAbsolutePath,
+		"dataFile": dataFileAbsolutePath,
+		"refresh":  refresh,
+	}
+
+	jsonData, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal response: %w", err)
+	}
+
+	result := string(jsonData)
+	return &result, nil
+}
+
+// GetDocumentPropertiesHandler handles the visio_get_document_properties tool
+func GetDocumentPropertiesHandler(arguments map[string]interface{}) (*string, error) {
+	fileAbsolutePath, ok := arguments["fileAbsolutePath"].(string)
+	if !ok {
+		return nil, fmt.Errorf("fileAbsolutePath is required")
+	}
+
+	// Check if file exists
+	if !visio.FileExists(fileAbsolutePath) {
+		return nil, fmt.Errorf("file not found: %s", fileAbsolutePath)
+	}
+
+	props, err := visio.NewReader(fileAbsolutePath).ReadDocumentProperties()
+	if err != nil {
+		return nil, err
+	}
+
+	// Format response
+	response := map[string]interface{}{
+		"file":       fileAbsolutePath,
+		"properties": props,
+	}
+
+	jsonData, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal response: %w", err)
+	}
+
+	result := string(jsonData)
+	return &result, nil
+}
+
+// SetDocumentPropertiesHandler handles the visio_set_document_properties tool
+func SetDocumentPropertiesHandler(arguments map[string]interface{}) (*string, error) {
+	fileAbsolutePath, ok := arguments["fileAbsolutePath"].(string)
+	if !ok {
+		return nil, fmt.Errorf("fileAbsolutePath is required")
+	}
+
+	update := visio.DocumentPropertiesUpdate{}
+	var err error
+	if update.Core, err = getStringMap(arguments, "core"); err != nil {
+		return nil, err
+	}
+	if update.Extended, err = getStringMap(arguments, "extended"); err != nil {
+		return nil, err
+	}
+
+	// Parse custom property edits
+	if customRaw, ok := arguments["custom"]; ok {
+		custom, ok := customRaw.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("custom must be an array")
+		}
+		for _, raw := range custom {
+			property, ok := raw.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("custom entries must be objects")
+			}
+			edit := visio.CustomPropertyEdit{
+				Name:  getStringValue(property, "name"),
+				Type:  getStringValue(property, "type"),
+				Value: getStringValue(property, "value"),
+			}
+			switch value := property["value"].(type) {
+			case float64:
+				edit.Value = strconv.FormatFloat(value, 'f', -1, 64)
+			case bool:
+				edit.Value = strconv.FormatBool(value)
+			}
+			if del, ok := property["delete"].(bool); ok {
+				edit.Delete = del
+			}
+			if edit.Name == "" {
+				return nil, fmt.Errorf("custom entries require a name")
+			}
+			update.Custom = append(update.Custom, edit)
+		}
+	}
+
+	// Check if file exists
+	if !visio.FileExists(fileAbsolutePath) {
+		return nil, fmt.Errorf("file not found: %s", fileAbsolutePath)
+	}
+
+	writer := visio.NewWriter(fileAbsolutePath)
+	if err := writer.SetDocumentProperties(update); err != nil {
+		return nil, fmt.Errorf("failed to set document properties: %w", err)
+	}
+
+	props, err := visio.NewReader(fileAbsolutePath).ReadDocumentProperties()
+	if err != nil {
+		return nil, err
+	}
+
+	// Format response
+	response := map[string]interface{}{
+		"success":    true,
+		"file":       fileAbsolutePath,
+		"properties": props,
+	}
+
+	jsonData, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal response: %w", err)
+	}
+
+	result := string(jsonData)
+	return &result, nil
+}
+
+// ReadDocumentHandler handles the visio_read_document tool
+func ReadDocumentHandler(arguments map[string]interface{}) (*string, error) {
+	fileAbsolutePath, ok := arguments["fileAbsolutePath"].(string)
+	if !ok {
+		return nil, fmt.Errorf("fileAbsolutePath is required")
+	}
+
+	depth := "shapes"
+	if d, ok := arguments["depth"].(string); ok && d != "" {
+		depth = d
+	}
+	if depth != "summary" && depth != "shapes" {
+		return nil, fmt.Errorf("depth must be \"summary\" or \"shapes\"")
+	}
+
+	shapeDepth := -1
+	if _, ok := arguments["shapeDepth"]; ok {
+		shapeDepth = int(getFloatValue(arguments, "shapeDepth"))
+		if shapeDepth < 0 {
+			return nil, fmt.Errorf("shapeDepth must not be negative")
+		}
+	}
+
+	includeMasters := true
+	if im, ok := arguments["includeMasters"].(bool); ok {
+		includeMasters = im
+	}
+	includeMasterShapes := false
+	if ims, ok := arguments["includeMasterShapes"].(bool); ok {
+		includeMasterShapes = ims
+	}
+	includeConnections := false
+	if ic, ok := arguments["includeConnections"].(bool); ok {
+		includeConnections = ic
+	}
+
+	pageFilter := getStringSet(arguments, "pages")
+	fields, err := getShapeFields(arguments)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check if file exists
+	if !visio.FileExists(fileAbsolutePath) {
+		return nil, fmt.Errorf("file not found: %s", fileAbsolutePath)
+	}
+
+	// Read document
+	reader := visio.NewReader(fileAbsolutePath)
+	doc, err := reader.ReadDocument()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read document: %w", err)
+	}
+
+	unit := ""
+	pages := make([]map[string]interface{}, 0, len(doc.Pages))
+	for i := range doc.Pages {
+		page := &doc.Pages[i]
+		if len(pageFilter) > 0 && !pageFilter[page.Name] && !pageFilter[page.NameU] {
+			continue
+		}
+
+		converter, err := getUnitConverter(arguments, page.PageScale, page.DrawingScale)
+		if err != nil {
+			return nil, err
+		}
+		converter.ConvertPage(page)
+		unit = converter.Unit
+
+		entry := map[string]interface{}{
+			"id":           page.ID,
+			"name":         page.Name,
+			"nameU":        page.NameU,
+			"index":        page.Index,
+			"isBackground": page.IsBackground,
+			"background":   page.Background,
+			"width":        page.Width,
+			"height":       page.Height,
+			"pageScale":    page.PageScale,
+			"drawingScale": page.DrawingScale,
+			"layers":       page.Layers,
+			"shapeCount":   len(visio.FlattenShapes(page.Shapes)),
+			"commentCount": len(page.Comments),
+		}
+		if depth == "shapes" {
+			entry["comments"] = page.Comments
+			entry["structures"] = page.Structures
+			entry["shapes"], err = selectShapes(page.Shapes, shapeDepth, fields)
+			if err != nil {
+				return nil, err
+			}
+			if includeConnections {
+				entry["connections"] = page.Connections
+			}
+		}
+		pages = append(pages, entry)
+	}
+	if len(pageFilter) > 0 && len(pages) == 0 {
+		return nil, fmt.Errorf("no pages match %v", getStringSlice(arguments, "pages"))
+	}
+
+	// Format response
+	response := map[string]interface{}{
+		"file":       fileAbsolutePath,
+		"unit":       unit,
+		"properties": doc.Properties,
+		"pageCount":  len(pages),
+		"pages":      pages,
+	}
+
+	if includeMasters {
+		// Masters are not placed on a scaled page, so they use the unit alone
+		converter, err := getUnitConverter(arguments, 1, 1)
+		if err != nil {
+			return nil, err
+		}
+		masters := make([]map[string]interface{}, 0, len(doc.Masters))
+		for i := range doc.Masters {
+			master := &doc.Masters[i]
+			entry := map[string]interface{}{
+				"id":         master.ID,
+				"name":       master.Name,
+				"nameU":      master.NameU,
+				"prompt":     master.Prompt,
+				"uniqueId":   master.UniqueID,
+				"hidden":     master.Hidden,
+				"shapeCount": len(visio.FlattenShapes(master.Shapes)),
+			}
+			if includeMasterShapes {
+				converter.ConvertShapes(master.Shapes)
+				entry["shapes"], err = selectShapes(master.Shapes, shapeDepth, fields)
+				if err != nil {
+					return nil, err
+				}
+			}
+			masters = append(masters, entry)
+		}
+		response["masters"] = masters
+	}
+
+	jsonData, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal response: %w", err)
+	}
+
+	result := string(jsonData)
+	return &result, nil
+}
+
+// selectShapes limits a shape tree to shapeDepth levels of group members,
+// or keeps all levels when shapeDepth is negative, and reduces each shape
+// to the given fields. ID and Children are always kept.
+func selectShapes(shapes []visio.Shape, shapeDepth int, fields map[string]bool) (interface{}, error) {
+	if shapeDepth >= 0 {
+		shapes = visio.LimitShapeDepth(shapes, shapeDepth)
+	}
+	if len(fields) == 0 {
+		return shapes, nil
+	}
+
+	data, err := json.Marshal(shapes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal shapes: %w", err)
+	}
+	selected := make([]interface{}, 0)
+	if err := json.Unmarshal(data, &selected); err != nil {
+		return nil, fmt.Errorf("failed to select shape fields: %w", err)
+	}
+
+	var reduce func(shapes []interface{})
+	reduce = func(shapes []interface{}) {
+		for _, shape := range shapes {
+			shape, ok := shape.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			for key := range shape {
+				if key != "ID" && key != "Children" && !fields[key] {
+					delete(shape, key)
+				}
+			}
+			if children, ok := shape["Children"].([]interface{}); ok {
+				reduce(children)
+			}
+		}
+	}
+	reduce(selected)
+	return selected, nil
+}
+
+// getShapeFields reads the fields argument, matching names to the JSON
+// fields of visio.Shape regardless of case
+func getShapeFields(arguments map[string]interface{}) (map[string]bool, error) {
+	names := make(map[string]string)
+	shapeType := reflect.TypeOf(visio.Shape{})
+	for i := 0; i < shapeType.NumField(); i++ {
+		field := shapeType.Field(i)
+		if field.Tag.Get("json") != "-" {
+			names[strings.ToLower(field.Name)] = field.Name
+		}
+	}
+
+	fields := make(map[string]bool)
+	for _, field := range getStringSlice(arguments, "fields") {
+		name, ok := names[strings.ToLower(field)]
+		if !ok {
+			return nil, fmt.Errorf("unknown shape field: %s", field)
+		}
+		fields[name] = true
+	}
+	return fields, nil
+}
+
+// BinaryResult is the result of a tool that returns file data: a JSON
+// description of the data and the data itself
+type BinaryResult struct {
+	Text     string // JSON description
+	URI      string // Identifies the data within the file
+	MIMEType string
+	Data     string // Base64-encoded data
+}
+
+// ExtractForeignDataHandler handles the visio_extract_foreign_data tool
+func ExtractForeignDataHandler(arguments map[string]interface{}) (*BinaryResult, error) {
+	fileAbsolutePath, ok := arguments["fileAbsolutePath"].(string)
+	if !ok {
+		return nil, fmt.Errorf("fileAbsolutePath is required")
+	}
+
+	pageName, ok := arguments["pageName"].(string)
+	if !ok {
+		return nil, fmt.Errorf("pageName is required")
+	}
+
+	shapeID, ok := arguments["shapeId"].(string)
+	if !ok {
+		return nil, fmt.Errorf("shapeId is required")
+	}
+
+	// Check if file exists
+	if !visio.FileExists(fileAbsolutePath) {
+		return nil, fmt.Errorf("file not found: %s", fileAbsolutePath)
+	}
+
+	reader := visio.NewReader(fileAbsolutePath)
+	data, foreign, err := reader.ReadForeignData(pageName, shapeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read foreign data: %w", err)
+	}
+
+	// Format response
+	response := map[string]interface{}{
+		"file":    fileAbsolutePath,
+		"page":    pageName,
+		"shapeId": shapeID,
+		"foreign": foreign,
+	}
+
+	jsonData, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal response: %w", err)
+	}
+
+	return &BinaryResult{
+		Text:     string(jsonData),
+		URI:      "file://" + filepath.ToSlash(fileAbsolutePath) + "#" + foreign.Part,
+		MIMEType: foreign.MIMEType,
+		Data:     base64.StdEncoding.EncodeToString(data),
+	}, nil
+}
+
+// GetThumbnailHandler handles the visio_get_thumbnail tool
+func GetThumbnailHandler(arguments map[string]interface{}) (*BinaryResult, error) {
+	fileAbsolutePath, ok := arguments["fileAbsolutePath"].(string)
+	if !ok {
+		return nil, fmt.Errorf("fileAbsolutePath is required")
+	}
+
+	size := 0
+	if _, ok := arguments["maxSize"]; ok {
+		size = int(getFloatValue(arguments, "maxSize"))
+		if size < 1 || size > 4096 {
+			return nil, fmt.Errorf("maxSize must be between 1 and 4096")
+		}
+	}
+
+	// Check if file exists
+	if !visio.FileExists(fileAbsolutePath) {
+		return nil, fmt.Errorf("file not found: %s", fileAbsolutePath)
+	}
+
+	reader := visio.NewReader(fileAbsolutePath)
+	thumbnail, err := reader.ReadThumbnail(size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read thumbnail: %w", err)
+	}
+
+	// Format response
+	response := map[string]interface{}{
+		"file":     fileAbsolutePath,
+		"part":     thumbnail.Part,
+		"mimeType": thumbnail.MIMEType,
+		"rendered": thumbnail.Rendered,
+	}
+
+	jsonData, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal response: %w", err)
+	}
+
+	return &BinaryResult{
+		Text:     string(jsonData),
+		URI:      "file://" + filepath.ToSlash(fileAbsolutePath) + "#" + thumbnail.Part,
+		MIMEType: thumbnail.MIMEType,
+		Data:     base64.StdEncoding.EncodeToString(thumbnail.Data),
+	}, nil
+}
+
+// ConvertToVSDXHandler handles the visio_convert_to_vsdx tool
+func ConvertToVSDXHandler(arguments map[string]interface{}) (*string, error) {
+	fileAbsolutePath, ok := arguments["fileAbsolutePath"].(string)
+	if !ok {
+		return nil, fmt.Errorf("fileAbsolutePath is required")
+	}
+
+	outputAbsolutePath := getStringValue(arguments, "outputAbsolutePath")
+	if outputAbsolutePath == "" {
+		outputAbsolutePath = strings.TrimSuffix(fileAbsolutePath, filepath.Ext(fileAbsolutePath)) + ".vsdx"
+	}
+	overwrite := false
+	if ow, ok := arguments["overwrite"].(bool); ok {
+		overwrite = ow
+	}
+
+	// Check if files exist
+	if !visio.FileExists(fileAbsolutePath) {
+		return nil, fmt.Errorf("file not found: %s", fileAbsolutePath)
+	}
+	if filepath.Clean(outputAbsolutePath) == filepath.Clean(fileAbsolutePath) {
+		return nil, fmt.Errorf("output file must differ from the source file")
+	}
+	if visio.FileExists(outputAbsolutePath) && !overwrite {
+		return nil, fmt.Errorf("output file already exists: %s", outputAbsolutePath)
+	}
+
+	if err := visio.NewReader(fileAbsolutePath).ConvertToVSDX(outputAbsolutePath); err != nil {
+		return nil, fmt.Errorf("failed to convert file: %w", err)
+	}
+	pages, err := visio.NewReader(outputAbsolutePath).ListPages()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read converted file: %w", err)
+	}
+
+	// Format response
+	response := map[string]interface{}{
+		"success": true,
+		"file":    fileAbsolutePath,
+		"output":  outputAbsolutePath,
+		"pages":   pages,
+	}
+
+	jsonData, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal response: %w", err)
+	}
+
+	result := string(jsonData)
+	return &result, nil
+}
+
 // Helper functions
 
+// getUnitConverter reads the unit and scale arguments shared by all tools
+// and binds them to the drawing scale of a page
+func getUnitConverter(arguments map[string]interface{}, pageScale, drawingScale float64) (visio.UnitConverter, error) {
+	scale := getStringValue(arguments, "scale")
+	if scale != "" && scale != "drawing" && scale != "page" {
+		return visio.UnitConverter{}, fmt.Errorf("scale must be \"drawing\" or \"page\"")
+	}
+	return visio.NewUnitConverter(getStringValue(arguments, "unit"), scale == "page", pageScale, drawingScale)
+}
+
 func getStringValue(m map[string]interface{}, key string) string {
 	if val, ok := m[key].(string); ok {
 		return val
@@ -212,6 +1235,46 @@ func getStringValue(m map[string]interface{}, key string) string {
 	return ""
 }
 
+func getStringSlice(m map[string]interface{}, key string) []string {
+	values := make([]string, 0)
+	if raw, ok := m[key].([]interface{}); ok {
+		for _, value := range raw {
+			if value, ok := value.(string); ok {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+func getStringSet(m map[string]interface{}, key string) map[string]bool {
+	set := make(map[string]bool)
+	for _, value := range getStringSlice(m, key) {
+		set[value] = true
+	}
+	return set
+}
+
+func getStringMap(m map[string]interface{}, key string) (map[string]string, error) {
+	raw, ok := m[key]
+	if !ok {
+		return nil, nil
+	}
+	object, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s must be an object", key)
+	}
+	values := make(map[string]string, len(object))
+	for name, value := range object {
+		text, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s.%s must be a string", key, name)
+		}
+		values[name] = text
+	}
+	return values, nil
+}
+
 func getFloatValue(m map[string]interface{}, key string) float64 {
 	if val, ok := m[key].(float64); ok {
 		return val
@@ -221,3 +1284,20 @@ func getFloatValue(m map[string]interface{}, key string) float64 {
 	}
 	return 0.0
 }
+
+// getFloatPointer returns a pointer to an optional number, or nil when the
+// key is absent or null. Values of other types are an error.
+func getFloatPointer(m map[string]interface{}, key string) (*float64, error) {
+	raw, ok := m[key]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+	switch val := raw.(type) {
+	case float64:
+		return &val, nil
+	case int:
+		f := float64(val)
+		return &f, nil
+	}
+	return nil, fmt.Errorf("%s must be a number", key)
+}
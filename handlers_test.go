@@ -0,0 +1,52 @@
+package tools
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/negokaz/visio-mcp-server/internal/visio"
+)
+
+func TestWriteShapeHandlerRejectsNonNumbers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "drawing.vsdx")
+	if err := visio.NewWriter(path).CreateNewDocument(); err != nil {
+		t.Fatal(err)
+	}
+	_, err := WriteShapeHandler(map[string]interface{}{
+		"fileAbsolutePath": path,
+		"pageName":         "Page-1",
+		"shapeData":        map[string]interface{}{"pinX": 1.0, "pinY": 2.0, "width": 3.0, "height": nil},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, shapeData := range []map[string]interface{}{
+		{"id": "1", "pinX": "abc"},
+		{"id": "1", "pinY": "2"},
+		{"id": "1", "width": true},
+		{"id": "1", "height": map[string]interface{}{}},
+	} {
+		_, err := WriteShapeHandler(map[string]interface{}{
+			"fileAbsolutePath": path,
+			"pageName":         "Page-1",
+			"shapeData":        shapeData,
+		})
+		if err == nil {
+			t.Errorf("%v: expected an error", shapeData)
+		}
+	}
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Error("the file was modified")
+	}
+}
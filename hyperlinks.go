@@ -0,0 +1,93 @@
+package visio
+
+// parseHyperlinks reads the rows of the Hyperlink section
+func parseHyperlinks(sheet *xmlSheet) []Hyperlink {
+	links := make([]Hyperlink, 0)
+	for _, section := range sheet.Sections {
+		if section.N != "Hyperlink" {
+			continue
+		}
+		for i := range section.Rows {
+			row := &section.Rows[i]
+			link := Hyperlink{
+				Name: row.N,
+			}
+			if c, ok := row.cell("Description"); ok {
+				link.Description = c.V
+			}
+			if c, ok := row.cell("Address"); ok {
+				link.Address = c.V
+			}
+			if c, ok := row.cell("SubAddress"); ok {
+				link.SubAddress = c.V
+			}
+			if c, ok := row.cell("ExtraInfo"); ok {
+				link.ExtraInfo = c.V
+			}
+			if c, ok := row.cell("Frame"); ok {
+				link.Frame = c.V
+			}
+			if c, ok := row.cell("NewWindow"); ok {
+				link.NewWindow = parseBool(c.V)
+			}
+			if c, ok := row.cell("Default"); ok {
+				link.Default = parseBool(c.V)
+			}
+			if c, ok := row.cell("Invisible"); ok {
+				link.Invisible = parseBool(c.V)
+			}
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// FindHyperlink returns the hyperlink of a shape with the given row name
+func FindHyperlink(shape *Shape, name string) (*Hyperlink, bool) {
+	for i := range shape.Hyperlinks {
+		if shape.Hyperlinks[i].Name == name {
+			return &shape.Hyperlinks[i], true
+		}
+	}
+	return nil, false
+}
+
+// SetHyperlink adds or replaces a hyperlink of a shape. A hyperlink without
+// a name is added as a new row. Returns the row name of the hyperlink.
+func (w *Writer) SetHyperlink(pageName, shapeID string, link Hyperlink) (string, error) {
+	err := w.updatePage(pageName, func(pkg *vsdxPackage, tree *xmlNode) error {
+		shape, err := shapeElement(tree, shapeID)
+		if err != nil {
+			return err
+		}
+		if link.Name == "" {
+			effective, err := effectiveShape(pkg, tree, shapeID)
+			if err != nil {
+				return err
+			}
+			link.Name = nextRowName(effective, "Hyperlink")
+		}
+		setNamedRow(shape, "Hyperlink", link.Name, []xmlCell{
+			{N: "Description", V: link.Description},
+			{N: "Address", V: link.Address},
+			{N: "SubAddress", V: link.SubAddress},
+			{N: "ExtraInfo", V: link.ExtraInfo},
+			{N: "Frame", V: link.Frame},
+			{N: "NewWindow", V: boolCellValue(link.NewWindow)},
+			{N: "Default", V: boolCellValue(link.Default)},
+			{N: "Invisible", V: boolCellValue(link.Invisible)},
+		})
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return link.Name, nil
+}
+
+// RemoveHyperlink removes a hyperlink row from a shape
+func (w *Writer) RemoveHyperlink(pageName, shapeID, name string) error {
+	return w.updatePage(pageName, func(pkg *vsdxPackage, tree *xmlNode) error {
+		return deleteNamedRow(pkg, tree, shapeID, "Hyperlink", name)
+	})
+}
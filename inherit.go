@@ -0,0 +1,187 @@
+package visio
+
+import "strings"
+
+// Shapes that are instances of a master only store the cells, rows and
+// text they override locally. The functions below overlay an instance on
+// its master shape so that readers see the values Visio displays.
+
+// inheritShape returns the effective shape for an instance of a master
+// shape. Local cells, section rows, text and foreign data take precedence; anything not
+// present locally is inherited from the master. Sections and rows marked
+// Del="1" locally suppress the inherited ones. Group members are kept
+// as-is and resolved separately against the same master.
+func inheritShape(local, master *xmlShape) xmlShape {
+	shape := *local
+	shape.Cells = mergeCells(master.Cells, local.Cells)
+	shape.Sections = mergeSections(master.Sections, local.Sections)
+	if shape.Text == nil {
+		shape.Text = master.Text
+	}
+	if shape.ForeignData == nil {
+		shape.ForeignData = master.ForeignData
+	}
+	if shape.LineStyle == "" {
+		shape.LineStyle = master.LineStyle
+	}
+	if shape.FillStyle == "" {
+		shape.FillStyle = master.FillStyle
+	}
+	if shape.TextStyle == "" {
+		shape.TextStyle = master.TextStyle
+	}
+	if shape.Type == "" {
+		shape.Type = master.Type
+	}
+	return shape
+}
+
+// mergeCells overlays local cells on inherited cells by cell name
+func mergeCells(inherited, local []xmlCell) []xmlCell {
+	if len(inherited) == 0 {
+		return local
+	}
+	merged := make([]xmlCell, 0, len(inherited)+len(local))
+	overridden := make(map[string]bool, len(local))
+	for _, c := range local {
+		overridden[c.N] = true
+	}
+	formulas := make(map[string]string, len(inherited))
+	for _, c := range inherited {
+		if !overridden[c.N] {
+			c.inherited = true
+			merged = append(merged, c)
+		}
+		formulas[c.N] = c.F
+		if isInheritedFormula(c.F) {
+			formulas[c.N] = c.inheritedF
+		}
+	}
+	for _, c := range local {
+		if isInheritedFormula(c.F) {
+			c.inheritedF = formulas[c.N]
+		}
+		merged = append(merged, c)
+	}
+	return merged
+}
+
+// isInheritedFormula reports whether a formula is the "Inh" marker Visio
+// writes for local values computed from the master's formula
+func isInheritedFormula(f string) bool {
+	return strings.EqualFold(f, "Inh")
+}
+
+// mergeSections overlays local sections on inherited sections. Sections
+// are matched by name and index, rows by name or index.
+func mergeSections(inherited, local []xmlSection) []xmlSection {
+	if len(inherited) == 0 {
+		return local
+	}
+	merged := make([]xmlSection, 0, len(inherited)+len(local))
+	used := make([]bool, len(local))
+	for _, in := range inherited {
+		i := findSection(local, in.N, in.IX)
+		if i < 0 {
+			merged = append(merged, inheritedSection(in))
+			continue
+		}
+		used[i] = true
+		if parseBool(local[i].Del) {
+			continue
+		}
+		section := local[i]
+		section.Cells = mergeCells(in.Cells, local[i].Cells)
+		section.Rows = mergeRows(in.Rows, local[i].Rows)
+		merged = append(merged, section)
+	}
+	for i, section := range local {
+		if !used[i] && !parseBool(section.Del) {
+			merged = append(merged, section)
+		}
+	}
+	return merged
+}
+
+// mergeRows overlays local rows on inherited rows
+func mergeRows(inherited, local []xmlRow) []xmlRow {
+	merged := make([]xmlRow, 0, len(inherited)+len(local))
+	used := make([]bool, len(local))
+	for _, in := range inherited {
+		i := findRow(local, in)
+		if i < 0 {
+			merged = append(merged, inheritedRow(in))
+			continue
+		}
+		used[i] = true
+		if parseBool(local[i].Del) {
+			continue
+		}
+		row := local[i]
+		row.Cells = mergeCells(in.Cells, local[i].Cells)
+		if row.T == "" {
+			row.T = in.T
+		}
+		merged = append(merged, row)
+	}
+	for i, row := range local {
+		if !used[i] && !parseBool(row.Del) {
+			merged = append(merged, row)
+		}
+	}
+	return merged
+}
+
+// inheritedSection returns a copy of a master section with its cells marked as inherited
+func inheritedSection(section xmlSection) xmlSection {
+	section.Cells = mergeCells(section.Cells, nil)
+	rows := make([]xmlRow, len(section.Rows))
+	for i, row := range section.Rows {
+		rows[i] = inheritedRow(row)
+	}
+	section.Rows = rows
+	return section
+}
+
+// inheritedRow returns a copy of a master row with its cells marked as inherited
+func inheritedRow(row xmlRow) xmlRow {
+	row.Cells = mergeCells(row.Cells, nil)
+	return row
+}
+
+// findSection returns the index of the section with the given name and index, or -1
+func findSection(sections []xmlSection, name, ix string) int {
+	for i, s := range sections {
+		if s.N == name && s.IX == ix {
+			return i
+		}
+	}
+	return -1
+}
+
+// findRow returns the index of the row matching target by name, or by
+// index for unnamed rows, or -1
+func findRow(rows []xmlRow, target xmlRow) int {
+	for i, r := range rows {
+		if target.N != "" && r.N == target.N {
+			return i
+		}
+		if target.N == "" && r.N == "" && r.IX == target.IX {
+			return i
+		}
+	}
+	return -1
+}
+
+// findShapeByID searches a shape tree for the shape with the given ID
+func findShapeByID(shapes []xmlShape, id string) *xmlShape {
+	for i := range shapes {
+		if shapes[i].ID == id {
+			return &shapes[i]
+		}
+		if found := findShapeByID(shapes[i].Shapes, id); found != nil {
+			return found
+		}
+	}
+	return nil
+}
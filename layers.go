@@ -0,0 +1,92 @@
+package visio
+
+import (
+	"strconv"
+	"strings"
+)
+
+// layerNoColor is the Color cell value of a layer that does not override
+// the colors of its shapes
+const layerNoColor = "255"
+
+// parseLayers reads the Layer section of a page sheet
+func parseLayers(sheet *xmlSheet, styles *styleResolver) []Layer {
+	layers := make([]Layer, 0)
+	for _, section := range sheet.Sections {
+		if section.N != "Layer" {
+			continue
+		}
+		for i := range section.Rows {
+			row := &section.Rows[i]
+			layer := Layer{
+				Index: i,
+			}
+			if ix, err := strconv.Atoi(row.IX); err == nil {
+				layer.Index = ix
+			}
+			cellValue := func(name string) string {
+				c, _ := row.cell(name)
+				return c.V
+			}
+			layer.Name = cellValue("Name")
+			layer.NameU = cellValue("NameUniv")
+			layer.Visible = parseBool(cellValue("Visible"))
+			layer.Print = parseBool(cellValue("Print"))
+			layer.Active = parseBool(cellValue("Active"))
+			layer.Lock = parseBool(cellValue("Lock"))
+			layer.Snap = parseBool(cellValue("Snap"))
+			layer.Glue = parseBool(cellValue("Glue"))
+			if c, ok := row.cell("Color"); ok && c.V != layerNoColor {
+				layer.Color = styles.colorValue(c, func() (xmlCell, bool) {
+					return xmlCell{}, false
+				})
+			}
+			layers = append(layers, layer)
+		}
+	}
+	return layers
+}
+
+// layerNames maps a LayerMember cell value, a semicolon-separated list of
+// layer indices, to layer names
+func layerNames(member string, layers []Layer) []string {
+	names := make([]string, 0)
+	for _, ix := range strings.Split(member, ";") {
+		n, err := strconv.Atoi(strings.TrimSpace(ix))
+		if err != nil {
+			continue
+		}
+		for _, layer := range layers {
+			if layer.Index == n {
+				names = append(names, layer.Name)
+				break
+			}
+		}
+	}
+	return names
+}
+
+// OnLayer reports whether a shape is a member of the named layer. The
+// layer is matched by name case-insensitively.
+func OnLayer(shape *Shape, layer string) bool {
+	for _, name := range shape.Layers {
+		if strings.EqualFold(name, layer) {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterShapesByLayer returns the shapes of a shape tree that are members
+// of the named layer. Groups that are not members themselves are kept when
+// any of their members are, so the tree structure is preserved.
+func FilterShapesByLayer(shapes []Shape, layer string) []Shape {
+	filtered := make([]Shape, 0)
+	for _, shape := range shapes {
+		shape.Children = FilterShapesByLayer(shape.Children, layer)
+		if OnLayer(&shape, layer) || len(shape.Children) > 0 {
+			filtered = append(filtered, shape)
+		}
+	}
+	return filtered
+}
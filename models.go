@@ -3,62 +3,448 @@ package visio
 // Document represents a Visio document structure
 type Document struct {
 	Pages      []Page
+	Masters    []Master
 	Properties DocumentProperties
+	Comments   []Comment
 }
 
-// DocumentProperties contains document metadata
+// DocumentProperties contains document metadata from the core, extended
+// and custom properties parts
 type DocumentProperties struct {
-	Title       string
-	Subject     string
-	Creator     string
-	Keywords    string
-	Description string
-	Created     string
-	Modified    string
+	Title          string
+	Subject        string
+	Creator        string
+	Keywords       string
+	Description    string
+	Category       string
+	ContentStatus  string
+	Language       string
+	LastModifiedBy string
+	Revision       string
+	Created        string
+	Modified       string
+	LastPrinted    string
+	Extended       ExtendedProperties
+	Custom         []CustomProperty
+}
+
+// ExtendedProperties contains the application properties of a document
+type ExtendedProperties struct {
+	Application   string
+	AppVersion    string
+	Company       string
+	Manager       string
+	Template      string
+	HyperlinkBase string
+}
+
+// CustomProperty is a user-defined document property
+type CustomProperty struct {
+	Name  string
+	Type  string // string, integer, number, boolean or date
+	Value string
+}
+
+// DocumentPropertiesUpdate lists document properties to change. Core and
+// extended properties are keyed by name, e.g. title or Company; an empty
+// value removes the property.
+type DocumentPropertiesUpdate struct {
+	Core     map[string]string
+	Extended map[string]string
+	Custom   []CustomPropertyEdit
+}
+
+// CustomPropertyEdit sets or deletes a custom property. The type of an
+// existing property is kept when Type is empty; new properties default to
+// string.
+type CustomPropertyEdit struct {
+	Name   string
+	Type   string
+	Value  string
+	Delete bool
 }
 
 // Page represents a single page in a Visio document
 type Page struct {
-	ID         string
+	ID           string
+	Name         string
+	NameU        string // Universal (locale-independent) name
+	Index        int    // Position in document order
+	IsBackground bool
+	Width        float64 // Page width in inches
+	Height       float64 // Page height in inches
+	Units        string  // Display units of the page size, e.g. IN or MM
+	PageScale    float64 // Page units per drawing scale unit, in inches
+	DrawingScale float64 // Drawing units represented by PageScale, in inches
+	DrawingUnits string  // Display units of the drawing scale
+	SizeType     int     // DrawingSizeType cell value
+	Layers       []Layer
+	Shapes       []Shape
+	Connections  []Connection
+	Structures   []Structure // Containers, lists and callouts with their members
+	Comments     []Comment   // Comments on the page and its shapes
+	Background   string      // Name of the background page, if any
+}
+
+// Comment is a reviewer comment on a page or one of its shapes. Visio
+// shows the comments on the same page or shape as one conversation, so a
+// reply is a further comment on the same anchor.
+type Comment struct {
+	ID       string
+	Author   string
+	Initials string
+	PageID   string
+	Page     string // Name of the page
+	ShapeID  string // Empty for comments on the page itself
+	Date     string
+	EditDate string
+	Done     bool // Marked as resolved
+	Text     string
+}
+
+// DataRecordset is a set of rows of linked external data together with the
+// shapes its rows are linked to
+type DataRecordset struct {
+	ID            string
+	Name          string
+	Command       string // Query that retrieved the data from its source
+	ConnectionID  string // Data connection the data was retrieved through
+	TimeRefreshed string
+	PrimaryKey    string // Column identifying rows across refreshes, if any
+	Part          string // Part holding the rows, e.g. visio/data/recordset1.xml
+	Columns       []DataColumn
+	Rows          []DataRow
+	Links         []DataLink
+}
+
+// DataColumn is a column of a data recordset
+type DataColumn struct {
+	Name     string // Shape data row the column is linked to, Prop.<Name>
+	Label    string
+	Source   string // Column name in the data source
+	DataType int    // Same values as the shape data Type cell
+}
+
+// DataRow is a row of a data recordset
+type DataRow struct {
+	ID     string
+	Values map[string]string // By column name
+}
+
+// DataLink links a shape to a row of a data recordset
+type DataLink struct {
+	RowID   string
+	PageID  string
+	Page    string // Name of the page
+	ShapeID string
+}
+
+// DataRefreshResult summarizes a refresh of a data recordset from new data
+type DataRefreshResult struct {
+	Recordset      string
+	RowsUpdated    int
+	RowsAdded      int
+	ShapesUpdated  int
+	UnmatchedRows  []string // IDs of rows missing from the new data; they are left unchanged
+	IgnoredColumns []string // Columns of the new data that match no recordset column
+}
+
+// Layer is a row of a page's Layer section
+type Layer struct {
+	Index   int // Row index, referenced by shapes' LayerMember cells
+	Name    string
+	NameU   string // Universal (locale-independent) name
+	Visible bool
+	Print   bool
+	Active  bool
+	Lock    bool
+	Snap    bool
+	Glue    bool
+	Color   string // #RRGGBB override for member shapes, empty if none
+}
+
+// Connection describes a connector and the shapes its end points are glued
+// to. A glue cell of PinX means the end point is glued to the whole shape
+// (dynamic glue); otherwise it names the connection point, e.g.
+// Connections.X1.
+type Connection struct {
+	ConnectorID string
+	FromShapeID string // Shape glued to the begin point
+	FromGlue    string // Cell of FromShape the begin point is glued to
+	ToShapeID   string // Shape glued to the end point
+	ToGlue      string // Cell of ToShape the end point is glued to
+}
+
+// Structure is a container, list or callout on a page. Swimlanes are
+// containers in the Swimlane category, usually members of a list formed by
+// a cross-functional flowchart. Membership is read from the Relationships
+// cells of the shapes involved.
+type Structure struct {
+	ShapeID    string
 	Name       string
-	Width      float64
-	Height     float64
-	Shapes     []Shape
-	Background string
+	Text       string
+	Type       string   // Container, Swimlane, List or Callout
+	Categories []string // Shape categories from User.msvShapeCategories
+	Members    []string // IDs of the shapes in a container or list
+	Containers []string // IDs of the containers and lists this one is a member of
+	Target     string   // ID of the shape a callout is associated with
 }
 
 // Shape represents a shape on a Visio page
 type Shape struct {
 	ID         string
 	Name       string
-	Text       string
+	Text       string    // Plain text, including the displayed value of fields
+	TextRuns   []TextRun // Text split into runs of uniform formatting
 	Type       string
-	PinX       float64 // X coordinate of rotation pin
-	PinY       float64 // Y coordinate of rotation pin
+	PinX       float64 // X coordinate of rotation pin, in parent coordinates
+	PinY       float64 // Y coordinate of rotation pin, in parent coordinates
+	PageX      float64 // X coordinate of rotation pin on the page
+	PageY      float64 // Y coordinate of rotation pin on the page
 	Width      float64
 	Height     float64
-	Master     string
-	Properties map[string]string
+	LocPinX    float64 // Rotation pin in local coordinates
+	LocPinY    float64
+	Angle      float64 // Rotation in radians
+	FlipX      bool
+	FlipY      bool
+	Layers     []string // Names of the layers the shape belongs to
+	ParentID   string   // ID of the containing group, if any
+	Children   []Shape  // Members of a group shape
+	Master     string   // Name of the master this shape is an instance of
+	MasterID   string
+	Properties map[string]string // Shape data values by row name
+	Data       []ShapeDataField  // Shape data rows with their metadata
+	User       []UserCell        // User-defined cells
+	Hyperlinks []Hyperlink
+	Foreign    *ForeignData // Image or embedded object of a foreign shape
+	Style      ShapeStyle
+	Geometry   []GeometryPath
+	Cells      []Cell         `json:"-"` // Reported by visio_read_shapesheet
+	Sections   []SheetSection `json:"-"` // Reported by visio_read_shapesheet
+}
+
+// ForeignData describes the image or embedded object displayed by a
+// foreign shape and the package part that holds it
+type ForeignData struct {
+	Type        string // ForeignType: Bitmap, EnhMetaFile, MetaFile, Object or Ink
+	Compression string // Compression of bitmaps, e.g. PNG or JPEG
+	ObjectType  string // Kind of embedded object, for objects
+	Part        string // Part holding the data, e.g. visio/media/image1.png
+	MIMEType    string
+	Size        int64 // Size of the data in bytes
+}
+
+// Cell is a ShapeSheet cell with its cached value, unit, formula and error
+// flag as stored in the file
+type Cell struct {
+	Name      string
+	Value     string // Cached result of the formula (V)
+	Unit      string // Units the value is displayed in (U)
+	Formula   string // Formula (F); empty for constants
+	Error     string // Error flag (E), set when the formula failed to evaluate
+	Inherited bool   // The cell is not set locally and comes from the master shape
+}
+
+// SheetSection is a ShapeSheet section with its section-level cells and rows
+type SheetSection struct {
+	Name  string
+	Index string // Set for indexed sections such as Geometry
+	Cells []Cell
+	Rows  []SheetRow
+}
+
+// SheetRow is a row of a ShapeSheet section
+type SheetRow struct {
+	Name  string // Set for named rows, e.g. in the Property and User sections
+	Index string // Set for indexed rows
+	Type  string // Row type, e.g. MoveTo or LineTo in Geometry sections
+	Cells []Cell
+}
+
+// GeometryPath is a Geometry section of a shape: one path with its
+// visibility flags
+type GeometryPath struct {
+	Index    int
+	NoFill   bool
+	NoLine   bool
+	NoShow   bool
+	NoSnap   bool
+	Segments []PathSegment
+}
+
+// PathSegment is a normalized geometry row. Coordinates are in the shape's
+// local coordinates, in inches; relative rows are converted to their
+// absolute equivalents (RelCubBezTo becomes CubicBezTo, RelQuadBezTo
+// becomes QuadBezTo).
+type PathSegment struct {
+	Type    string  // MoveTo, LineTo, ArcTo, EllipticalArcTo, CubicBezTo, QuadBezTo, PolylineTo, NURBSTo, SplineStart, SplineKnot, Ellipse or InfiniteLine
+	X       float64 // End point; the center for Ellipse
+	Y       float64
+	Control []Point // Bezier control points, the point an elliptical arc passes through, or an ellipse's axis points
+	Bow     float64 // ArcTo: distance from the chord midpoint to the arc
+	Angle   float64 // EllipticalArcTo: angle of the major axis, in radians
+	Ratio   float64 // EllipticalArcTo: major to minor axis ratio
+	Points  []Point // PolylineTo vertices, NURBSTo control points, or InfiniteLine's second point
+	Knots   []float64
+	Weights []float64
+	Degree  int
+}
+
+// Point is a position in local shape coordinates, in inches
+type Point struct {
+	X float64
+	Y float64
+}
+
+// TextRun is a span of shape text sharing character, paragraph and tab
+// formatting. Text fields form runs of their own.
+type TextRun struct {
+	Text      string
+	Character *CharacterFormat
+	Paragraph *ParagraphFormat
+	Tabs      int        // Index of the Tabs row in effect
+	Field     *TextField // Set when the run is the value of a text field
+}
+
+// CharacterFormat is the effective formatting of a Character section row
+type CharacterFormat struct {
+	Index     int // Row index, referenced by cp markers
+	Font      string
+	Color     string  // #RRGGBB
+	Size      float64 // Inches
+	Bold      bool
+	Italic    bool
+	Underline bool
+}
+
+// ParagraphFormat is the effective formatting of a Paragraph section row
+type ParagraphFormat struct {
+	Index     int // Row index, referenced by pp markers
+	HorzAlign int // 0 = left, 1 = center, 2 = right, 3 = justify, 4 = distributed
+	IndFirst  float64
+	IndLeft   float64
+	IndRight  float64
+	SpLine    float64
+	SpBefore  float64
+	SpAfter   float64
+	Bullet    int
+	BulletStr string
+}
+
+// TextField is a Field section row inserted into the text by a fld marker
+type TextField struct {
+	Index   int // Row index, referenced by fld markers
+	Value   string
+	Formula string
+	Format  string
+	Type    int
+}
+
+// ShapeDataField is a row of a shape's Property (Shape Data) section
+type ShapeDataField struct {
+	Name      string // Row name, referenced in formulas as Prop.<Name>
+	Label     string
+	Prompt    string
+	Type      int // 0 = string, 1 = fixed list, 2 = number, 3 = boolean, 4 = variable list, 5 = date, 6 = duration, 7 = currency
+	Format    string
+	Value     string
+	Invisible bool
+}
+
+// UserCell is a row of a shape's User section
+type UserCell struct {
+	Name    string // Row name, referenced in formulas as User.<Name>
+	Value   string
+	Unit    string
+	Formula string
+	Prompt  string
+}
+
+// Hyperlink is a row of a shape's Hyperlink section
+type Hyperlink struct {
+	Name        string // Row name, e.g. Row_1
+	Description string
+	Address     string // URL or file path
+	SubAddress  string // Location within the target, e.g. a page name
+	ExtraInfo   string // Query string or other data passed to the target
+	Frame       string
+	NewWindow   bool
+	Default     bool // Followed when the shape is double-clicked
+	Invisible   bool
+}
+
+// ShapeStyle is the effective line, fill and text formatting of a shape,
+// resolved through its master, style sheets and the document theme
+type ShapeStyle struct {
+	LineStyle   string  // Name of the line style sheet
+	FillStyle   string  // Name of the fill style sheet
+	TextStyle   string  // Name of the text style sheet
+	LineColor   string  // #RRGGBB
+	LineWeight  float64 // Inches
+	LinePattern int     // 0 = no line, 1 = solid
+	FillForegnd string  // #RRGGBB
+	FillBkgnd   string  // #RRGGBB
+	FillPattern int     // 0 = no fill, 1 = solid
+	FontName    string
+	FontColor   string  // #RRGGBB
+	FontSize    float64 // Inches
+	Bold        bool
+	Italic      bool
+	Underline   bool
+}
+
+// Master represents a master shape in the document stencil
+type Master struct {
+	ID       string
+	Name     string
+	NameU    string // Universal (locale-independent) name
+	Prompt   string
+	UniqueID string
+	BaseID   string
+	Hidden   bool
+	Shapes   []Shape
 }
 
 // PageInfo contains basic page information
 type PageInfo struct {
-	ID         string
-	Name       string
-	Width      float64
-	Height     float64
-	ShapeCount int
-	Background string
+	ID           string
+	Name         string
+	NameU        string // Universal (locale-independent) name
+	Index        int    // Position in document order
+	IsBackground bool
+	Width        float64 // Page width in inches
+	Height       float64 // Page height in inches
+	Units        string  // Display units of the page size, e.g. IN or MM
+	PageScale    float64 // Page units per drawing scale unit, in inches
+	DrawingScale float64 // Drawing units represented by PageScale, in inches
+	DrawingUnits string  // Display units of the drawing scale
+	SizeType     int     // DrawingSizeType cell value
+	ShapeCount   int
+	Background   string // Name of the background page, if any
 }
 
-// ShapeData is used for creating or updating shapes
+// ShapeData is used for creating or updating shapes. When ID names an
+// existing shape, only the fields that are set are written: nil position
+// and size fields and an empty name or text keep their current values.
 type ShapeData struct {
+	ID         string // Shape to update; empty to add a new shape
 	Name       string
 	Text       string
 	Type       string
-	PinX       float64
-	PinY       float64
-	Width      float64
-	Height     float64
+	PinX       *float64
+	PinY       *float64
+	Width      *float64
+	Height     *float64
 	Properties map[string]string
+	User       []UserCellEdit
+}
+
+// UserCellEdit sets or deletes a row of a shape's User section
+type UserCellEdit struct {
+	Name    string
+	Value   string // Cached value; computed from Formula when it can be evaluated
+	Formula string // Empty for a constant value
+	Prompt  string // Left unchanged when empty
+	Delete  bool
 }
@@ -0,0 +1,147 @@
+package visio
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Relationship types used to navigate a VSDX package
+const (
+	relTypeDocument       = "http://schemas.microsoft.com/visio/2010/relationships/document"
+	relTypeOfficeDocument = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument"
+	relTypePages          = "http://schemas.microsoft.com/visio/2010/relationships/pages"
+	relTypePage           = "http://schemas.microsoft.com/visio/2010/relationships/page"
+	relTypeMasters        = "http://schemas.microsoft.com/visio/2010/relationships/masters"
+	relTypeMaster         = "http://schemas.microsoft.com/visio/2010/relationships/master"
+	relTypeTheme          = "http://schemas.microsoft.com/visio/2010/relationships/theme"
+	relTypeComments       = "http://schemas.microsoft.com/visio/2010/relationships/comments"
+	relTypeRecordsets     = "http://schemas.microsoft.com/visio/2010/relationships/recordsets"
+
+	relTypeCoreProperties     = "http://schemas.openxmlformats.org/package/2006/relationships/metadata/core-properties"
+	relTypeExtendedProperties = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/extended-properties"
+	relTypeCustomProperties   = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/custom-properties"
+	relTypeThumbnail          = "http://schemas.openxmlformats.org/package/2006/relationships/metadata/thumbnail"
+	relTypeImage              = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/image"
+	relTypeOLEObject          = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/oleObject"
+)
+
+// xmlRelationships is the root element of a .rels part
+type xmlRelationships struct {
+	Relationships []xmlRelationship `xml:"Relationship"`
+}
+
+// xmlRelationship is a single relationship from a source part
+type xmlRelationship struct {
+	ID         string `xml:"Id,attr"`
+	Type       string `xml:"Type,attr"`
+	Target     string `xml:"Target,attr"`
+	TargetMode string `xml:"TargetMode,attr"`
+}
+
+// xmlContentTypes is the root element of [Content_Types].xml
+type xmlContentTypes struct {
+	Defaults []struct {
+		Extension   string `xml:"Extension,attr"`
+		ContentType string `xml:"ContentType,attr"`
+	} `xml:"Default"`
+	Overrides []struct {
+		PartName    string `xml:"PartName,attr"`
+		ContentType string `xml:"ContentType,attr"`
+	} `xml:"Override"`
+}
+
+// contentType returns the content type of a part: its override if it has
+// one, otherwise the default for its extension
+func (t *xmlContentTypes) contentType(part string) string {
+	for _, o := range t.Overrides {
+		if strings.EqualFold(strings.TrimPrefix(o.PartName, "/"), part) {
+			return o.ContentType
+		}
+	}
+	ext := strings.TrimPrefix(path.Ext(part), ".")
+	for _, d := range t.Defaults {
+		if strings.EqualFold(d.Extension, ext) {
+			return d.ContentType
+		}
+	}
+	return ""
+}
+
+// relsPartName returns the name of the relationships part for a source part,
+// e.g. visio/document.xml -> visio/_rels/document.xml.rels. An empty source
+// denotes the package itself.
+func relsPartName(source string) string {
+	dir, file := path.Split(source)
+	return dir + "_rels/" + file + ".rels"
+}
+
+// resolveTarget resolves a relationship target against its source part
+func resolveTarget(source, target string) string {
+	if strings.HasPrefix(target, "/") {
+		return strings.TrimPrefix(target, "/")
+	}
+	return path.Join(path.Dir(source), target)
+}
+
+// readRelationships reads the relationships of a source part. A missing
+// relationships part is not an error; it yields no relationships.
+func readRelationships(zipReader *zip.Reader, source string) ([]xmlRelationship, error) {
+	name := relsPartName(source)
+	if findZipFile(zipReader, name) == nil {
+		return nil, nil
+	}
+
+	data, err := readZipFile(zipReader, name)
+	if err != nil {
+		return nil, err
+	}
+
+	rels := xmlRelationships{}
+	if err := xml.Unmarshal(data, &rels); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+
+	// Resolve internal targets to part names once, so callers can use them directly
+	for i, rel := range rels.Relationships {
+		if rel.TargetMode != "External" {
+			rels.Relationships[i].Target = resolveTarget(source, rel.Target)
+		}
+	}
+	return rels.Relationships, nil
+}
+
+// relationshipByType returns the target of the first relationship of the given type
+func relationshipByType(rels []xmlRelationship, relTypes ...string) (string, bool) {
+	for _, relType := range relTypes {
+		for _, rel := range rels {
+			if rel.Type == relType {
+				return rel.Target, true
+			}
+		}
+	}
+	return "", false
+}
+
+// relationshipByID returns the target of the relationship with the given ID
+func relationshipByID(rels []xmlRelationship, id string) (string, bool) {
+	for _, rel := range rels {
+		if rel.ID == id {
+			return rel.Target, true
+		}
+	}
+	return "", false
+}
+
+// findZipFile returns the named part, matching names case-insensitively as
+// part names in an OPC package are
+func findZipFile(zipReader *zip.Reader, name string) *zip.File {
+	for _, file := range zipReader.File {
+		if strings.EqualFold(file.Name, name) {
+			return file
+		}
+	}
+	return nil
+}
@@ -0,0 +1,405 @@
+package visio
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// vsdxPackage is an opened VSDX package with its part graph resolved:
+// _rels/.rels -> visio/document.xml -> visio/pages/pages.xml -> page parts
+type vsdxPackage struct {
+	zip          *zip.Reader
+	rootRels     []xmlRelationship
+	documentPart string
+	documentRels []xmlRelationship
+	pages        []pageEntry
+	masters      []masterEntry
+	document     *xmlVisioDocument
+	styles       *styleResolver
+	comments     []Comment // Loaded on first use; non-nil once loaded
+	contentTypes *xmlContentTypes
+	partRels     map[string][]xmlRelationship // Relationships of parts, by part name
+}
+
+// pageEntry is a page listed in the pages part together with the name of
+// its contents part
+type pageEntry struct {
+	xml   xmlPage
+	index int
+	part  string
+}
+
+// masterEntry is a master listed in the masters part together with the
+// name of its contents part. Contents are decoded on first use.
+type masterEntry struct {
+	xml      xmlMaster
+	part     string
+	contents *xmlPageContents
+}
+
+// openPackage resolves the document and page parts of a VSDX package
+func openPackage(zipReader *zip.Reader) (*vsdxPackage, error) {
+	pkg := &vsdxPackage{
+		zip: zipReader,
+	}
+
+	rootRels, err := readRelationships(zipReader, "")
+	if err != nil {
+		return nil, err
+	}
+	pkg.rootRels = rootRels
+	documentPart, ok := relationshipByType(rootRels, relTypeDocument, relTypeOfficeDocument)
+	if !ok {
+		documentPart = "visio/document.xml"
+	}
+	pkg.documentPart = documentPart
+
+	pkg.documentRels, err = readRelationships(zipReader, documentPart)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := pkg.loadPages(); err != nil {
+		return nil, err
+	}
+	if err := pkg.loadMasters(); err != nil {
+		return nil, err
+	}
+	return pkg, nil
+}
+
+// loadPages reads the page list in document order from the pages part.
+// Packages without a pages part fall back to scanning for page parts.
+func (p *vsdxPackage) loadPages() error {
+	pagesPart, ok := relationshipByType(p.documentRels, relTypePages)
+	if !ok || findZipFile(p.zip, pagesPart) == nil {
+		return p.scanPages()
+	}
+
+	data, err := readZipFile(p.zip, pagesPart)
+	if err != nil {
+		return err
+	}
+	pages := xmlPages{}
+	if err := xml.Unmarshal(data, &pages); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", pagesPart, err)
+	}
+
+	pageRels, err := readRelationships(p.zip, pagesPart)
+	if err != nil {
+		return err
+	}
+
+	for i, page := range pages.Pages {
+		part, ok := relationshipByID(pageRels, page.Rel.ID)
+		if !ok {
+			return fmt.Errorf("page %q references missing relationship %q", page.Name, page.Rel.ID)
+		}
+		p.pages = append(p.pages, pageEntry{
+			xml:   page,
+			index: i,
+			part:  part,
+		})
+	}
+	return nil
+}
+
+// loadMasters reads the master list from the masters part, if the document has one
+func (p *vsdxPackage) loadMasters() error {
+	mastersPart, ok := relationshipByType(p.documentRels, relTypeMasters)
+	if !ok || findZipFile(p.zip, mastersPart) == nil {
+		return nil
+	}
+
+	data, err := readZipFile(p.zip, mastersPart)
+	if err != nil {
+		return err
+	}
+	masters := xmlMasters{}
+	if err := xml.Unmarshal(data, &masters); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", mastersPart, err)
+	}
+
+	masterRels, err := readRelationships(p.zip, mastersPart)
+	if err != nil {
+		return err
+	}
+
+	for _, master := range masters.Masters {
+		part, ok := relationshipByID(masterRels, master.Rel.ID)
+		if !ok {
+			return fmt.Errorf("master %q references missing relationship %q", master.Name, master.Rel.ID)
+		}
+		p.masters = append(p.masters, masterEntry{
+			xml:  master,
+			part: part,
+		})
+	}
+	return nil
+}
+
+// scanPages lists visio/pages/pageN.xml parts in numeric order. Page names
+// are taken from the document part when it lists the same number of pages,
+// otherwise from the part names.
+func (p *vsdxPackage) scanPages() error {
+	parts := make([]string, 0)
+	for _, file := range p.zip.File {
+		dir, name := path.Split(file.Name)
+		if dir == "visio/pages/" && strings.HasPrefix(name, "page") && strings.HasSuffix(name, ".xml") && name != "pages.xml" {
+			parts = append(parts, file.Name)
+		}
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return pagePartNumber(parts[i]) < pagePartNumber(parts[j])
+	})
+
+	listed := xmlVisioDocument{}
+	if data, err := readZipFile(p.zip, p.documentPart); err == nil {
+		// A malformed document part only costs us the page names
+		_ = xml.Unmarshal(data, &listed)
+	}
+
+	for i, part := range parts {
+		page := xmlPage{
+			ID:   strconv.Itoa(i),
+			Name: strings.TrimSuffix(path.Base(part), ".xml"),
+		}
+		if len(listed.Pages) == len(parts) {
+			page = listed.Pages[i]
+		}
+		p.pages = append(p.pages, pageEntry{
+			xml:   page,
+			index: i,
+			part:  part,
+		})
+	}
+	return nil
+}
+
+// pagePartNumber returns N for a part named pageN.xml
+func pagePartNumber(part string) int {
+	name := strings.TrimSuffix(strings.TrimPrefix(path.Base(part), "page"), ".xml")
+	n, err := strconv.Atoi(name)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// findPage returns the page whose name or universal name matches pageName
+func (p *vsdxPackage) findPage(pageName string) (*pageEntry, bool) {
+	for i := range p.pages {
+		if p.pages[i].name() == pageName {
+			return &p.pages[i], true
+		}
+	}
+	for i := range p.pages {
+		if p.pages[i].xml.NameU == pageName {
+			return &p.pages[i], true
+		}
+	}
+	return nil, false
+}
+
+// findPageByID returns the page with the given ID, or nil
+func (p *vsdxPackage) findPageByID(id string) *pageEntry {
+	for i := range p.pages {
+		if p.pages[i].xml.ID == id {
+			return &p.pages[i]
+		}
+	}
+	return nil
+}
+
+// pageContents decodes the contents part of a page
+func (p *vsdxPackage) pageContents(entry *pageEntry) (*xmlPageContents, error) {
+	data, err := readZipFile(p.zip, entry.part)
+	if err != nil {
+		return nil, err
+	}
+	return decodePageContents(data)
+}
+
+// findMaster returns the master with the given ID
+func (p *vsdxPackage) findMaster(id string) (*masterEntry, bool) {
+	for i := range p.masters {
+		if p.masters[i].xml.ID == id {
+			return &p.masters[i], true
+		}
+	}
+	return nil, false
+}
+
+// masterContents decodes the contents part of a master, caching the result
+func (p *vsdxPackage) masterContents(entry *masterEntry) (*xmlPageContents, error) {
+	if entry.contents != nil {
+		return entry.contents, nil
+	}
+	data, err := readZipFile(p.zip, entry.part)
+	if err != nil {
+		return nil, err
+	}
+	contents, err := decodePageContents(data)
+	if err != nil {
+		return nil, err
+	}
+	entry.contents = contents
+	return contents, nil
+}
+
+// resolveMaster returns the effective shape for a shape that is an instance
+// of a master, along with that master. Top-level instances name their
+// master in the Master attribute; members of an instantiated group name the
+// corresponding master sub-shape in MasterShape and inherit the master of
+// their group. Shapes that are not instances are returned unchanged.
+func (p *vsdxPackage) resolveMaster(xs *xmlShape, parentMaster *masterEntry) (xmlShape, *masterEntry, error) {
+	master := parentMaster
+	if xs.Master != "" {
+		m, ok := p.findMaster(xs.Master)
+		if !ok {
+			return *xs, nil, nil
+		}
+		master = m
+	} else if xs.MasterShape == "" {
+		return *xs, nil, nil
+	}
+	if master == nil {
+		return *xs, nil, nil
+	}
+
+	contents, err := p.masterContents(master)
+	if err != nil {
+		return *xs, nil, fmt.Errorf("failed to read master %s: %w", master.name(), err)
+	}
+
+	var masterShape *xmlShape
+	if xs.MasterShape != "" {
+		masterShape = findShapeByID(contents.Shapes, xs.MasterShape)
+	} else if len(contents.Shapes) > 0 {
+		masterShape = &contents.Shapes[0]
+	}
+	if masterShape == nil {
+		return *xs, master, nil
+	}
+	return inheritShape(xs, masterShape), master, nil
+}
+
+// relationships returns the relationships of a part, caching the result
+func (p *vsdxPackage) relationships(part string) ([]xmlRelationship, error) {
+	if rels, ok := p.partRels[part]; ok {
+		return rels, nil
+	}
+	rels, err := readRelationships(p.zip, part)
+	if err != nil {
+		return nil, err
+	}
+	if p.partRels == nil {
+		p.partRels = make(map[string][]xmlRelationship)
+	}
+	p.partRels[part] = rels
+	return rels, nil
+}
+
+// contentType returns the content type of a part as listed in
+// [Content_Types].xml. A missing or malformed list yields no types.
+func (p *vsdxPackage) contentType(part string) string {
+	if p.contentTypes == nil {
+		p.contentTypes = &xmlContentTypes{}
+		if data, err := readZipFile(p.zip, contentTypesPart); err == nil {
+			_ = xml.Unmarshal(data, p.contentTypes)
+		}
+	}
+	return p.contentTypes.contentType(part)
+}
+
+// loadDocument decodes the document part, caching the result
+func (p *vsdxPackage) loadDocument() (*xmlVisioDocument, error) {
+	if p.document != nil {
+		return p.document, nil
+	}
+	data, err := readZipFile(p.zip, p.documentPart)
+	if err != nil {
+		return nil, err
+	}
+	doc := &xmlVisioDocument{}
+	if err := xml.Unmarshal(data, doc); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", p.documentPart, err)
+	}
+	p.document = doc
+	return doc, nil
+}
+
+// name returns the display name of the master, falling back to its universal name
+func (e *masterEntry) name() string {
+	if e.xml.Name != "" {
+		return e.xml.Name
+	}
+	return e.xml.NameU
+}
+
+// name returns the display name of the page, falling back to its universal name
+func (e *pageEntry) name() string {
+	if e.xml.Name != "" {
+		return e.xml.Name
+	}
+	return e.xml.NameU
+}
+
+// pageSetup is the page size and scale read from a page sheet
+type pageSetup struct {
+	width        float64
+	height       float64
+	units        string
+	pageScale    float64
+	drawingScale float64
+	drawingUnits string
+	sizeType     int
+}
+
+// setup reads the page size and scale cells of the page sheet. Cached
+// values are always in inches; the U attribute only records the units
+// the page is displayed in.
+func (e *pageEntry) setup() pageSetup {
+	sheet := &e.xml.PageSheet
+	setup := pageSetup{
+		width:        sheet.cellFloat("PageWidth"),
+		height:       sheet.cellFloat("PageHeight"),
+		units:        "IN",
+		pageScale:    1,
+		drawingScale: 1,
+		sizeType:     int(sheet.cellFloat("DrawingSizeType")),
+	}
+	if c, ok := sheet.cell("PageWidth"); ok && c.U != "" {
+		setup.units = c.U
+	}
+	if c, ok := sheet.cell("PageScale"); ok && c.float() != 0 {
+		setup.pageScale = c.float()
+	}
+	setup.drawingUnits = setup.units
+	if c, ok := sheet.cell("DrawingScale"); ok {
+		if c.float() != 0 {
+			setup.drawingScale = c.float()
+		}
+		if c.U != "" {
+			setup.drawingUnits = c.U
+		}
+	}
+	return setup
+}
+
+// backgroundName returns the name of the background page assigned to a
+// page, or an empty string
+func (p *vsdxPackage) backgroundName(entry *pageEntry) string {
+	if entry.xml.BackPage == "" {
+		return ""
+	}
+	if background := p.findPageByID(entry.xml.BackPage); background != nil {
+		return background.name()
+	}
+	return ""
+}
@@ -0,0 +1,227 @@
+package visio
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Namespaces of the package-level parts an edit may create
+const (
+	contentTypesNamespace        = "http://schemas.openxmlformats.org/package/2006/content-types"
+	relationshipsNamespace       = "http://schemas.openxmlformats.org/package/2006/relationships"
+	officeRelationshipsNamespace = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+)
+
+// contentTypesPart is the part listing the content type of every part
+const contentTypesPart = "[Content_Types].xml"
+
+// packageEdit collects the parts changed by an edit of a package. Parts are
+// parsed on first access and serialized when the package is written.
+type packageEdit struct {
+	pkg   *vsdxPackage
+	trees map[string]*xmlNode // Edited parts, keyed by lower-cased part name
+	added []string            // Names of new parts, in the order they were added
+	blobs map[string][]byte   // Contents of new binary parts, keyed by lower-cased part name
+}
+
+// newPackageEdit starts an edit of a package
+func newPackageEdit(pkg *vsdxPackage) *packageEdit {
+	return &packageEdit{
+		pkg:   pkg,
+		trees: make(map[string]*xmlNode),
+		blobs: make(map[string][]byte),
+	}
+}
+
+// buildPackage creates a package in memory. The package starts with only
+// a content types part; build adds the other parts through a package edit.
+// Returns the zip data of the package.
+func buildPackage(build func(e *packageEdit) error) ([]byte, error) {
+	empty := &bytes.Buffer{}
+	if err := zip.NewWriter(empty).Close(); err != nil {
+		return nil, err
+	}
+	zipReader, err := zip.NewReader(bytes.NewReader(empty.Bytes()), int64(empty.Len()))
+	if err != nil {
+		return nil, err
+	}
+	e := newPackageEdit(&vsdxPackage{zip: zipReader})
+	types := newXMLElement("Types", "xmlns", contentTypesNamespace)
+	types.addElement("Default", nil, "Extension", "rels", "ContentType", "application/vnd.openxmlformats-package.relationships+xml")
+	types.addElement("Default", nil, "Extension", "xml", "ContentType", "application/xml")
+	e.trees[strings.ToLower(contentTypesPart)] = newXMLDocument(types)
+	e.added = append(e.added, contentTypesPart)
+	if err := build(e); err != nil {
+		return nil, err
+	}
+
+	data := &bytes.Buffer{}
+	zipWriter := zip.NewWriter(data)
+	for _, name := range e.added {
+		writer, err := zipWriter.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := writer.Write(e.addedContents(name)); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+	if err := zipWriter.Close(); err != nil {
+		return nil, err
+	}
+	return data.Bytes(), nil
+}
+
+// hasPart reports whether the package has a part, including parts added
+// by this edit
+func (e *packageEdit) hasPart(name string) bool {
+	if _, ok := e.trees[strings.ToLower(name)]; ok {
+		return true
+	}
+	if _, ok := e.blobs[strings.ToLower(name)]; ok {
+		return true
+	}
+	return findZipFile(e.pkg.zip, name) != nil
+}
+
+// part returns the tree of a part for editing
+func (e *packageEdit) part(name string) (*xmlNode, error) {
+	if tree, ok := e.trees[strings.ToLower(name)]; ok {
+		return tree, nil
+	}
+	file := findZipFile(e.pkg.zip, name)
+	if file == nil {
+		return nil, fmt.Errorf("part not found: %s", name)
+	}
+	data, err := readZipFile(e.pkg.zip, file.Name)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := parseXMLTree(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+	if tree.root() == nil {
+		return nil, fmt.Errorf("part %s has no root element", name)
+	}
+	e.trees[strings.ToLower(name)] = tree
+	return tree, nil
+}
+
+// updated returns the edited tree of a part in the original package, if any
+func (e *packageEdit) updated(name string) (*xmlNode, bool) {
+	tree, ok := e.trees[strings.ToLower(name)]
+	return tree, ok
+}
+
+// addPart adds a new part, registering its content type and a relationship
+// of the given type from the source part, or from the package itself when
+// source is empty. Returns the ID of the relationship.
+func (e *packageEdit) addPart(name, contentType, source, relType string, tree *xmlNode) (string, error) {
+	id, err := e.registerPart(name, contentType, source, relType)
+	if err != nil {
+		return "", err
+	}
+	e.trees[strings.ToLower(name)] = tree
+	e.added = append(e.added, name)
+	return id, nil
+}
+
+// addBinaryPart adds a new part that is not XML, such as an image, in the
+// same way as addPart
+func (e *packageEdit) addBinaryPart(name, contentType, source, relType string, data []byte) (string, error) {
+	id, err := e.registerPart(name, contentType, source, relType)
+	if err != nil {
+		return "", err
+	}
+	e.blobs[strings.ToLower(name)] = data
+	e.added = append(e.added, name)
+	return id, nil
+}
+
+// registerPart records the content type of a new part and adds the
+// relationship to it
+func (e *packageEdit) registerPart(name, contentType, source, relType string) (string, error) {
+	if e.hasPart(name) {
+		return "", fmt.Errorf("part already exists: %s", name)
+	}
+
+	types, err := e.part(contentTypesPart)
+	if err != nil {
+		return "", err
+	}
+	types.root().addElement("Override", nil, "PartName", "/"+name, "ContentType", contentType)
+
+	relsName := relsPartName(source)
+	if !e.hasPart(relsName) {
+		e.trees[strings.ToLower(relsName)] = newXMLDocument(newXMLElement("Relationships", "xmlns", relationshipsNamespace))
+		e.added = append(e.added, relsName)
+	}
+	rels, err := e.part(relsName)
+	if err != nil {
+		return "", err
+	}
+	id := nextRelationshipID(rels.root())
+	rels.root().addElement("Relationship", nil,
+		"Id", id,
+		"Type", relType,
+		"Target", relationshipTarget(source, name),
+	)
+	return id, nil
+}
+
+// addedContents returns the serialized contents of a new part
+func (e *packageEdit) addedContents(name string) []byte {
+	if data, ok := e.blobs[strings.ToLower(name)]; ok {
+		return data
+	}
+	tree, _ := e.updated(name)
+	return tree.bytes()
+}
+
+// nextRelationshipID returns the first unused ID of the form rIdN
+func nextRelationshipID(rels *xmlNode) string {
+	next := 1
+	for _, rel := range rels.elements("Relationship") {
+		id := rel.attr("Id")
+		if n, err := strconv.Atoi(strings.TrimPrefix(id, "rId")); err == nil && strings.HasPrefix(id, "rId") && n >= next {
+			next = n + 1
+		}
+	}
+	return "rId" + strconv.Itoa(next)
+}
+
+// relationshipTarget returns the target of a relationship from the source
+// part to another part, relative to the source where possible
+func relationshipTarget(source, name string) string {
+	if source == "" {
+		return name
+	}
+	dir := path.Dir(source)
+	if strings.HasPrefix(name, dir+"/") {
+		return strings.TrimPrefix(name, dir+"/")
+	}
+	return "/" + name
+}
+
+// namespacePrefix returns the prefix bound to a namespace on an element,
+// declaring it with the preferred prefix if the element has none
+func namespacePrefix(element *xmlNode, namespace, preferred string) string {
+	for _, a := range element.attrs {
+		if a.Value != namespace {
+			continue
+		}
+		if a.Name.Space == "xmlns" {
+			return a.Name.Local
+		}
+		if a.Name.Space == "" && a.Name.Local == "xmlns" {
+			return ""
+		}
+	}
+	element.setAttr("xmlns:"+preferred, namespace)
+	return preferred
+}
@@ -0,0 +1,255 @@
+package visio
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The types below mirror the VSDX page contents schema
+// (http://schemas.microsoft.com/office/visio/2012/main). Element and
+// attribute names are matched by local name only, so the decoder accepts
+// the default namespace as well as prefixed or namespace-less documents.
+
+// xmlPageContents is the root element of a page or master contents part
+type xmlPageContents struct {
+	Shapes   []xmlShape   `xml:"Shapes>Shape"`
+	Connects []xmlConnect `xml:"Connects>Connect"`
+}
+
+// xmlConnect records that a cell of one shape (usually a connector end
+// point) is glued to a cell of another shape
+type xmlConnect struct {
+	FromSheet string `xml:"FromSheet,attr"`
+	FromCell  string `xml:"FromCell,attr"`
+	FromPart  string `xml:"FromPart,attr"`
+	ToSheet   string `xml:"ToSheet,attr"`
+	ToCell    string `xml:"ToCell,attr"`
+	ToPart    string `xml:"ToPart,attr"`
+}
+
+// xmlSheet holds the cells and sections shared by shapes, page sheets and
+// style sheets
+type xmlSheet struct {
+	LineStyle string       `xml:"LineStyle,attr"`
+	FillStyle string       `xml:"FillStyle,attr"`
+	TextStyle string       `xml:"TextStyle,attr"`
+	Cells     []xmlCell    `xml:"Cell"`
+	Sections  []xmlSection `xml:"Section"`
+}
+
+// xmlShape is a single Shape element, including any nested group members
+type xmlShape struct {
+	xmlSheet
+	ID          string          `xml:"ID,attr"`
+	Type        string          `xml:"Type,attr"`
+	Name        string          `xml:"Name,attr"`
+	NameU       string          `xml:"NameU,attr"`
+	Master      string          `xml:"Master,attr"`
+	MasterShape string          `xml:"MasterShape,attr"`
+	UniqueID    string          `xml:"UniqueID,attr"`
+	Del         string          `xml:"Del,attr"`
+	Text        *xmlText        `xml:"Text"`
+	ForeignData *xmlForeignData `xml:"ForeignData"`
+	Shapes      []xmlShape      `xml:"Shapes>Shape"`
+}
+
+// xmlForeignData holds the image or embedded object of a foreign shape. The
+// data itself is stored in a separate part referenced by Rel.
+type xmlForeignData struct {
+	ForeignType     string `xml:"ForeignType,attr"`
+	CompressionType string `xml:"CompressionType,attr"`
+	ObjectType      string `xml:"ObjectType,attr"`
+	Rel             xmlRel `xml:"Rel"`
+}
+
+// xmlCell is a ShapeSheet cell with its cached value, unit, formula and error flag
+type xmlCell struct {
+	N string `xml:"N,attr"`
+	V string `xml:"V,attr"`
+	U string `xml:"U,attr"`
+	F string `xml:"F,attr"`
+	E string `xml:"E,attr"`
+
+	inherited  bool   // Set when the cell comes from the master shape
+	inheritedF string // Master formula of a local cell whose F is "Inh"
+}
+
+// xmlSection is a ShapeSheet section such as Geometry, Property or
+// Character. Geometry sections carry section-level cells (NoFill, NoLine,
+// ...) in addition to their rows.
+type xmlSection struct {
+	N     string    `xml:"N,attr"`
+	IX    string    `xml:"IX,attr"`
+	Del   string    `xml:"Del,attr"`
+	Cells []xmlCell `xml:"Cell"`
+	Rows  []xmlRow  `xml:"Row"`
+}
+
+// xmlRow is a row of a ShapeSheet section, addressed by index (IX) or name (N)
+type xmlRow struct {
+	N     string    `xml:"N,attr"`
+	IX    string    `xml:"IX,attr"`
+	T     string    `xml:"T,attr"`
+	Del   string    `xml:"Del,attr"`
+	Cells []xmlCell `xml:"Cell"`
+}
+
+// xmlPages is the root element of the pages part (visio/pages/pages.xml)
+type xmlPages struct {
+	Pages []xmlPage `xml:"Page"`
+}
+
+// xmlPage describes a page: its identity, page sheet and a relationship to
+// its contents part
+type xmlPage struct {
+	ID         string   `xml:"ID,attr"`
+	Name       string   `xml:"Name,attr"`
+	NameU      string   `xml:"NameU,attr"`
+	Background string   `xml:"Background,attr"`
+	BackPage   string   `xml:"BackPage,attr"`
+	PageSheet  xmlSheet `xml:"PageSheet"`
+	Rel        xmlRel   `xml:"Rel"`
+}
+
+// xmlRel references a relationship of the containing part by ID
+type xmlRel struct {
+	ID string `xml:"id,attr"`
+}
+
+// xmlMasters is the root element of the masters part (visio/masters/masters.xml)
+type xmlMasters struct {
+	Masters []xmlMaster `xml:"Master"`
+}
+
+// xmlMaster describes a master: its identity, page sheet and a relationship
+// to its contents part
+type xmlMaster struct {
+	ID        string   `xml:"ID,attr"`
+	Name      string   `xml:"Name,attr"`
+	NameU     string   `xml:"NameU,attr"`
+	Prompt    string   `xml:"Prompt,attr"`
+	UniqueID  string   `xml:"UniqueID,attr"`
+	BaseID    string   `xml:"BaseID,attr"`
+	Hidden    string   `xml:"Hidden,attr"`
+	PageSheet xmlSheet `xml:"PageSheet"`
+	Rel       xmlRel   `xml:"Rel"`
+}
+
+// xmlVisioDocument is the root element of the document part
+// (visio/document.xml). Pages are only listed here by documents that
+// predate the separate pages part.
+type xmlVisioDocument struct {
+	Settings      xmlDocumentSettings `xml:"DocumentSettings"`
+	Colors        []xmlColorEntry     `xml:"Colors>ColorEntry"`
+	StyleSheets   []xmlStyleSheet     `xml:"StyleSheets>StyleSheet"`
+	DocumentSheet xmlSheet            `xml:"DocumentSheet"`
+	Pages         []xmlPage           `xml:"Pages>Page"`
+}
+
+// xmlDocumentSettings holds the default styles applied to unstyled shapes
+type xmlDocumentSettings struct {
+	DefaultLineStyle string `xml:"DefaultLineStyle,attr"`
+	DefaultFillStyle string `xml:"DefaultFillStyle,attr"`
+	DefaultTextStyle string `xml:"DefaultTextStyle,attr"`
+}
+
+// xmlColorEntry is an entry of the document color table
+type xmlColorEntry struct {
+	IX  string `xml:"IX,attr"`
+	RGB string `xml:"RGB,attr"`
+}
+
+// xmlStyleSheet is a named style. Its LineStyle, FillStyle and TextStyle
+// attributes name the styles it inherits from.
+type xmlStyleSheet struct {
+	xmlSheet
+	ID    string `xml:"ID,attr"`
+	Name  string `xml:"Name,attr"`
+	NameU string `xml:"NameU,attr"`
+}
+
+// decodePageContents decodes a page or master contents part
+func decodePageContents(data []byte) (*xmlPageContents, error) {
+	contents := &xmlPageContents{}
+	if err := xml.Unmarshal(data, contents); err != nil {
+		return nil, fmt.Errorf("failed to parse page contents: %w", err)
+	}
+	return contents, nil
+}
+
+// cell returns the named cell of the sheet
+func (s *xmlSheet) cell(name string) (xmlCell, bool) {
+	for _, c := range s.Cells {
+		if c.N == name {
+			return c, true
+		}
+	}
+	return xmlCell{}, false
+}
+
+// cellFloat returns the cached numeric value of the named cell, or 0
+func (s *xmlSheet) cellFloat(name string) float64 {
+	c, ok := s.cell(name)
+	if !ok {
+		return 0
+	}
+	return c.float()
+}
+
+// cellValue returns the cached value of the named cell, or an empty string
+func (s *xmlSheet) cellValue(name string) string {
+	c, _ := s.cell(name)
+	return c.V
+}
+
+// float parses the cached value of the cell, returning 0 for empty or
+// non-numeric values such as "Themed"
+func (c xmlCell) float() float64 {
+	v, err := strconv.ParseFloat(c.V, 64)
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+// row returns the row of a named section, matched by row index. Sections
+// such as Character and Paragraph have a single instance per sheet. Rows
+// written without an IX attribute are matched by position.
+func (s *xmlSheet) row(section, ix string) (*xmlRow, bool) {
+	for i := range s.Sections {
+		if s.Sections[i].N != section {
+			continue
+		}
+		rows := s.Sections[i].Rows
+		for j := range rows {
+			if rows[j].IX == ix {
+				return &rows[j], true
+			}
+		}
+		if n, err := strconv.Atoi(ix); err == nil && n >= 0 && n < len(rows) && rows[n].IX == "" {
+			return &rows[n], true
+		}
+	}
+	return nil, false
+}
+
+// cell returns the named cell of the row
+func (r *xmlRow) cell(name string) (xmlCell, bool) {
+	for _, c := range r.Cells {
+		if c.N == name {
+			return c, true
+		}
+	}
+	return xmlCell{}, false
+}
+
+// parseBool parses a ShapeSheet boolean, which may be written as 1/0 or true/false
+func parseBool(v string) bool {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "1", "true":
+		return true
+	}
+	return false
+}
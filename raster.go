@@ -0,0 +1,217 @@
+package visio
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sort"
+)
+
+// point is a position in output pixel coordinates
+type point struct {
+	x, y float64
+}
+
+// rasterizer fills polygons into an RGBA image with anti-aliased edges.
+// Coverage is sampled on several sub-scanlines per pixel row and computed
+// exactly along each sub-scanline.
+type rasterizer struct {
+	img  *image.RGBA
+	clip image.Rectangle
+}
+
+// rasterSubsamples is the number of sub-scanlines sampled per pixel row
+const rasterSubsamples = 4
+
+// rasterEdge is a polygon edge with its winding direction
+type rasterEdge struct {
+	x0, y0, x1, y1 float64
+	dir            int
+}
+
+// fill paints the union of closed polygons with a color using the
+// even-odd or nonzero winding rule
+func (r *rasterizer) fill(polygons [][]point, c color.RGBA, evenOdd bool) {
+	if c.A == 0 {
+		return
+	}
+	edges := make([]rasterEdge, 0)
+	minY, maxY := math.Inf(1), math.Inf(-1)
+	minX, maxX := math.Inf(1), math.Inf(-1)
+	for _, polygon := range polygons {
+		for i := range polygon {
+			p, q := polygon[i], polygon[(i+1)%len(polygon)]
+			minX, maxX = math.Min(minX, p.x), math.Max(maxX, p.x)
+			minY, maxY = math.Min(minY, p.y), math.Max(maxY, p.y)
+			if p.y == q.y || math.IsNaN(p.y) || math.IsNaN(q.y) {
+				continue
+			}
+			if p.y < q.y {
+				edges = append(edges, rasterEdge{p.x, p.y, q.x, q.y, 1})
+			} else {
+				edges = append(edges, rasterEdge{q.x, q.y, p.x, p.y, -1})
+			}
+		}
+	}
+	if len(edges) == 0 {
+		return
+	}
+
+	bounds := image.Rect(int(math.Floor(minX)), int(math.Floor(minY)), int(math.Ceil(maxX))+1, int(math.Ceil(maxY))+1).Intersect(r.clip)
+	if bounds.Empty() {
+		return
+	}
+	coverage := make([]float64, bounds.Dx()+1)
+	type crossing struct {
+		x   float64
+		dir int
+	}
+	crossings := make([]crossing, 0, 16)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for i := range coverage {
+			coverage[i] = 0
+		}
+		touched := false
+		for s := 0; s < rasterSubsamples; s++ {
+			sy := float64(y) + (float64(s)+0.5)/rasterSubsamples
+			crossings = crossings[:0]
+			for _, e := range edges {
+				if sy < e.y0 || sy >= e.y1 {
+					continue
+				}
+				x := e.x0 + (sy-e.y0)*(e.x1-e.x0)/(e.y1-e.y0)
+				crossings = append(crossings, crossing{x, e.dir})
+			}
+			if len(crossings) < 2 {
+				continue
+			}
+			sort.Slice(crossings, func(i, j int) bool { return crossings[i].x < crossings[j].x })
+			winding := 0
+			for i := 0; i < len(crossings)-1; i++ {
+				if evenOdd {
+					winding ^= 1
+				} else {
+					winding += crossings[i].dir
+				}
+				if winding != 0 {
+					addSpan(coverage, crossings[i].x-float64(bounds.Min.X), crossings[i+1].x-float64(bounds.Min.X))
+					touched = true
+				}
+			}
+		}
+		if !touched {
+			continue
+		}
+		for i := 0; i < bounds.Dx(); i++ {
+			if coverage[i] > 0 {
+				r.blend(bounds.Min.X+i, y, c, math.Min(coverage[i]/rasterSubsamples, 1))
+			}
+		}
+	}
+}
+
+// addSpan adds the coverage of the span [x0, x1) on one sub-scanline,
+// counting partially covered pixels by the covered fraction
+func addSpan(coverage []float64, x0, x1 float64) {
+	limit := float64(len(coverage))
+	x0, x1 = math.Max(x0, 0), math.Min(x1, limit)
+	if x1 <= x0 {
+		return
+	}
+	first, last := int(x0), int(x1)
+	if first == last {
+		coverage[first] += x1 - x0
+		return
+	}
+	coverage[first] += float64(first+1) - x0
+	for i := first + 1; i < last; i++ {
+		coverage[i]++
+	}
+	if last < len(coverage) {
+		coverage[last] += x1 - float64(last)
+	}
+}
+
+// blend composites a color over a pixel with the given coverage
+func (r *rasterizer) blend(x, y int, c color.RGBA, coverage float64) {
+	alpha := coverage * float64(c.A) / 255
+	offset := r.img.PixOffset(x, y)
+	pix := r.img.Pix[offset : offset+4 : offset+4]
+	for i, v := range []uint8{c.R, c.G, c.B} {
+		pix[i] = uint8(math.Round(float64(v)*alpha + float64(pix[i])*(1-alpha)))
+	}
+	pix[3] = uint8(math.Round(255*alpha + float64(pix[3])*(1-alpha)))
+}
+
+// stroke paints polylines with the given width. Segments and joins are
+// filled as separate consistently oriented polygons, so the nonzero rule
+// paints their union.
+func (r *rasterizer) stroke(lines [][]point, width float64, c color.RGBA) {
+	half := math.Max(width, 1) / 2
+	polygons := make([][]point, 0)
+	for _, line := range lines {
+		for i := 0; i+1 < len(line); i++ {
+			p, q := line[i], line[i+1]
+			dx, dy := q.x-p.x, q.y-p.y
+			length := math.Hypot(dx, dy)
+			if length == 0 {
+				continue
+			}
+			nx, ny := -dy/length*half, dx/length*half
+			polygons = append(polygons, orient([]point{
+				{p.x + nx, p.y + ny}, {q.x + nx, q.y + ny},
+				{q.x - nx, q.y - ny}, {p.x - nx, p.y - ny},
+			}))
+		}
+		// Round joins and caps hide the gaps between wide segments
+		if half > 1 {
+			for _, p := range line {
+				polygons = append(polygons, orient(circlePoints(p, half)))
+			}
+		}
+	}
+	r.fill(polygons, c, false)
+}
+
+// orient returns a polygon with a positive signed area
+func orient(polygon []point) []point {
+	area := 0.0
+	for i := range polygon {
+		p, q := polygon[i], polygon[(i+1)%len(polygon)]
+		area += p.x*q.y - q.x*p.y
+	}
+	if area < 0 {
+		for i, j := 0, len(polygon)-1; i < j; i, j = i+1, j-1 {
+			polygon[i], polygon[j] = polygon[j], polygon[i]
+		}
+	}
+	return polygon
+}
+
+// circlePoints approximates a circle by a polygon
+func circlePoints(center point, radius float64) []point {
+	n := int(math.Max(8, math.Min(64, radius*2)))
+	points := make([]point, n)
+	for i := range points {
+		sin, cos := math.Sincos(2 * math.Pi * float64(i) / float64(n))
+		points[i] = point{center.x + radius*cos, center.y + radius*sin}
+	}
+	return points
+}
+
+// bezierPoints flattens a cubic Bézier curve, excluding its start point
+func bezierPoints(p0, p1, p2, p3 point) []point {
+	length := math.Hypot(p1.x-p0.x, p1.y-p0.y) + math.Hypot(p2.x-p1.x, p2.y-p1.y) + math.Hypot(p3.x-p2.x, p3.y-p2.y)
+	n := int(math.Max(2, math.Min(100, length/3)))
+	points := make([]point, n)
+	for i := 1; i <= n; i++ {
+		t := float64(i) / float64(n)
+		u := 1 - t
+		points[i-1] = point{
+			u*u*u*p0.x + 3*u*u*t*p1.x + 3*u*t*t*p2.x + t*t*t*p3.x,
+			u*u*u*p0.y + 3*u*u*t*p1.y + 3*u*t*t*p2.y + t*t*t*p3.y,
+		}
+	}
+	return points
+}
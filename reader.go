@@ -2,13 +2,10 @@ package visio
 
 import (
 	"archive/zip"
-	"encoding/xml"
+	"bytes"
 	"fmt"
 	"io"
 	"os"
-	"path/filepath"
-	"strconv"
-	"strings"
 )
 
 // Reader handles reading Visio files
@@ -23,339 +20,454 @@ func NewReader(filePath string) *Reader {
 	}
 }
 
+// openPackage opens the package of the file. Legacy binary drawings (.vsd)
+// and XML drawings (.vdx) are converted to a package in memory.
+func (r *Reader) openPackage() (*vsdxPackage, error) {
+	data, _, err := r.packageData()
+	if err != nil {
+		return nil, err
+	}
+	zipReader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open VSDX file: %w", err)
+	}
+	pkg, err := openPackage(zipReader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open VSDX package: %w", err)
+	}
+	return pkg, nil
+}
+
+// packageData returns the zip data of the file's package, converting
+// legacy and XML drawings. converted reports whether the file was
+// converted.
+func (r *Reader) packageData() ([]byte, bool, error) {
+	data, err := os.ReadFile(r.filePath)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open VSDX file: %w", err)
+	}
+	switch {
+	case isCompoundFile(data):
+		if data, err = convertVSD(data); err != nil {
+			return nil, false, fmt.Errorf("failed to read VSD file: %w", err)
+		}
+		return data, true, nil
+	case isVDX(data):
+		if data, err = convertVDX(data); err != nil {
+			return nil, false, fmt.Errorf("failed to read VDX file: %w", err)
+		}
+		return data, true, nil
+	}
+	return data, false, nil
+}
+
+// ConvertToVSDX writes the file, a Visio XML drawing (.vdx) or legacy
+// binary drawing (.vsd), as a VSDX package to targetPath
+func (r *Reader) ConvertToVSDX(targetPath string) error {
+	data, converted, err := r.packageData()
+	if err != nil {
+		return err
+	}
+	if !converted {
+		return fmt.Errorf("file is not a VDX or VSD drawing: %s", r.filePath)
+	}
+	if err := os.WriteFile(targetPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write VSDX file: %w", err)
+	}
+	return nil
+}
+
 // ReadDocument reads the entire Visio document
 func (r *Reader) ReadDocument() (*Document, error) {
-	zipReader, err := zip.OpenReader(r.filePath)
+	pkg, err := r.openPackage()
 	if err != nil {
-		return nil, fmt.Errorf("failed to open VSDX file: %w", err)
+		return nil, err
 	}
-	defer zipReader.Close()
 
 	doc := &Document{
-		Pages: make([]Page, 0),
+		Pages:   make([]Page, 0),
+		Masters: make([]Master, 0),
 	}
 
 	// Read document properties
-	props, err := r.readDocumentProperties(&zipReader.Reader)
+	props, err := readDocumentProperties(pkg)
 	if err == nil {
 		doc.Properties = props
 	}
 
 	// Read pages
-	pages, err := r.readPages(&zipReader.Reader)
+	pages, err := r.readPages(pkg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read pages: %w", err)
 	}
 	doc.Pages = pages
 
-	return doc, nil
-}
+	// Read masters
+	masters, err := r.readMasters(pkg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read masters: %w", err)
+	}
+	doc.Masters = masters
 
-// ListPages returns basic information about all pages
-func (r *Reader) ListPages() ([]PageInfo, error) {
-	zipReader, err := zip.OpenReader(r.filePath)
+	// Read comments
+	comments, err := pkg.loadComments()
 	if err != nil {
-		return nil, fmt.Errorf("failed to open VSDX file: %w", err)
+		return nil, fmt.Errorf("failed to read comments: %w", err)
 	}
-	defer zipReader.Close()
+	doc.Comments = comments
 
-	return r.listPagesFromZip(&zipReader.Reader)
+	return doc, nil
 }
 
-// ReadPage reads a specific page by name
-func (r *Reader) ReadPage(pageName string) (*Page, error) {
-	zipReader, err := zip.OpenReader(r.filePath)
+// ReadDocumentProperties reads the core, extended and custom properties
+// of the document
+func (r *Reader) ReadDocumentProperties() (*DocumentProperties, error) {
+	pkg, err := r.openPackage()
 	if err != nil {
-		return nil, fmt.Errorf("failed to open VSDX file: %w", err)
+		return nil, err
 	}
-	defer zipReader.Close()
 
-	pages, err := r.readPages(&zipReader.Reader)
+	props, err := readDocumentProperties(pkg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read pages: %w", err)
+		return nil, fmt.Errorf("failed to read document properties: %w", err)
 	}
+	return &props, nil
+}
 
-	for _, page := range pages {
-		if page.Name == pageName {
-			return &page, nil
-		}
+// ListPages returns basic information about all pages
+func (r *Reader) ListPages() ([]PageInfo, error) {
+	pkg, err := r.openPackage()
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, fmt.Errorf("page not found: %s", pageName)
+	return r.listPages(pkg)
 }
 
-// readDocumentProperties reads document metadata
-func (r *Reader) readDocumentProperties(zipReader *zip.Reader) (DocumentProperties, error) {
-	props := DocumentProperties{}
-
-	// Read core properties
-	for _, file := range zipReader.File {
-		if strings.HasSuffix(file.Name, "docProps/core.xml") {
-			rc, err := file.Open()
-			if err != nil {
-				return props, err
-			}
-			defer rc.Close()
-
-			data, err := io.ReadAll(rc)
-			if err != nil {
-				return props, err
-			}
-
-			// Simple XML parsing for core properties
-			// In production, use proper XML unmarshaling
-			content := string(data)
-			props.Title = extractXMLValue(content, "dc:title")
-			props.Subject = extractXMLValue(content, "dc:subject")
-			props.Creator = extractXMLValue(content, "dc:creator")
-			props.Keywords = extractXMLValue(content, "cp:keywords")
-			props.Description = extractXMLValue(content, "dc:description")
+// ReadPageInfo returns basic information about a page by name or universal name
+func (r *Reader) ReadPageInfo(pageName string) (*PageInfo, error) {
+	pkg, err := r.openPackage()
+	if err != nil {
+		return nil, err
+	}
 
-			break
-		}
+	entry, ok := pkg.findPage(pageName)
+	if !ok {
+		return nil, fmt.Errorf("page not found: %s", pageName)
 	}
 
-	return props, nil
+	info, err := r.readPageInfo(pkg, entry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read page %s: %w", pageName, err)
+	}
+	return &info, nil
 }
 
-// listPagesFromZip lists pages from zip reader
-func (r *Reader) listPagesFromZip(zipReader *zip.Reader) ([]PageInfo, error) {
-	pageInfos := make([]PageInfo, 0)
-
-	// Find and read document.xml to get page list
-	for _, file := range zipReader.File {
-		if strings.HasSuffix(file.Name, "visio/document.xml") {
-			rc, err := file.Open()
-			if err != nil {
-				return nil, err
-			}
-			defer rc.Close()
+// ListMasters returns the master catalog of the document
+func (r *Reader) ListMasters() ([]Master, error) {
+	pkg, err := r.openPackage()
+	if err != nil {
+		return nil, err
+	}
 
-			data, err := io.ReadAll(rc)
-			if err != nil {
-				return nil, err
-			}
+	return r.readMasters(pkg)
+}
 
-			// Parse document.xml to extract page information
-			// This is a simplified version - production code should use proper XML parsing
-			content := string(data)
-			_ = content // TODO: Parse XML to extract page information
+// ReadPage reads a specific page by name or universal name
+func (r *Reader) ReadPage(pageName string) (*Page, error) {
+	pkg, err := r.openPackage()
+	if err != nil {
+		return nil, err
+	}
 
-			break
-		}
+	entry, ok := pkg.findPage(pageName)
+	if !ok {
+		return nil, fmt.Errorf("page not found: %s", pageName)
 	}
 
-	// For now, scan for page files
-	pageFiles := make(map[string]bool)
-	for _, file := range zipReader.File {
-		if strings.Contains(file.Name, "visio/pages/page") && strings.HasSuffix(file.Name, ".xml") {
-			if !strings.Contains(file.Name, "_rels") {
-				pageFiles[file.Name] = true
-			}
-		}
+	page, err := r.readPage(pkg, entry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read page %s: %w", pageName, err)
 	}
+	return &page, nil
+}
+
+// listPages lists pages in document order
+func (r *Reader) listPages(pkg *vsdxPackage) ([]PageInfo, error) {
+	pageInfos := make([]PageInfo, 0, len(pkg.pages))
 
-	// Read each page file
-	for pageFile := range pageFiles {
-		pageInfo, err := r.readPageInfo(zipReader, pageFile)
-		if err == nil {
-			pageInfos = append(pageInfos, pageInfo)
+	for i := range pkg.pages {
+		pageInfo, err := r.readPageInfo(pkg, &pkg.pages[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read page %s: %w", pkg.pages[i].name(), err)
 		}
+		pageInfos = append(pageInfos, pageInfo)
 	}
 
 	return pageInfos, nil
 }
 
-// readPages reads all pages from the document
-func (r *Reader) readPages(zipReader *zip.Reader) ([]Page, error) {
-	pages := make([]Page, 0)
+// readPages reads all pages from the document in document order
+func (r *Reader) readPages(pkg *vsdxPackage) ([]Page, error) {
+	pages := make([]Page, 0, len(pkg.pages))
 
-	// Find page files
-	pageFiles := make(map[string]bool)
-	for _, file := range zipReader.File {
-		if strings.Contains(file.Name, "visio/pages/page") && strings.HasSuffix(file.Name, ".xml") {
-			if !strings.Contains(file.Name, "_rels") {
-				pageFiles[file.Name] = true
-			}
-		}
-	}
-
-	// Read each page
-	for pageFile := range pageFiles {
-		page, err := r.readPageFromFile(zipReader, pageFile)
-		if err == nil {
-			pages = append(pages, page)
+	for i := range pkg.pages {
+		page, err := r.readPage(pkg, &pkg.pages[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read page %s: %w", pkg.pages[i].name(), err)
 		}
+		pages = append(pages, page)
 	}
 
 	return pages, nil
 }
 
 // readPageInfo reads basic page information
-func (r *Reader) readPageInfo(zipReader *zip.Reader, pageFile string) (PageInfo, error) {
+func (r *Reader) readPageInfo(pkg *vsdxPackage, entry *pageEntry) (PageInfo, error) {
+	setup := entry.setup()
 	info := PageInfo{
-		ID:   filepath.Base(pageFile),
-		Name: "Page",
+		ID:           entry.xml.ID,
+		Name:         entry.name(),
+		NameU:        entry.xml.NameU,
+		Index:        entry.index,
+		IsBackground: parseBool(entry.xml.Background),
+		Width:        setup.width,
+		Height:       setup.height,
+		Units:        setup.units,
+		PageScale:    setup.pageScale,
+		DrawingScale: setup.drawingScale,
+		DrawingUnits: setup.drawingUnits,
+		SizeType:     setup.sizeType,
+		Background:   pkg.backgroundName(entry),
 	}
 
-	for _, file := range zipReader.File {
-		if file.Name == pageFile {
-			rc, err := file.Open()
-			if err != nil {
-				return info, err
-			}
-			defer rc.Close()
-
-			data, err := io.ReadAll(rc)
-			if err != nil {
-				return info, err
-			}
-
-			content := string(data)
-			info.Name = extractXMLValue(content, "Name")
-			if info.Name == "" {
-				info.Name = strings.TrimSuffix(filepath.Base(pageFile), ".xml")
-			}
-
-			// Count shapes (simplified)
-			info.ShapeCount = strings.Count(content, "<Shape ")
-
-			break
-		}
+	contents, err := pkg.pageContents(entry)
+	if err != nil {
+		return info, err
+	}
+	shapes, err := r.parseShapes(pkg, entry.part, contents, nil)
+	if err != nil {
+		return info, err
 	}
+	info.ShapeCount = len(FlattenShapes(shapes))
 
 	return info, nil
 }
 
-// readPageFromFile reads a complete page from a file
-func (r *Reader) readPageFromFile(zipReader *zip.Reader, pageFile string) (Page, error) {
+// readPage reads a complete page
+func (r *Reader) readPage(pkg *vsdxPackage, entry *pageEntry) (Page, error) {
+	setup := entry.setup()
 	page := Page{
-		ID:     filepath.Base(pageFile),
-		Name:   "Page",
-		Shapes: make([]Shape, 0),
+		ID:           entry.xml.ID,
+		Name:         entry.name(),
+		NameU:        entry.xml.NameU,
+		Index:        entry.index,
+		IsBackground: parseBool(entry.xml.Background),
+		Width:        setup.width,
+		Height:       setup.height,
+		Units:        setup.units,
+		PageScale:    setup.pageScale,
+		DrawingScale: setup.drawingScale,
+		DrawingUnits: setup.drawingUnits,
+		SizeType:     setup.sizeType,
+		Shapes:       make([]Shape, 0),
+		Background:   pkg.backgroundName(entry),
 	}
 
-	for _, file := range zipReader.File {
-		if file.Name == pageFile {
-			rc, err := file.Open()
-			if err != nil {
-				return page, err
-			}
-			defer rc.Close()
-
-			data, err := io.ReadAll(rc)
-			if err != nil {
-				return page, err
-			}
-
-			content := string(data)
-			page.Name = extractXMLValue(content, "Name")
-			if page.Name == "" {
-				page.Name = strings.TrimSuffix(filepath.Base(pageFile), ".xml")
-			}
+	styles, err := pkg.loadStyles()
+	if err != nil {
+		return page, fmt.Errorf("failed to read styles: %w", err)
+	}
+	page.Layers = parseLayers(&entry.xml.PageSheet, styles)
 
-			// Parse shapes (simplified - production should use proper XML parsing)
-			page.Shapes = r.parseShapes(content)
+	contents, err := pkg.pageContents(entry)
+	if err != nil {
+		return page, err
+	}
+	page.Shapes, err = r.parseShapes(pkg, entry.part, contents, page.Layers)
+	if err != nil {
+		return page, err
+	}
+	page.Connections = r.parseConnections(contents)
+	page.Structures = parseStructures(page.Shapes, contents)
 
-			break
-		}
+	comments, err := pkg.loadComments()
+	if err != nil {
+		return page, fmt.Errorf("failed to read comments: %w", err)
 	}
+	page.Comments = pageComments(comments, page.ID)
 
 	return page, nil
 }
 
-// parseShapes extracts shapes from page XML content
-func (r *Reader) parseShapes(content string) []Shape {
-	shapes := make([]Shape, 0)
-
-	// This is a simplified parser
-	// Production code should use encoding/xml with proper struct definitions
-	lines := strings.Split(content, "\n")
-	var currentShape *Shape
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
+// parseShapes converts decoded page contents into a shape tree, resolving
+// instances of masters against their master shapes. Members of a group are
+// returned as the group's Children, positioned in the group's local
+// coordinates, with page-absolute pin positions in PageX and PageY. part is
+// the contents part the shapes were decoded from.
+func (r *Reader) parseShapes(pkg *vsdxPackage, part string, contents *xmlPageContents, layers []Layer) ([]Shape, error) {
+	styles, err := pkg.loadStyles()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read styles: %w", err)
+	}
 
-		if strings.Contains(line, "<Shape ") {
-			currentShape = &Shape{
-				Properties: make(map[string]string),
+	var walk func(xmlShapes []xmlShape, parent *Shape, toPage transform, parentMaster *masterEntry) ([]Shape, error)
+	walk = func(xmlShapes []xmlShape, parent *Shape, toPage transform, parentMaster *masterEntry) ([]Shape, error) {
+		shapes := make([]Shape, 0, len(xmlShapes))
+		for i := range xmlShapes {
+			effective, master, err := pkg.resolveMaster(&xmlShapes[i], parentMaster)
+			if err != nil {
+				return nil, err
 			}
-			// Extract ID from Shape tag
-			if id := extractAttribute(line, "ID"); id != "" {
-				currentShape.ID = id
+			shape := r.buildShape(&effective, master)
+			shape.Style = styles.resolve(&effective)
+			shape.TextRuns = parseTextRuns(&effective, styles)
+			shape.Text = plainText(shape.TextRuns)
+			shape.Layers = layerNames(effective.cellValue("LayerMember"), layers)
+			if parent != nil {
+				shape.ParentID = parent.ID
 			}
-		}
-
-		if currentShape != nil {
-			if strings.Contains(line, "<Cell N=\"PinX\"") {
-				if val := extractAttribute(line, "V"); val != "" {
-					currentShape.PinX, _ = strconv.ParseFloat(val, 64)
+			shape.PageX, shape.PageY = toPage.apply(shape.PinX, shape.PinY)
+			if effective.ForeignData != nil {
+				// Inherited foreign data refers to the relationships of the master part
+				source := part
+				if xmlShapes[i].ForeignData == nil && master != nil {
+					source = master.part
 				}
-			}
-			if strings.Contains(line, "<Cell N=\"PinY\"") {
-				if val := extractAttribute(line, "V"); val != "" {
-					currentShape.PinY, _ = strconv.ParseFloat(val, 64)
+				shape.Foreign, err = pkg.resolveForeignData(effective.ForeignData, source)
+				if err != nil {
+					return nil, err
 				}
 			}
-			if strings.Contains(line, "<Cell N=\"Width\"") {
-				if val := extractAttribute(line, "V"); val != "" {
-					currentShape.Width, _ = strconv.ParseFloat(val, 64)
-				}
+
+			childMaster := parentMaster
+			if master != nil {
+				childMaster = master
 			}
-			if strings.Contains(line, "<Cell N=\"Height\"") {
-				if val := extractAttribute(line, "V"); val != "" {
-					currentShape.Height, _ = strconv.ParseFloat(val, 64)
+			if len(xmlShapes[i].Shapes) > 0 {
+				shape.Children, err = walk(xmlShapes[i].Shapes, &shape, toPage.then(shapeTransform(&shape)), childMaster)
+				if err != nil {
+					return nil, err
 				}
 			}
-			if strings.Contains(line, "<Text>") {
-				text := extractXMLValue(line, "Text")
-				currentShape.Text = text
-			}
-		}
-
-		if strings.Contains(line, "</Shape>") && currentShape != nil {
-			shapes = append(shapes, *currentShape)
-			currentShape = nil
+			shapes = append(shapes, shape)
 		}
+		return shapes, nil
 	}
-
-	return shapes
+	return walk(contents.Shapes, nil, identityTransform(), nil)
 }
 
-// Helper functions for simple XML parsing
+// buildShape converts a decoded Shape element into a Shape
+func (r *Reader) buildShape(xs *xmlShape, master *masterEntry) Shape {
+	shape := Shape{
+		ID:         xs.ID,
+		Name:       xs.Name,
+		Type:       xs.Type,
+		PinX:       xs.cellFloat("PinX"),
+		PinY:       xs.cellFloat("PinY"),
+		Width:      xs.cellFloat("Width"),
+		Height:     xs.cellFloat("Height"),
+		LocPinX:    xs.cellFloat("LocPinX"),
+		LocPinY:    xs.cellFloat("LocPinY"),
+		Angle:      xs.cellFloat("Angle"),
+		FlipX:      parseBool(xs.cellValue("FlipX")),
+		FlipY:      parseBool(xs.cellValue("FlipY")),
+		Properties: make(map[string]string),
+	}
+	if shape.Name == "" {
+		shape.Name = xs.NameU
+	}
+	shape.Geometry = parseGeometry(xs)
+	shape.Cells, shape.Sections = parseShapeSheet(&xs.xmlSheet)
+	shape.Data = parseShapeData(&xs.xmlSheet)
+	for _, field := range shape.Data {
+		shape.Properties[field.Name] = field.Value
+	}
+	shape.User = parseUserCells(&xs.xmlSheet)
+	shape.Hyperlinks = parseHyperlinks(&xs.xmlSheet)
+	if master != nil {
+		shape.Master = master.name()
+		shape.MasterID = master.xml.ID
+	}
+	return shape
+}
 
-func extractXMLValue(content, tag string) string {
-	startTag := "<" + tag + ">"
-	endTag := "</" + tag + ">"
+// parseConnections groups the Connect records of a page by connector. Only
+// records gluing a connector's begin or end point are reported.
+func (r *Reader) parseConnections(contents *xmlPageContents) []Connection {
+	connections := make([]Connection, 0)
+	index := make(map[string]int)
 
-	startIdx := strings.Index(content, startTag)
-	if startIdx == -1 {
-		return ""
+	for _, connect := range contents.Connects {
+		if connect.FromCell != "BeginX" && connect.FromCell != "EndX" {
+			continue
+		}
+		i, ok := index[connect.FromSheet]
+		if !ok {
+			i = len(connections)
+			index[connect.FromSheet] = i
+			connections = append(connections, Connection{
+				ConnectorID: connect.FromSheet,
+			})
+		}
+		if connect.FromCell == "BeginX" {
+			connections[i].FromShapeID = connect.ToSheet
+			connections[i].FromGlue = connect.ToCell
+		} else {
+			connections[i].ToShapeID = connect.ToSheet
+			connections[i].ToGlue = connect.ToCell
+		}
 	}
-	startIdx += len(startTag)
 
-	endIdx := strings.Index(content[startIdx:], endTag)
-	if endIdx == -1 {
-		return ""
+	return connections
+}
+
+// readMasters reads the master catalog with the shapes of each master
+func (r *Reader) readMasters(pkg *vsdxPackage) ([]Master, error) {
+	masters := make([]Master, 0, len(pkg.masters))
+
+	for i := range pkg.masters {
+		entry := &pkg.masters[i]
+		contents, err := pkg.masterContents(entry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read master %s: %w", entry.name(), err)
+		}
+		styles, err := pkg.loadStyles()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read styles: %w", err)
+		}
+		shapes, err := r.parseShapes(pkg, entry.part, contents, parseLayers(&entry.xml.PageSheet, styles))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read master %s: %w", entry.name(), err)
+		}
+		masters = append(masters, Master{
+			ID:       entry.xml.ID,
+			Name:     entry.name(),
+			NameU:    entry.xml.NameU,
+			Prompt:   entry.xml.Prompt,
+			UniqueID: entry.xml.UniqueID,
+			BaseID:   entry.xml.BaseID,
+			Hidden:   parseBool(entry.xml.Hidden),
+			Shapes:   shapes,
+		})
 	}
 
-	return strings.TrimSpace(content[startIdx : startIdx+endIdx])
+	return masters, nil
 }
 
-func extractAttribute(line, attr string) string {
-	attrStr := attr + "=\""
-	startIdx := strings.Index(line, attrStr)
-	if startIdx == -1 {
-		return ""
+// readZipFile returns the contents of the named part
+func readZipFile(zipReader *zip.Reader, name string) ([]byte, error) {
+	file := findZipFile(zipReader, name)
+	if file == nil {
+		return nil, fmt.Errorf("part not found: %s", name)
 	}
-	startIdx += len(attrStr)
 
-	endIdx := strings.Index(line[startIdx:], "\"")
-	if endIdx == -1 {
-		return ""
+	rc, err := file.Open()
+	if err != nil {
+		return nil, err
 	}
-
-	return line[startIdx : startIdx+endIdx]
+	defer rc.Close()
+	return io.ReadAll(rc)
 }
 
 // FileExists checks if a file exists
@@ -0,0 +1,252 @@
+package visio
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// cellPath locates a cell within a shape: a sheet cell, a section-level
+// cell or a cell of a section row
+type cellPath struct {
+	section   string
+	sectionIX string
+	row       string
+	rowIX     string
+	rowType   string
+	inRow     bool
+	cell      string
+}
+
+// cellUpdate is a recomputed cell value to write back to a page part.
+// Inherited updates are for cells the shape does not store locally; they
+// are written as local cells with an "Inh" formula, as Visio does.
+type cellUpdate struct {
+	shapeID   string
+	path      cellPath
+	value     string
+	unit      string
+	inherited bool
+}
+
+// recalculatePage recomputes the cached values of the formulas on a page
+// whose contents part has been edited, updating the V attributes in tree
+func recalculatePage(pkg *vsdxPackage, entry *pageEntry, tree *xmlNode) error {
+	contents, err := decodePageContents(tree.bytes())
+	if err != nil {
+		return err
+	}
+	shapes, err := effectiveShapes(pkg, contents.Shapes)
+	if err != nil {
+		return err
+	}
+
+	var docSheet *xmlSheet
+	if doc, err := pkg.loadDocument(); err == nil {
+		docSheet = &doc.DocumentSheet
+	}
+	var pageSheet *xmlSheet
+	if entry != nil {
+		pageSheet = &entry.xml.PageSheet
+	}
+
+	env := newFormulaEnv(shapes, pageSheet, docSheet)
+	applyCellUpdates(tree, env.recalculate(shapes))
+	return nil
+}
+
+// effectiveShapes resolves master inheritance for every shape of a shape
+// tree and returns the effective shapes in document order
+func effectiveShapes(pkg *vsdxPackage, xmlShapes []xmlShape) ([]*xmlShape, error) {
+	shapes := make([]*xmlShape, 0, len(xmlShapes))
+	var walk func(xmlShapes []xmlShape, parentMaster *masterEntry) error
+	walk = func(xmlShapes []xmlShape, parentMaster *masterEntry) error {
+		for i := range xmlShapes {
+			effective, master, err := pkg.resolveMaster(&xmlShapes[i], parentMaster)
+			if err != nil {
+				return err
+			}
+			shapes = append(shapes, &effective)
+			if master == nil {
+				master = parentMaster
+			}
+			if err := walk(xmlShapes[i].Shapes, master); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(xmlShapes, nil); err != nil {
+		return nil, err
+	}
+	return shapes, nil
+}
+
+// recalculate evaluates every formula of the given shapes and returns the
+// cells whose cached value changed
+func (env *formulaEnv) recalculate(shapes []*xmlShape) []cellUpdate {
+	updates := make([]cellUpdate, 0)
+	for _, shape := range shapes {
+		sheet := &shape.xmlSheet
+		visit := func(cells []xmlCell, path cellPath) {
+			for i := range cells {
+				c := &cells[i]
+				v, ok, _ := env.evaluateCell(sheet, c)
+				if !ok {
+					continue
+				}
+				value, unit := formatCellValue(c, v)
+				if sameCellValue(c.V, value) && c.U == unit {
+					continue
+				}
+				path.cell = c.N
+				updates = append(updates, cellUpdate{
+					shapeID:   shape.ID,
+					path:      path,
+					value:     value,
+					unit:      unit,
+					inherited: c.inherited,
+				})
+			}
+		}
+
+		visit(sheet.Cells, cellPath{})
+		for _, section := range sheet.Sections {
+			path := cellPath{section: section.N, sectionIX: section.IX}
+			visit(section.Cells, path)
+			for _, row := range section.Rows {
+				path.row, path.rowIX, path.rowType, path.inRow = row.N, row.IX, row.T, true
+				visit(row.Cells, path)
+			}
+		}
+	}
+	return updates
+}
+
+// formatCellValue formats a value for the V and U attributes of a cell
+func formatCellValue(c *xmlCell, v formulaValue) (string, string) {
+	if v.isStr {
+		return v.str, "STR"
+	}
+	if c.U == "BOOL" {
+		if _, err := strconv.ParseFloat(c.V, 64); err != nil {
+			// Booleans cached as TRUE or FALSE keep that form
+			if v.num != 0 {
+				return "TRUE", c.U
+			}
+			return "FALSE", c.U
+		}
+	}
+	unit := c.U
+	if unit == "STR" {
+		unit = ""
+	}
+	return formatFormulaNumber(v.num), unit
+}
+
+// sameCellValue reports whether a recomputed value matches the cached one,
+// allowing for the rounding of cached numbers
+func sameCellValue(cached, value string) bool {
+	if cached == value {
+		return true
+	}
+	a, errA := strconv.ParseFloat(cached, 64)
+	b, errB := strconv.ParseFloat(value, 64)
+	if errA != nil || errB != nil {
+		return false
+	}
+	return math.Abs(a-b) <= 1e-12*math.Max(1, math.Abs(a))
+}
+
+// Child elements of a Shape in schema order, used to insert new sections
+// and cells at a valid position
+var (
+	beforeShapeCell    = []string{"Trigger", "Section", "Text", "Data1", "Data2", "Data3", "ForeignData", "Shapes"}
+	beforeShapeSection = []string{"Text", "Data1", "Data2", "Data3", "ForeignData", "Shapes"}
+)
+
+// applyCellUpdates writes recomputed values into the Shape elements of a
+// page or master contents tree, creating local cells, rows and sections
+// for inherited cells
+func applyCellUpdates(tree *xmlNode, updates []cellUpdate) {
+	if len(updates) == 0 {
+		return
+	}
+	elements := shapeElements(tree)
+	for _, update := range updates {
+		shape, ok := elements[update.shapeID]
+		if !ok {
+			continue
+		}
+		parent, before := shape, beforeShapeCell
+		if update.path.section != "" {
+			parent = findOrAddElement(shape, "Section", beforeShapeSection, update.path.section, update.path.sectionIX)
+			before = []string{"Row"}
+			if update.path.inRow {
+				parent = findOrAddElement(parent, "Row", nil, update.path.row, update.path.rowIX)
+				if update.path.rowType != "" && parent.attr("T") == "" {
+					parent.setAttr("T", update.path.rowType)
+				}
+				before = nil
+			}
+		}
+
+		cell := parent.findElement("Cell", "N", update.path.cell)
+		if cell == nil {
+			cell = parent.addElement("Cell", before, "N", update.path.cell)
+			if update.inherited {
+				cell.setAttr("F", "Inh")
+			}
+		}
+		cell.setAttr("V", update.value)
+		if update.unit != "" {
+			cell.setAttr("U", update.unit)
+		} else {
+			cell.removeAttr("U")
+		}
+	}
+}
+
+// shapeElements indexes the Shape elements of a contents tree by ID
+func shapeElements(tree *xmlNode) map[string]*xmlNode {
+	elements := make(map[string]*xmlNode)
+	var walk func(node *xmlNode)
+	walk = func(node *xmlNode) {
+		for _, shapes := range node.elements("Shapes") {
+			for _, shape := range shapes.elements("Shape") {
+				elements[shape.attr("ID")] = shape
+				walk(shape)
+			}
+		}
+	}
+	if root := tree.root(); root != nil {
+		walk(root)
+	}
+	return elements
+}
+
+// findOrAddElement returns the child element with the given N and IX
+// attributes, creating it when missing. Elements written without IX match
+// any index.
+func findOrAddElement(parent *xmlNode, name string, before []string, n, ix string) *xmlNode {
+	for _, child := range parent.elements(name) {
+		if child.attr("N") != n {
+			continue
+		}
+		if childIX := child.attr("IX"); childIX == ix || (n != "" && (childIX == "" || ix == "")) {
+			return child
+		}
+	}
+	return parent.addElement(name, before, "N", n, "IX", ix)
+}
+
+// nextShapeID returns an ID one higher than any shape of a contents tree
+func nextShapeID(tree *xmlNode) string {
+	maxID := 0
+	for id := range shapeElements(tree) {
+		if n, err := strconv.Atoi(strings.TrimSpace(id)); err == nil && n > maxID {
+			maxID = n
+		}
+	}
+	return strconv.Itoa(maxID + 1)
+}
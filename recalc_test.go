@@ -0,0 +1,83 @@
+package visio
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteShapeRecalculatesDependentCells(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "drawing.vsdx")
+	writer := NewWriter(path)
+	if err := writer.CreateNewDocument(); err != nil {
+		t.Fatal(err)
+	}
+	width, height := 2.0, 1.0
+	if err := writer.WriteShape("Page-1", ShapeData{Name: "Box", Width: &width, Height: &height}, false); err != nil {
+		t.Fatal(err)
+	}
+	shape := readTestShape(t, path, "1")
+	if shape.LocPinX != 1 || shape.LocPinY != 0.5 {
+		t.Fatalf("new shape local pin = %v, %v, want 1, 0.5", shape.LocPinX, shape.LocPinY)
+	}
+
+	// LocPinX is Width*0.5, so it follows the new width
+	width = 5
+	if err := writer.WriteShape("Page-1", ShapeData{ID: "1", Width: &width}, false); err != nil {
+		t.Fatal(err)
+	}
+	shape = readTestShape(t, path, "1")
+	if shape.Width != 5 || shape.LocPinX != 2.5 || shape.LocPinY != 0.5 {
+		t.Errorf("resized shape = width %v, local pin %v, %v, want 5, 2.5, 0.5", shape.Width, shape.LocPinX, shape.LocPinY)
+	}
+}
+
+// readTestShape reads a shape of the first page of a file
+func readTestShape(t *testing.T, path, id string) Shape {
+	t.Helper()
+	page, err := NewReader(path).ReadPage("Page-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, shape := range page.Shapes {
+		if shape.ID == id {
+			return shape
+		}
+	}
+	t.Fatalf("shape %s not found", id)
+	return Shape{}
+}
+
+func TestRecalculateUpdates(t *testing.T) {
+	shapes := []*xmlShape{
+		{ID: "1", xmlSheet: xmlSheet{
+			Cells: []xmlCell{
+				{N: "Width", V: "4"},
+				{N: "LocPinX", V: "1", F: "Width*0.5"},
+				{N: "LocPinY", V: "0.5", F: "Height*0.5"},
+				{N: "Height", V: "1"},
+				{N: "PinX", V: "0", F: "Sheet.2!PinX+1 in", U: "IN"},
+			},
+			Sections: []xmlSection{
+				{N: "User", Rows: []xmlRow{{N: "Visible", Cells: []xmlCell{{N: "Value", V: "FALSE", U: "BOOL", F: "Width>3"}}}}},
+			},
+		}},
+		{ID: "2", xmlSheet: xmlSheet{Cells: []xmlCell{{N: "PinX", V: "3"}}}},
+	}
+	env := newFormulaEnv(shapes, nil, nil)
+	updates := env.recalculate(shapes)
+
+	want := map[string]cellUpdate{
+		"LocPinX": {shapeID: "1", value: "2"},
+		"PinX":    {shapeID: "1", value: "4", unit: "IN"},
+		"Value":   {shapeID: "1", value: "TRUE", unit: "BOOL"},
+	}
+	if len(updates) != len(want) {
+		t.Fatalf("got %d updates, want %d: %+v", len(updates), len(want), updates)
+	}
+	for _, u := range updates {
+		w, ok := want[u.path.cell]
+		if !ok || u.shapeID != w.shapeID || u.value != w.value || u.unit != w.unit {
+			t.Errorf("unexpected update %+v", u)
+		}
+	}
+}
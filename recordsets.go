@@ -0,0 +1,576 @@
+package visio
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Linked data is stored in visio/data/recordsets.xml, which describes each
+// recordset's columns and the shapes linked to its rows, and one part per
+// recordset holding the rows as an ADO persisted rowset. Shapes carry the
+// linked values in Property rows named after the columns.
+
+// xmlDataRecordSets is the root element of the recordsets part
+type xmlDataRecordSets struct {
+	RecordSets []xmlDataRecordSet `xml:"DataRecordSet"`
+}
+
+// xmlDataRecordSet is a DataRecordSet element
+type xmlDataRecordSet struct {
+	ID            string          `xml:"ID,attr"`
+	Name          string          `xml:"Name,attr"`
+	Command       string          `xml:"Command,attr"`
+	ConnectionID  string          `xml:"ConnectionID,attr"`
+	TimeRefreshed string          `xml:"TimeRefreshed,attr"`
+	Rel           string          `xml:"id,attr"`
+	Columns       []xmlDataColumn `xml:"DataColumns>DataColumn"`
+	PrimaryKey    struct {
+		Name string `xml:"PrimaryKeyName,attr"`
+	} `xml:"PrimaryKey"`
+	RowMaps []xmlRowMap `xml:"RowMap"`
+}
+
+// xmlDataColumn is a DataColumn element
+type xmlDataColumn struct {
+	ColumnNameID string `xml:"ColumnNameID,attr"`
+	Name         string `xml:"Name,attr"`
+	Label        string `xml:"Label,attr"`
+	DataType     string `xml:"DataType,attr"`
+}
+
+// xmlRowMap links a recordset row to a shape
+type xmlRowMap struct {
+	RowID   string `xml:"RowID,attr"`
+	PageID  string `xml:"PageID,attr"`
+	ShapeID string `xml:"ShapeID,attr"`
+}
+
+// adoRowset is the rows part of a recordset, persisted in the ADO XML
+// format: a schema mapping row attributes to column names, followed by
+// z:row elements in an rs:data element
+type adoRowset struct {
+	data      *xmlNode
+	columns   map[string]string // Row attribute by lower-cased column name
+	rowIDAttr string            // Row attribute holding the Visio row ID, if any
+}
+
+// adoRowIDColumn is the column Visio adds to identify rows
+const adoRowIDColumn = "_Visio_RowID_"
+
+// parseADORowset reads the schema of a persisted rowset
+func parseADORowset(tree *xmlNode) (*adoRowset, error) {
+	root := tree.root()
+	rowset := &adoRowset{
+		data:    root.child("data"),
+		columns: make(map[string]string),
+	}
+	if rowset.data == nil {
+		return nil, fmt.Errorf("recordset has no data element")
+	}
+	if schema := root.child("Schema"); schema != nil {
+		for _, elementType := range schema.elements("ElementType") {
+			for _, attributeType := range elementType.elements("AttributeType") {
+				attr := qualifiedAttr(attributeType, "name")
+				column := qualifiedAttr(attributeType, "rs:name")
+				if column == "" {
+					column = attr
+				}
+				if column == adoRowIDColumn {
+					rowset.rowIDAttr = attr
+				} else {
+					rowset.columns[strings.ToLower(column)] = attr
+				}
+			}
+		}
+	}
+	return rowset, nil
+}
+
+// rows returns the row elements, in order
+func (a *adoRowset) rows() []*xmlNode {
+	return a.data.elements("row")
+}
+
+// rowID returns the Visio ID of the row at the given position
+func (a *adoRowset) rowID(row *xmlNode, index int) string {
+	if a.rowIDAttr != "" {
+		if id := qualifiedAttr(row, a.rowIDAttr); id != "" {
+			return id
+		}
+	}
+	return strconv.Itoa(index + 1)
+}
+
+// qualifiedAttr returns the value of an attribute by its prefixed name, for
+// elements whose attributes share local names across namespaces
+func qualifiedAttr(n *xmlNode, name string) string {
+	qname := splitQName(name)
+	for _, a := range n.attrs {
+		if a.Name == qname {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// recordsetColumn is a recordset column with the row attribute holding
+// its values
+type recordsetColumn struct {
+	DataColumn
+	attr string
+}
+
+// recordsetColumns pairs the columns of a recordset with the attributes of
+// its rowset, matching them by source name, then by linked row name
+func recordsetColumns(columns []xmlDataColumn, rowset *adoRowset) []recordsetColumn {
+	result := make([]recordsetColumn, 0, len(columns))
+	for _, c := range columns {
+		dataType, _ := strconv.Atoi(c.DataType)
+		column := recordsetColumn{DataColumn: DataColumn{
+			Name:     c.ColumnNameID,
+			Label:    c.Label,
+			Source:   c.Name,
+			DataType: dataType,
+		}}
+		for _, name := range []string{c.Name, c.ColumnNameID} {
+			if attr, ok := rowset.columns[strings.ToLower(name)]; ok && name != "" {
+				column.attr = attr
+				break
+			}
+		}
+		result = append(result, column)
+	}
+	return result
+}
+
+// recordsetsPart returns the name of the recordsets part, which may not exist
+func (p *vsdxPackage) recordsetsPart() string {
+	if part, ok := relationshipByType(p.documentRels, relTypeRecordsets); ok {
+		return part
+	}
+	return "visio/data/recordsets.xml"
+}
+
+// readDataRecordsets reads the recordsets of the document with their rows
+// and shape links
+func (p *vsdxPackage) readDataRecordsets() ([]DataRecordset, error) {
+	recordsets := make([]DataRecordset, 0)
+	part := p.recordsetsPart()
+	if findZipFile(p.zip, part) == nil {
+		return recordsets, nil
+	}
+	data, err := readZipFile(p.zip, part)
+	if err != nil {
+		return nil, err
+	}
+	var xr xmlDataRecordSets
+	if err := xml.Unmarshal(data, &xr); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", part, err)
+	}
+	rels, err := p.relationships(part)
+	if err != nil {
+		return nil, err
+	}
+
+	pageNames := make(map[string]string, len(p.pages))
+	for i := range p.pages {
+		pageNames[p.pages[i].xml.ID] = p.pages[i].name()
+	}
+
+	for _, rs := range xr.RecordSets {
+		recordset := DataRecordset{
+			ID:            rs.ID,
+			Name:          rs.Name,
+			Command:       rs.Command,
+			ConnectionID:  rs.ConnectionID,
+			TimeRefreshed: rs.TimeRefreshed,
+			PrimaryKey:    rs.PrimaryKey.Name,
+			Columns:       make([]DataColumn, 0, len(rs.Columns)),
+			Rows:          make([]DataRow, 0),
+			Links:         make([]DataLink, 0, len(rs.RowMaps)),
+		}
+		for _, m := range rs.RowMaps {
+			recordset.Links = append(recordset.Links, DataLink{
+				RowID:   m.RowID,
+				PageID:  m.PageID,
+				Page:    pageNames[m.PageID],
+				ShapeID: m.ShapeID,
+			})
+		}
+
+		rowsPart, ok := relationshipByID(rels, rs.Rel)
+		file := findZipFile(p.zip, rowsPart)
+		if !ok || file == nil {
+			for _, c := range recordsetColumns(rs.Columns, &adoRowset{columns: map[string]string{}}) {
+				recordset.Columns = append(recordset.Columns, c.DataColumn)
+			}
+			recordsets = append(recordsets, recordset)
+			continue
+		}
+		recordset.Part = file.Name
+		rowData, err := readZipFile(p.zip, file.Name)
+		if err != nil {
+			return nil, err
+		}
+		tree, err := parseXMLTree(rowData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", file.Name, err)
+		}
+		rowset, err := parseADORowset(tree)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", file.Name, err)
+		}
+
+		columns := recordsetColumns(rs.Columns, rowset)
+		for _, c := range columns {
+			recordset.Columns = append(recordset.Columns, c.DataColumn)
+		}
+		for i, row := range rowset.rows() {
+			values := make(map[string]string, len(columns))
+			for _, c := range columns {
+				if c.attr != "" {
+					values[c.Name] = qualifiedAttr(row, c.attr)
+				}
+			}
+			recordset.Rows = append(recordset.Rows, DataRow{ID: rowset.rowID(row, i), Values: values})
+		}
+		recordsets = append(recordsets, recordset)
+	}
+	return recordsets, nil
+}
+
+// ListDataRecordsets returns the linked data recordsets of the document
+func (r *Reader) ListDataRecordsets() ([]DataRecordset, error) {
+	pkg, err := r.openPackage()
+	if err != nil {
+		return nil, err
+	}
+
+	recordsets, err := pkg.readDataRecordsets()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data recordsets: %w", err)
+	}
+	return recordsets, nil
+}
+
+// ReadDataFile reads rows of data from a CSV file with a header row or a
+// JSON file holding an array of objects. Returns the column names in the
+// order of the file and the rows keyed by column name.
+func ReadDataFile(filePath string) ([]string, []map[string]string, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".csv":
+		records, err := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))).ReadAll()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse CSV: %w", err)
+		}
+		if len(records) == 0 {
+			return nil, nil, fmt.Errorf("CSV file has no header row")
+		}
+		header := records[0]
+		rows := make([]map[string]string, 0, len(records)-1)
+		for _, record := range records[1:] {
+			row := make(map[string]string, len(header))
+			for i, name := range header {
+				if i < len(record) {
+					row[name] = record[i]
+				}
+			}
+			rows = append(rows, row)
+		}
+		return header, rows, nil
+
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		var objects []map[string]interface{}
+		if err := decoder.Decode(&objects); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse JSON: expected an array of objects: %w", err)
+		}
+		seen := make(map[string]bool)
+		columns := make([]string, 0)
+		rows := make([]map[string]string, 0, len(objects))
+		for _, object := range objects {
+			row := make(map[string]string, len(object))
+			for _, name := range sortedObjectKeys(object) {
+				if !seen[name] {
+					seen[name] = true
+					columns = append(columns, name)
+				}
+				switch v := object[name].(type) {
+				case nil:
+					row[name] = ""
+				case string:
+					row[name] = v
+				case json.Number:
+					row[name] = v.String()
+				case bool:
+					row[name] = strconv.FormatBool(v)
+				default:
+					encoded, _ := json.Marshal(v)
+					row[name] = string(encoded)
+				}
+			}
+			rows = append(rows, row)
+		}
+		return columns, rows, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported data file type: %s (expected .csv or .json)", filepath.Ext(filePath))
+}
+
+// sortedObjectKeys returns the keys of a JSON object in sorted order
+func sortedObjectKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// RefreshDataRecordset replaces the data of a recordset, named by ID or
+// name, with new rows and updates the shape data of the linked shapes.
+// Rows are matched on keyColumn, which defaults to the recordset's primary
+// key or its first column; columns are matched by linked row name, label
+// or source name. Rows without a match are added, and rows missing from
+// the new data are left unchanged.
+func (w *Writer) RefreshDataRecordset(recordset string, columns []string, rows []map[string]string, keyColumn string) (*DataRefreshResult, error) {
+	result := &DataRefreshResult{
+		UnmatchedRows:  make([]string, 0),
+		IgnoredColumns: make([]string, 0),
+	}
+	err := w.updatePackage(func(e *packageEdit) error {
+		part := e.pkg.recordsetsPart()
+		if !e.hasPart(part) {
+			return fmt.Errorf("document has no data recordsets")
+		}
+		tree, err := e.part(part)
+		if err != nil {
+			return err
+		}
+		element := findRecordsetElement(tree.root(), recordset)
+		if element == nil {
+			return fmt.Errorf("data recordset not found: %s", recordset)
+		}
+		result.Recordset = element.attr("ID")
+
+		var xrs xmlDataRecordSet
+		if err := xml.Unmarshal(element.bytes(), &xrs); err != nil {
+			return fmt.Errorf("failed to parse data recordset: %w", err)
+		}
+		rels, err := e.pkg.relationships(part)
+		if err != nil {
+			return err
+		}
+		rowsPart, ok := relationshipByID(rels, xrs.Rel)
+		if !ok || !e.hasPart(rowsPart) {
+			return fmt.Errorf("data of recordset %s not found", result.Recordset)
+		}
+		rowsTree, err := e.part(rowsPart)
+		if err != nil {
+			return err
+		}
+		rowset, err := parseADORowset(rowsTree)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", rowsPart, err)
+		}
+		recordsetCols := recordsetColumns(xrs.Columns, rowset)
+
+		// Match the columns of the new data to recordset columns
+		fileColumns := make(map[string]*recordsetColumn)
+		for _, name := range columns {
+			if c := matchRecordsetColumn(recordsetCols, name); c != nil && c.attr != "" {
+				fileColumns[name] = c
+			} else {
+				result.IgnoredColumns = append(result.IgnoredColumns, name)
+			}
+		}
+		if keyColumn == "" {
+			keyColumn = xrs.PrimaryKey.Name
+		}
+		if keyColumn == "" && len(recordsetCols) > 0 {
+			keyColumn = recordsetCols[0].Name
+		}
+		key := matchRecordsetColumn(recordsetCols, keyColumn)
+		if key == nil || key.attr == "" {
+			return fmt.Errorf("key column not found: %s", keyColumn)
+		}
+		keyField := ""
+		for name, c := range fileColumns {
+			if c.Name == key.Name {
+				keyField = name
+			}
+		}
+		if keyField == "" {
+			return fmt.Errorf("data has no values for key column %s", key.Name)
+		}
+
+		// Update matching rows and add the others
+		existing := make(map[string]*xmlNode)
+		rowIDs := make(map[*xmlNode]string)
+		for i, row := range rowset.rows() {
+			rowIDs[row] = rowset.rowID(row, i)
+			existing[qualifiedAttr(row, key.attr)] = row
+		}
+		nextRowID, _ := strconv.Atoi(element.attr("NextRowID"))
+		if nextRowID <= len(rowIDs) {
+			nextRowID = len(rowIDs) + 1
+		}
+		changed := make(map[string]map[string]string) // Values by column name, by row ID
+		seen := make(map[string]bool)
+		for _, values := range rows {
+			keyValue := values[keyField]
+			row, ok := existing[keyValue]
+			if ok {
+				if !seen[rowIDs[row]] {
+					result.RowsUpdated++
+				}
+			} else {
+				row = rowset.data.addElement("z:row", nil)
+				rowIDs[row] = strconv.Itoa(nextRowID)
+				nextRowID++
+				if rowset.rowIDAttr != "" {
+					row.setAttr(rowset.rowIDAttr, rowIDs[row])
+				}
+				if primaryKey := element.child("PrimaryKey"); primaryKey != nil {
+					primaryKey.addElement("RowKeyValue", nil, "RowID", rowIDs[row], "Value", keyValue)
+				}
+				existing[keyValue] = row
+				result.RowsAdded++
+			}
+			id := rowIDs[row]
+			seen[id] = true
+			if changed[id] == nil {
+				changed[id] = make(map[string]string)
+			}
+			for name, c := range fileColumns {
+				row.setAttr(c.attr, values[name])
+				changed[id][c.Name] = values[name]
+			}
+		}
+		for _, row := range rowset.rows() {
+			if !seen[rowIDs[row]] {
+				result.UnmatchedRows = append(result.UnmatchedRows, rowIDs[row])
+			}
+		}
+		element.setAttr("NextRowID", strconv.Itoa(nextRowID))
+		element.setAttr("TimeRefreshed", time.Now().UTC().Format("2006-01-02T15:04:05"))
+
+		// Write the new values to the linked shapes, page by page
+		links := make(map[string][]xmlRowMap)
+		pageIDs := make([]string, 0)
+		for _, m := range xrs.RowMaps {
+			if _, ok := changed[m.RowID]; !ok {
+				continue
+			}
+			if _, ok := links[m.PageID]; !ok {
+				pageIDs = append(pageIDs, m.PageID)
+			}
+			links[m.PageID] = append(links[m.PageID], m)
+		}
+		for _, pageID := range pageIDs {
+			entry := e.pkg.findPageByID(pageID)
+			if entry == nil {
+				continue
+			}
+			err := w.modifyPage(e, entry, func(pkg *vsdxPackage, tree *xmlNode) error {
+				shapes := shapeElements(tree)
+				for _, m := range links[pageID] {
+					shape, ok := shapes[m.ShapeID]
+					if !ok {
+						continue
+					}
+					for _, c := range recordsetCols {
+						if value, ok := changed[m.RowID][c.Name]; ok {
+							setLinkedValue(shape, c.DataColumn, value)
+						}
+					}
+					result.ShapesUpdated++
+				}
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("failed to update shapes on page %s: %w", entry.name(), err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// findRecordsetElement returns the DataRecordSet element with the given ID
+// or name
+func findRecordsetElement(root *xmlNode, recordset string) *xmlNode {
+	for _, element := range root.elements("DataRecordSet") {
+		if element.attr("ID") == recordset || strings.EqualFold(element.attr("Name"), recordset) {
+			return element
+		}
+	}
+	return nil
+}
+
+// matchRecordsetColumn returns the column with the given linked row name,
+// label or source name
+func matchRecordsetColumn(columns []recordsetColumn, name string) *recordsetColumn {
+	for _, match := range []func(c *recordsetColumn) string{
+		func(c *recordsetColumn) string { return c.Name },
+		func(c *recordsetColumn) string { return c.Label },
+		func(c *recordsetColumn) string { return c.Source },
+	} {
+		for i := range columns {
+			if strings.EqualFold(match(&columns[i]), name) {
+				return &columns[i]
+			}
+		}
+	}
+	return nil
+}
+
+// setLinkedValue writes a linked value to the shape data row of a column,
+// creating the row with the column's label and type when missing
+func setLinkedValue(shape *xmlNode, column DataColumn, value string) {
+	exists := false
+	for _, section := range shape.elements("Section") {
+		if section.attr("N") == "Property" && section.findElement("Row", "N", column.Name) != nil {
+			exists = true
+		}
+	}
+
+	cell := xmlCell{N: "Value", V: value, U: "STR"}
+	switch column.DataType {
+	case 2, 7:
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			cell.U = ""
+		}
+	case 3:
+		if b, err := strconv.ParseBool(value); err == nil {
+			cell.V, cell.U = strings.ToUpper(strconv.FormatBool(b)), "BOOL"
+		}
+	case 5:
+		cell.U = "DATE"
+	}
+	cells := []xmlCell{cell}
+	if !exists {
+		cells = append(cells,
+			xmlCell{N: "Label", V: column.Label, U: "STR"},
+			xmlCell{N: "Type", V: strconv.Itoa(column.DataType)},
+		)
+	}
+	setNamedRow(shape, "Property", column.Name, cells)
+}
@@ -0,0 +1,240 @@
+package visio
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testRecordsets = `<?xml version="1.0" encoding="utf-8"?>
+<DataRecordSets NextID="2" xmlns="http://schemas.microsoft.com/office/visio/2012/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<DataRecordSet ID="1" Name="Assets" NextRowID="3" r:id="rId1">
+<DataColumns>
+<DataColumn ColumnNameID="AssetTag" Name="Asset Tag" Label="Asset tag" DataType="0"/>
+<DataColumn ColumnNameID="Owner" Name="Owner" Label="Owner" DataType="0"/>
+<DataColumn ColumnNameID="Cost" Name="Cost" Label="Cost" DataType="2"/>
+</DataColumns>
+<PrimaryKey PrimaryKeyName="AssetTag"><RowKeyValue RowID="1" Value="A-100"/><RowKeyValue RowID="2" Value="A-200"/></PrimaryKey>
+<RowMap RowID="1" PageID="0" ShapeID="1"/>
+<RowMap RowID="2" PageID="0" ShapeID="2"/>
+</DataRecordSet>
+</DataRecordSets>`
+
+const testRecordsetRows = `<xml xmlns:s='uuid:BDC6E3F0-6DA3-11d1-A2A3-00C04FD619C7' xmlns:dt='uuid:C2F41010-65B3-11d1-A29F-00AA00C14882' xmlns:rs='urn:schemas-microsoft-com:rowset' xmlns:z='#RowsetSchema'>
+<s:Schema id='RowsetSchema'>
+<s:ElementType name='row' content='eltOnly'>
+<s:AttributeType name='c0' rs:name='Asset Tag'/>
+<s:AttributeType name='Owner'/>
+<s:AttributeType name='Cost'/>
+<s:AttributeType name='_Visio_RowID_'/>
+</s:ElementType>
+</s:Schema>
+<rs:data>
+<z:row c0='A-100' Owner='ops' Cost='1200' _Visio_RowID_='1'/>
+<z:row c0='A-200' Owner='net' Cost='300.5' _Visio_RowID_='2'/>
+</rs:data>
+</xml>`
+
+// createTestRecordsetDocument creates a document with a recordset of two
+// assets, each linked to a shape on the first page
+func createTestRecordsetDocument(t *testing.T) (*Writer, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "assets.vsdx")
+	writer := NewWriter(path)
+	if err := writer.CreateNewDocument(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Server", "Switch"} {
+		if err := writer.WriteShape("Page-1", ShapeData{Name: name}, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := writer.updatePackage(func(e *packageEdit) error {
+		err := writer.modifyPage(e, e.pkg.findPageByID("0"), func(pkg *vsdxPackage, tree *xmlNode) error {
+			shapes := shapeElements(tree)
+			for id, values := range map[string][2]string{"1": {"A-100", "ops"}, "2": {"A-200", "net"}} {
+				setNamedRow(shapes[id], "Property", "AssetTag", []xmlCell{{N: "Value", V: values[0], U: "STR"}})
+				setNamedRow(shapes[id], "Property", "Owner", []xmlCell{{N: "Value", V: values[1], U: "STR"}})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		recordsets, err := parseXMLTree([]byte(testRecordsets))
+		if err != nil {
+			return err
+		}
+		rows, err := parseXMLTree([]byte(testRecordsetRows))
+		if err != nil {
+			return err
+		}
+		if _, err := e.addPart("visio/data/recordsets.xml", "application/vnd.ms-visio.recordsets+xml", e.pkg.documentPart, relTypeRecordsets, recordsets); err != nil {
+			return err
+		}
+		_, err = e.addPart("visio/data/recordset1.xml", "application/vnd.ms-visio.recordset+xml", "visio/data/recordsets.xml",
+			"http://schemas.microsoft.com/visio/2010/relationships/recordset", rows)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writer, path
+}
+
+// readTestPropertyCells returns the cells of the shape data rows of a
+// shape on the first page, by row name
+func readTestPropertyCells(t *testing.T, path, id string) map[string]map[string]xmlCell {
+	t.Helper()
+	zipReader, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zipReader.Close()
+	pkg, err := openPackage(&zipReader.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := pkg.pageContents(pkg.findPageByID("0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := make(map[string]map[string]xmlCell)
+	for _, shape := range contents.Shapes {
+		if shape.ID != id {
+			continue
+		}
+		for _, section := range shape.Sections {
+			if section.N != "Property" {
+				continue
+			}
+			for _, row := range section.Rows {
+				rows[row.N] = make(map[string]xmlCell)
+				for _, cell := range row.Cells {
+					rows[row.N][cell.N] = cell
+				}
+			}
+		}
+	}
+	return rows
+}
+
+func TestRefreshDataRecordsetFromCSV(t *testing.T) {
+	writer, path := createTestRecordsetDocument(t)
+	dataPath := filepath.Join(t.TempDir(), "assets.csv")
+	csv := "Asset tag,Owner,Cost,Extra\nA-100,web,1500,x\nA-300,db,99,y\n"
+	if err := os.WriteFile(dataPath, []byte(csv), 0644); err != nil {
+		t.Fatal(err)
+	}
+	columns, rows, err := ReadDataFile(dataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := writer.RefreshDataRecordset("Assets", columns, rows, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &DataRefreshResult{
+		Recordset:      "1",
+		RowsUpdated:    1,
+		RowsAdded:      1,
+		UnmatchedRows:  []string{"2"},
+		IgnoredColumns: []string{"Extra"},
+		ShapesUpdated:  1,
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("result = %+v, want %+v", result, want)
+	}
+
+	// The matched shape gets the new values; the new cost row is numeric
+	// and labeled from its column
+	cells := readTestPropertyCells(t, path, "1")
+	if c := cells["Owner"]["Value"]; c.V != "web" || c.U != "STR" {
+		t.Errorf("Owner = %q (%s), want web (STR)", c.V, c.U)
+	}
+	if c := cells["AssetTag"]["Value"]; c.V != "A-100" {
+		t.Errorf("AssetTag = %q, want A-100", c.V)
+	}
+	cost := cells["Cost"]
+	if cost["Value"].V != "1500" || cost["Value"].U != "" || cost["Label"].V != "Cost" || cost["Type"].V != "2" {
+		t.Errorf("Cost = %+v, want 1500 without a unit, labeled Cost, of type 2", cost)
+	}
+
+	// The shape of the row missing from the data keeps its values
+	cells = readTestPropertyCells(t, path, "2")
+	if c := cells["Owner"]["Value"]; c.V != "net" {
+		t.Errorf("unmatched Owner = %q, want net", c.V)
+	}
+	if _, ok := cells["Cost"]; ok {
+		t.Error("unmatched shape got a Cost row")
+	}
+
+	recordsets, err := NewReader(path).ListDataRecordsets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recordsets) != 1 || len(recordsets[0].Rows) != 3 {
+		t.Fatalf("recordsets = %+v, want one with 3 rows", recordsets)
+	}
+	added := recordsets[0].Rows[2]
+	if added.ID != "3" || added.Values["AssetTag"] != "A-300" || added.Values["Cost"] != "99" {
+		t.Errorf("added row = %+v, want row 3 for A-300", added)
+	}
+}
+
+func TestRefreshDataRecordsetErrors(t *testing.T) {
+	writer, _ := createTestRecordsetDocument(t)
+	tests := []struct {
+		name      string
+		recordset string
+		columns   []string
+		key       string
+	}{
+		{"unknown recordset", "Missing", []string{"Asset tag"}, ""},
+		{"unknown key column", "Assets", []string{"Asset tag"}, "Serial"},
+		{"no key values", "1", []string{"Owner"}, ""},
+	}
+	for _, tt := range tests {
+		if _, err := writer.RefreshDataRecordset(tt.recordset, tt.columns, nil, tt.key); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+
+	empty := NewWriter(filepath.Join(t.TempDir(), "empty.vsdx"))
+	if err := empty.CreateNewDocument(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := empty.RefreshDataRecordset("Assets", []string{"Asset tag"}, nil, ""); err == nil {
+		t.Error("document without recordsets: expected an error")
+	}
+}
+
+func TestReadDataFileJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "assets.json")
+	data := `[{"Owner": "ops", "Cost": 1200.50, "Asset tag": "A-100"}, {"Asset tag": "A-200", "Active": true, "Owner": null}]`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	columns, rows, err := ReadDataFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Asset tag", "Cost", "Owner", "Active"}; !reflect.DeepEqual(columns, want) {
+		t.Errorf("columns = %v, want %v", columns, want)
+	}
+	want := []map[string]string{
+		{"Asset tag": "A-100", "Cost": "1200.50", "Owner": "ops"},
+		{"Asset tag": "A-200", "Active": "true", "Owner": ""},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %v, want %v", rows, want)
+	}
+
+	if _, _, err := ReadDataFile(filepath.Join(t.TempDir(), "assets.txt")); err == nil {
+		t.Error("unsupported file type: expected an error")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
 	"github.com/negokaz/visio-mcp-server/internal/tools"
+	"github.com/negokaz/visio-mcp-server/internal/visio"
 )
 
 // Server represents the MCP server
@@ -51,6 +52,8 @@ func (s *Server) registerTools() {
 					"type":        "string",
 					"description": "Absolute path to the Visio file",
 				},
+				"unit":  unitProperty(),
+				"scale": scaleProperty(),
 			},
 			Required: []string{"fileAbsolutePath"},
 		},
@@ -59,7 +62,7 @@ func (s *Server) registerTools() {
 	// Read page tool
 	s.mcp.AddTool(mcp.Tool{
 		Name:        "visio_read_page",
-		Description: "Read shapes and their properties from a specific page",
+		Description: "Read shapes and their properties from a specific page, with the members of its containers, swimlanes and lists and the targets of its callouts",
 		InputSchema: mcp.ToolInputSchema{
 			Type: "object",
 			Properties: map[string]interface{}{
@@ -73,9 +76,21 @@ func (s *Server) registerTools() {
 				},
 				"includeConnections": map[string]interface{}{
 					"type":        "boolean",
-					"description": "Include connector information",
+					"description": "Include connectors with the shapes and glue points their ends are attached to",
 					"default":     false,
 				},
+				"layout": map[string]interface{}{
+					"type":        "string",
+					"description": "Return group members nested under their group (tree) or as a flat list with page-absolute positions (flat)",
+					"enum":        []string{"tree", "flat"},
+					"default":     "tree",
+				},
+				"layer": map[string]interface{}{
+					"type":        "string",
+					"description": "Only return shapes on this layer (case-insensitive)",
+				},
+				"unit":  unitProperty(),
+				"scale": scaleProperty(),
 			},
 			Required: []string{"fileAbsolutePath", "pageName"},
 		},
@@ -96,11 +111,57 @@ func (s *Server) registerTools() {
 					"type":        "string",
 					"description": "Name of the page",
 				},
+				"shapeData": map[string]interface{}{
+					"type":        "object",
+					"description": "Only list shapes whose shape data matches every entry, keyed by row name or label (case-insensitive)",
+					"additionalProperties": map[string]interface{}{
+						"type": "string",
+					},
+				},
+				"layer": map[string]interface{}{
+					"type":        "string",
+					"description": "Only list shapes on this layer (case-insensitive)",
+				},
+				"unit":  unitProperty(),
+				"scale": scaleProperty(),
 			},
 			Required: []string{"fileAbsolutePath", "pageName"},
 		},
 	}, tools.ListShapesHandler)
 
+	// Read ShapeSheet tool
+	s.mcp.AddTool(mcp.Tool{
+		Name:        "visio_read_shapesheet",
+		Description: "Read every ShapeSheet cell of a shape with its value, unit, formula and error flag",
+		InputSchema: mcp.ToolInputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"fileAbsolutePath": map[string]interface{}{
+					"type":        "string",
+					"description": "Absolute path to the Visio file",
+				},
+				"pageName": map[string]interface{}{
+					"type":        "string",
+					"description": "Name of the page",
+				},
+				"shapeId": map[string]interface{}{
+					"type":        "string",
+					"description": "ID of the shape",
+				},
+				"sections": map[string]interface{}{
+					"type":        "array",
+					"description": "Only return these sections, e.g. Geometry, Property or User (default: all)",
+					"items": map[string]interface{}{
+						"type": "string",
+					},
+				},
+				"unit":  unitProperty(),
+				"scale": scaleProperty(),
+			},
+			Required: []string{"fileAbsolutePath", "pageName", "shapeId"},
+		},
+	}, tools.ReadShapeSheetHandler)
+
 	// Write shape tool
 	s.mcp.AddTool(mcp.Tool{
 		Name:        "visio_write_shape",
@@ -118,27 +179,66 @@ func (s *Server) registerTools() {
 				},
 				"shapeData": map[string]interface{}{
 					"type":        "object",
-					"description": "Shape properties (text, position, size, type)",
+					"description": "Shape properties (text, position, size, user cells). With an id, only the given fields of that shape are changed",
 					"properties": map[string]interface{}{
+						"id": map[string]interface{}{
+							"type":        "string",
+							"description": "ID of an existing shape to update; omit to add a new shape",
+						},
+						"name": map[string]interface{}{
+							"type":        "string",
+							"description": "Shape name",
+						},
 						"text": map[string]interface{}{
 							"type":        "string",
 							"description": "Shape text content",
 						},
 						"pinX": map[string]interface{}{
 							"type":        "number",
-							"description": "X coordinate (in inches)",
+							"description": "X coordinate, in the selected unit",
 						},
 						"pinY": map[string]interface{}{
 							"type":        "number",
-							"description": "Y coordinate (in inches)",
+							"description": "Y coordinate, in the selected unit",
 						},
 						"width": map[string]interface{}{
 							"type":        "number",
-							"description": "Shape width (in inches)",
+							"description": "Shape width, in the selected unit",
 						},
 						"height": map[string]interface{}{
 							"type":        "number",
-							"description": "Shape height (in inches)",
+							"description": "Shape height, in the selected unit",
+						},
+						"userCells": map[string]interface{}{
+							"type":        "array",
+							"description": "User-defined cells to set or delete",
+							"items": map[string]interface{}{
+								"type": "object",
+								"properties": map[string]interface{}{
+									"name": map[string]interface{}{
+										"type":        "string",
+										"description": "Row name, without the User. prefix",
+									},
+									"value": map[string]interface{}{
+										"type":        "string",
+										"description": "Cell value; computed from the formula when it can be evaluated",
+									},
+									"formula": map[string]interface{}{
+										"type":        "string",
+										"description": "ShapeSheet formula of the cell",
+									},
+									"prompt": map[string]interface{}{
+										"type":        "string",
+										"description": "Prompt text",
+									},
+									"delete": map[string]interface{}{
+										"type":        "boolean",
+										"description": "Delete the cell",
+										"default":     false,
+									},
+								},
+								"required": []string{"name"},
+							},
 						},
 					},
 				},
@@ -147,10 +247,453 @@ func (s *Server) registerTools() {
 					"description": "Create page if it doesn't exist",
 					"default":     false,
 				},
+				"unit":  unitProperty(),
+				"scale": scaleProperty(),
 			},
 			Required: []string{"fileAbsolutePath", "pageName", "shapeData"},
 		},
 	}, tools.WriteShapeHandler)
 
-	fmt.Fprintf(os.Stderr, "Registered %d tools\n", 4)
+	// Edit hyperlink tool
+	s.mcp.AddTool(mcp.Tool{
+		Name:        "visio_edit_hyperlink",
+		Description: "Add, update or remove a hyperlink of a shape",
+		InputSchema: mcp.ToolInputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"fileAbsolutePath": map[string]interface{}{
+					"type":        "string",
+					"description": "Absolute path to the Visio file",
+				},
+				"pageName": map[string]interface{}{
+					"type":        "string",
+					"description": "Name of the page",
+				},
+				"shapeId": map[string]interface{}{
+					"type":        "string",
+					"description": "ID of the shape",
+				},
+				"action": map[string]interface{}{
+					"type":        "string",
+					"description": "Add or update the hyperlink (set) or delete it (remove)",
+					"enum":        []string{"set", "remove"},
+					"default":     "set",
+				},
+				"name": map[string]interface{}{
+					"type":        "string",
+					"description": "Row name of the hyperlink, e.g. Row_1. Omit to add a new hyperlink",
+				},
+				"address": map[string]interface{}{
+					"type":        "string",
+					"description": "URL or file path the hyperlink opens",
+				},
+				"subAddress": map[string]interface{}{
+					"type":        "string",
+					"description": "Location within the target, e.g. a page name",
+				},
+				"description": map[string]interface{}{
+					"type":        "string",
+					"description": "Text shown for the hyperlink",
+				},
+				"extraInfo": map[string]interface{}{
+					"type":        "string",
+					"description": "Query string or other data passed to the target",
+				},
+				"frame": map[string]interface{}{
+					"type":        "string",
+					"description": "Target frame of the hyperlink",
+				},
+				"newWindow": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Open the hyperlink in a new window",
+				},
+				"default": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Follow this hyperlink when the shape is double-clicked",
+				},
+				"invisible": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Hide the hyperlink from the shape's shortcut menu",
+				},
+			},
+			Required: []string{"fileAbsolutePath", "pageName", "shapeId"},
+		},
+	}, tools.EditHyperlinkHandler)
+
+	// List comments tool
+	s.mcp.AddTool(mcp.Tool{
+		Name:        "visio_list_comments",
+		Description: "List reviewer comments with their authors, dates, resolution state and the page or shape they are attached to",
+		InputSchema: mcp.ToolInputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"fileAbsolutePath": map[string]interface{}{
+					"type":        "string",
+					"description": "Absolute path to the Visio file",
+				},
+				"pageName": map[string]interface{}{
+					"type":        "string",
+					"description": "Only list comments on this page and its shapes",
+				},
+				"shapeId": map[string]interface{}{
+					"type":        "string",
+					"description": "Only list comments on this shape; requires pageName",
+				},
+				"includeResolved": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Include comments marked as resolved",
+					"default":     true,
+				},
+			},
+			Required: []string{"fileAbsolutePath"},
+		},
+	}, tools.ListCommentsHandler)
+
+	// Edit comment tool
+	s.mcp.AddTool(mcp.Tool{
+		Name:        "visio_edit_comment",
+		Description: "Add a comment to a page or shape, reply to a comment, or mark a comment as resolved or open",
+		InputSchema: mcp.ToolInputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"fileAbsolutePath": map[string]interface{}{
+					"type":        "string",
+					"description": "Absolute path to the Visio file",
+				},
+				"action": map[string]interface{}{
+					"type":        "string",
+					"description": "add a comment, reply to a comment, resolve a comment or reopen a resolved one",
+					"enum":        []string{"add", "reply", "resolve", "reopen"},
+				},
+				"pageName": map[string]interface{}{
+					"type":        "string",
+					"description": "Page to comment on (add)",
+				},
+				"shapeId": map[string]interface{}{
+					"type":        "string",
+					"description": "Shape to comment on; omit to comment on the page (add)",
+				},
+				"commentId": map[string]interface{}{
+					"type":        "string",
+					"description": "ID of the comment to reply to, resolve or reopen, as listed by visio_list_comments",
+				},
+				"author": map[string]interface{}{
+					"type":        "string",
+					"description": "Name of the comment author (add, reply)",
+				},
+				"initials": map[string]interface{}{
+					"type":        "string",
+					"description": "Initials of the author; derived from the name when omitted (add, reply)",
+				},
+				"text": map[string]interface{}{
+					"type":        "string",
+					"description": "Comment text (add, reply)",
+				},
+			},
+			Required: []string{"fileAbsolutePath", "action"},
+		},
+	}, tools.EditCommentHandler)
+
+	// List data recordsets tool
+	s.mcp.AddTool(mcp.Tool{
+		Name:        "visio_list_data_recordsets",
+		Description: "List linked external data recordsets with their columns, rows and the shapes each row is linked to",
+		InputSchema: mcp.ToolInputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"fileAbsolutePath": map[string]interface{}{
+					"type":        "string",
+					"description": "Absolute path to the Visio file",
+				},
+			},
+			Required: []string{"fileAbsolutePath"},
+		},
+	}, tools.ListDataRecordsetsHandler)
+
+	// Refresh data recordset tool
+	s.mcp.AddTool(mcp.Tool{
+		Name:        "visio_refresh_data_recordset",
+		Description: "Refresh a linked data recordset from a local CSV or JSON file in place of its original data source, updating the shape data of linked shapes",
+		InputSchema: mcp.ToolInputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"fileAbsolutePath": map[string]interface{}{
+					"type":        "string",
+					"description": "Absolute path to the Visio file",
+				},
+				"recordset": map[string]interface{}{
+					"type":        "string",
+					"description": "ID or name of the recordset, as listed by visio_list_data_recordsets",
+				},
+				"dataFileAbsolutePath": map[string]interface{}{
+					"type":        "string",
+					"description": "Absolute path to a CSV file with a header row or a JSON file holding an array of objects",
+				},
+				"keyColumn": map[string]interface{}{
+					"type":        "string",
+					"description": "Column matching new rows to existing ones (default: the recordset's primary key, or its first column)",
+				},
+			},
+			Required: []string{"fileAbsolutePath", "recordset", "dataFileAbsolutePath"},
+		},
+	}, tools.RefreshDataRecordsetHandler)
+
+	// Get document properties tool
+	s.mcp.AddTool(mcp.Tool{
+		Name:        "visio_get_document_properties",
+		Description: "Read the core, extended and custom properties of a document",
+		InputSchema: mcp.ToolInputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"fileAbsolutePath": map[string]interface{}{
+					"type":        "string",
+					"description": "Absolute path to the Visio file",
+				},
+			},
+			Required: []string{"fileAbsolutePath"},
+		},
+	}, tools.GetDocumentPropertiesHandler)
+
+	// Set document properties tool
+	s.mcp.AddTool(mcp.Tool{
+		Name:        "visio_set_document_properties",
+		Description: "Update the core, extended and custom properties of a document",
+		InputSchema: mcp.ToolInputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"fileAbsolutePath": map[string]interface{}{
+					"type":        "string",
+					"description": "Absolute path to the Visio file",
+				},
+				"core": map[string]interface{}{
+					"type":        "object",
+					"description": "Core properties to set, e.g. title, subject, creator, keywords, description, category, contentStatus, language, lastModifiedBy, revision, created, modified, lastPrinted. An empty string removes the property",
+					"additionalProperties": map[string]interface{}{
+						"type": "string",
+					},
+				},
+				"extended": map[string]interface{}{
+					"type":        "object",
+					"description": "Extended properties to set: Application, AppVersion, Company, Manager, Template, HyperlinkBase. An empty string removes the property",
+					"additionalProperties": map[string]interface{}{
+						"type": "string",
+					},
+				},
+				"custom": map[string]interface{}{
+					"type":        "array",
+					"description": "Custom properties to set or delete",
+					"items": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"name": map[string]interface{}{
+								"type":        "string",
+								"description": "Property name",
+							},
+							"type": map[string]interface{}{
+								"type":        "string",
+								"description": "Value type; existing properties keep their type when omitted, new ones default to string",
+								"enum":        []string{"string", "integer", "number", "boolean", "date"},
+							},
+							"value": map[string]interface{}{
+								"type":        "string",
+								"description": "Property value; dates in the form 2006-01-02T15:04:05Z",
+							},
+							"delete": map[string]interface{}{
+								"type":        "boolean",
+								"description": "Delete the property",
+								"default":     false,
+							},
+						},
+						"required": []string{"name"},
+					},
+				},
+			},
+			Required: []string{"fileAbsolutePath"},
+		},
+	}, tools.SetDocumentPropertiesHandler)
+
+	// Read document tool
+	s.mcp.AddTool(mcp.Tool{
+		Name:        "visio_read_document",
+		Description: "Read document properties, all pages and masters, and optionally their shapes, in one request",
+		InputSchema: mcp.ToolInputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"fileAbsolutePath": map[string]interface{}{
+					"type":        "string",
+					"description": "Absolute path to the Visio file",
+				},
+				"depth": map[string]interface{}{
+					"type":        "string",
+					"description": "summary returns pages without shapes; shapes adds the shapes of each page",
+					"enum":        []string{"summary", "shapes"},
+					"default":     "shapes",
+				},
+				"shapeDepth": map[string]interface{}{
+					"type":        "integer",
+					"description": "Levels of group members to include below top-level shapes; all levels when omitted",
+					"minimum":     0,
+				},
+				"fields": map[string]interface{}{
+					"type":        "array",
+					"description": "Shape fields to return, e.g. Name, Text, PageX, PageY, Properties; all fields when omitted. ID and Children are always returned",
+					"items": map[string]interface{}{
+						"type": "string",
+					},
+				},
+				"pages": map[string]interface{}{
+					"type":        "array",
+					"description": "Names of the pages to include; all pages when omitted",
+					"items": map[string]interface{}{
+						"type": "string",
+					},
+				},
+				"includeConnections": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Include connector glue information of each page",
+					"default":     false,
+				},
+				"includeMasters": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Include the master catalog",
+					"default":     true,
+				},
+				"includeMasterShapes": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Include the shapes of each master",
+					"default":     false,
+				},
+				"unit":  unitProperty(),
+				"scale": scaleProperty(),
+			},
+			Required: []string{"fileAbsolutePath"},
+		},
+	}, tools.ReadDocumentHandler)
+
+	// Extract foreign data tool
+	s.mcp.AddTool(mcp.Tool{
+		Name:        "visio_extract_foreign_data",
+		Description: "Extract the image or embedded object of a foreign shape. PNG, JPEG and GIF images are returned as image content, other data, including EMF and WMF images, as an embedded resource",
+		InputSchema: mcp.ToolInputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"fileAbsolutePath": map[string]interface{}{
+					"type":        "string",
+					"description": "Absolute path to the Visio file",
+				},
+				"pageName": map[string]interface{}{
+					"type":        "string",
+					"description": "Name of the page",
+				},
+				"shapeId": map[string]interface{}{
+					"type":        "string",
+					"description": "ID of a shape with foreign data, as listed under Foreign by visio_read_page",
+				},
+			},
+			Required: []string{"fileAbsolutePath", "pageName", "shapeId"},
+		},
+	}, binaryResult(tools.ExtractForeignDataHandler))
+
+	// Get thumbnail tool
+	s.mcp.AddTool(mcp.Tool{
+		Name:        "visio_get_thumbnail",
+		Description: "Get the preview image stored with a Visio file. EMF thumbnails are rendered to PNG; text in them is not drawn",
+		InputSchema: mcp.ToolInputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"fileAbsolutePath": map[string]interface{}{
+					"type":        "string",
+					"description": "Absolute path to the Visio file",
+				},
+				"maxSize": map[string]interface{}{
+					"type":        "integer",
+					"description": "Length of the longer side of a rendered EMF thumbnail in pixels. JPEG and PNG thumbnails are returned unchanged",
+					"default":     visio.DefaultThumbnailSize,
+					"minimum":     1,
+					"maximum":     4096,
+				},
+			},
+			Required: []string{"fileAbsolutePath"},
+		},
+	}, binaryResult(tools.GetThumbnailHandler))
+
+	// Convert to VSDX tool
+	s.mcp.AddTool(mcp.Tool{
+		Name:        "visio_convert_to_vsdx",
+		Description: "Convert a Visio 2003 XML drawing (.vdx) or legacy binary drawing (.vsd) to a VSDX file that the write tools can edit",
+		InputSchema: mcp.ToolInputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"fileAbsolutePath": map[string]interface{}{
+					"type":        "string",
+					"description": "Absolute path to the .vdx or .vsd file",
+				},
+				"outputAbsolutePath": map[string]interface{}{
+					"type":        "string",
+					"description": "Absolute path of the VSDX file to write. Defaults to the source path with a .vsdx extension",
+				},
+				"overwrite": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Replace the output file if it exists",
+					"default":     false,
+				},
+			},
+			Required: []string{"fileAbsolutePath"},
+		},
+	}, tools.ConvertToVSDXHandler)
+
+	fmt.Fprintf(os.Stderr, "Registered %d tools\n", 16)
+}
+
+// imageContentTypes are the image types clients can display as image
+// content
+var imageContentTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// binaryResult adapts a handler that returns file data to MCP content:
+// PNG, JPEG, GIF and WebP images become image content, other data,
+// including EMF, WMF, TIFF and BMP images, an embedded blob resource. The
+// description is returned as text content ahead of the data.
+func binaryResult(handler func(arguments map[string]interface{}) (*tools.BinaryResult, error)) func(arguments map[string]interface{}) (*mcp.CallToolResult, error) {
+	return func(arguments map[string]interface{}) (*mcp.CallToolResult, error) {
+		result, err := handler(arguments)
+		if err != nil {
+			return nil, err
+		}
+		content := []mcp.Content{mcp.NewTextContent(result.Text)}
+		if imageContentTypes[result.MIMEType] {
+			content = append(content, mcp.NewImageContent(result.Data, result.MIMEType))
+		} else {
+			content = append(content, mcp.NewEmbeddedResource(mcp.BlobResourceContents{
+				URI:      result.URI,
+				MIMEType: result.MIMEType,
+				Blob:     result.Data,
+			}))
+		}
+		return &mcp.CallToolResult{Content: content}, nil
+	}
+}
+
+// unitProperty is the schema of the unit argument accepted by every tool
+func unitProperty() map[string]interface{} {
+	return map[string]interface{}{
+		"type":        "string",
+		"description": "Unit of lengths in the request and response",
+		"enum":        visio.Units(),
+		"default":     visio.UnitInches,
+	}
+}
+
+// scaleProperty is the schema of the scale argument accepted by every tool
+func scaleProperty() map[string]interface{} {
+	return map[string]interface{}{
+		"type":        "string",
+		"description": "Measure geometry in real-world drawing units (drawing) or on the printed page after applying the page's drawing scale (page)",
+		"enum":        []string{"drawing", "page"},
+		"default":     "drawing",
+	}
 }
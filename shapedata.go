@@ -0,0 +1,59 @@
+package visio
+
+import "strings"
+
+// parseShapeData reads the rows of the Property section
+func parseShapeData(sheet *xmlSheet) []ShapeDataField {
+	fields := make([]ShapeDataField, 0)
+	for _, section := range sheet.Sections {
+		if section.N != "Property" {
+			continue
+		}
+		for i := range section.Rows {
+			row := &section.Rows[i]
+			field := ShapeDataField{
+				Name: row.N,
+			}
+			if c, ok := row.cell("Label"); ok {
+				field.Label = c.V
+			}
+			if c, ok := row.cell("Prompt"); ok {
+				field.Prompt = c.V
+			}
+			if c, ok := row.cell("Type"); ok {
+				field.Type = int(c.float())
+			}
+			if c, ok := row.cell("Format"); ok {
+				field.Format = c.V
+			}
+			if c, ok := row.cell("Value"); ok {
+				field.Value = c.V
+			}
+			if c, ok := row.cell("Invisible"); ok {
+				field.Invisible = parseBool(c.V)
+			}
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// MatchShapeData reports whether a shape has a shape data value for every
+// entry of filter. Keys match a row name or label and values are compared
+// case-insensitively.
+func MatchShapeData(shape *Shape, filter map[string]string) bool {
+	for key, want := range filter {
+		matched := false
+		for _, field := range shape.Data {
+			if (strings.EqualFold(field.Name, key) || strings.EqualFold(field.Label, key)) &&
+				strings.EqualFold(field.Value, want) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
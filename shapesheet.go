@@ -0,0 +1,42 @@
+package visio
+
+// parseShapeSheet converts every cell and section of a sheet into the
+// generic ShapeSheet model, keeping formulas, units and error flags
+func parseShapeSheet(sheet *xmlSheet) ([]Cell, []SheetSection) {
+	cells := convertCells(sheet.Cells)
+	sections := make([]SheetSection, 0, len(sheet.Sections))
+	for _, section := range sheet.Sections {
+		rows := make([]SheetRow, 0, len(section.Rows))
+		for _, row := range section.Rows {
+			rows = append(rows, SheetRow{
+				Name:  row.N,
+				Index: row.IX,
+				Type:  row.T,
+				Cells: convertCells(row.Cells),
+			})
+		}
+		sections = append(sections, SheetSection{
+			Name:  section.N,
+			Index: section.IX,
+			Cells: convertCells(section.Cells),
+			Rows:  rows,
+		})
+	}
+	return cells, sections
+}
+
+// convertCells converts decoded cells to Cells
+func convertCells(xmlCells []xmlCell) []Cell {
+	cells := make([]Cell, 0, len(xmlCells))
+	for _, c := range xmlCells {
+		cells = append(cells, Cell{
+			Name:      c.N,
+			Value:     c.V,
+			Unit:      c.U,
+			Formula:   c.F,
+			Error:     c.E,
+			Inherited: c.inherited,
+		})
+	}
+	return cells
+}
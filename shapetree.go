@@ -0,0 +1,96 @@
+package visio
+
+import "math"
+
+// transform is a 2D affine transform mapping (x, y) to
+// (a*x + c*y + e, b*x + d*y + f)
+type transform struct {
+	a, b, c, d, e, f float64
+}
+
+// identityTransform returns the transform that leaves points unchanged
+func identityTransform() transform {
+	return transform{a: 1, d: 1}
+}
+
+// apply maps a point through the transform
+func (t transform) apply(x, y float64) (float64, float64) {
+	return t.a*x + t.c*y + t.e, t.b*x + t.d*y + t.f
+}
+
+// then returns the transform that applies inner first and t second
+func (t transform) then(inner transform) transform {
+	return transform{
+		a: t.a*inner.a + t.c*inner.b,
+		b: t.b*inner.a + t.d*inner.b,
+		c: t.a*inner.c + t.c*inner.d,
+		d: t.b*inner.c + t.d*inner.d,
+		e: t.a*inner.e + t.c*inner.f + t.e,
+		f: t.b*inner.e + t.d*inner.f + t.f,
+	}
+}
+
+// shapeTransform returns the transform from a shape's local coordinates to
+// the coordinates of its parent: the local pin is moved to the origin, the
+// shape is flipped and rotated about it, then moved to the pin position.
+func shapeTransform(shape *Shape) transform {
+	flipX, flipY := 1.0, 1.0
+	if shape.FlipX {
+		flipX = -1
+	}
+	if shape.FlipY {
+		flipY = -1
+	}
+	sin, cos := math.Sincos(shape.Angle)
+
+	t := transform{a: flipX, d: flipY, e: -shape.LocPinX * flipX, f: -shape.LocPinY * flipY}
+	t = transform{a: cos, b: sin, c: -sin, d: cos}.then(t)
+	return transform{a: 1, d: 1, e: shape.PinX, f: shape.PinY}.then(t)
+}
+
+// FlattenShapes returns every shape of a shape tree in document order, each
+// group followed by its members. The returned shapes have no Children; use
+// ParentID, PageX and PageY to place them on the page.
+func FlattenShapes(shapes []Shape) []Shape {
+	flat := make([]Shape, 0, len(shapes))
+	var walk func(shapes []Shape)
+	walk = func(shapes []Shape) {
+		for _, shape := range shapes {
+			children := shape.Children
+			shape.Children = nil
+			flat = append(flat, shape)
+			walk(children)
+		}
+	}
+	walk(shapes)
+	return flat
+}
+
+// LimitShapeDepth returns a copy of a shape tree without the group members
+// nested more than depth levels below the given shapes. Depth 0 keeps only
+// the given shapes.
+func LimitShapeDepth(shapes []Shape, depth int) []Shape {
+	limited := make([]Shape, len(shapes))
+	for i, shape := range shapes {
+		if depth > 0 {
+			shape.Children = LimitShapeDepth(shape.Children, depth-1)
+		} else {
+			shape.Children = nil
+		}
+		limited[i] = shape
+	}
+	return limited
+}
+
+// FindShape searches a shape tree for the shape with the given ID
+func FindShape(shapes []Shape, id string) (*Shape, bool) {
+	for i := range shapes {
+		if shapes[i].ID == id {
+			return &shapes[i], true
+		}
+		if found, ok := FindShape(shapes[i].Children, id); ok {
+			return found, true
+		}
+	}
+	return nil, false
+}
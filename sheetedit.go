@@ -0,0 +1,149 @@
+package visio
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The helpers below edit the ShapeSheet of a shape in a page contents tree.
+// Edits are made to the local shape only; master inheritance is taken into
+// account by resolving the effective shape where needed.
+
+// shapeElement returns the Shape element with the given ID
+func shapeElement(tree *xmlNode, id string) (*xmlNode, error) {
+	shape, ok := shapeElements(tree)[id]
+	if !ok {
+		return nil, fmt.Errorf("shape not found: %s", id)
+	}
+	return shape, nil
+}
+
+// effectiveShape returns the shape with the given ID from a contents tree
+// with master inheritance applied
+func effectiveShape(pkg *vsdxPackage, tree *xmlNode, id string) (*xmlShape, error) {
+	contents, err := decodePageContents(tree.bytes())
+	if err != nil {
+		return nil, err
+	}
+	shapes, err := effectiveShapes(pkg, contents.Shapes)
+	if err != nil {
+		return nil, err
+	}
+	for _, shape := range shapes {
+		if shape.ID == id {
+			return shape, nil
+		}
+	}
+	return nil, fmt.Errorf("shape not found: %s", id)
+}
+
+// setCellElement sets the value, unit and formula of a cell of a shape,
+// section or row element, creating the cell when missing. An empty unit or
+// formula removes the attribute.
+func setCellElement(parent *xmlNode, before []string, c xmlCell) *xmlNode {
+	cell := parent.findElement("Cell", "N", c.N)
+	if cell == nil {
+		cell = parent.addElement("Cell", before, "N", c.N)
+	}
+	cell.setAttr("V", c.V)
+	for _, attr := range []struct{ name, value string }{{"U", c.U}, {"F", c.F}} {
+		if attr.value != "" {
+			cell.setAttr(attr.name, attr.value)
+		} else {
+			cell.removeAttr(attr.name)
+		}
+	}
+	cell.removeAttr("E")
+	return cell
+}
+
+// setNamedRow sets cells of a named row, creating the section and row as
+// needed. Cells not listed keep their current values.
+func setNamedRow(shape *xmlNode, section, row string, cells []xmlCell) *xmlNode {
+	sectionElement := findOrAddElement(shape, "Section", beforeShapeSection, section, "")
+	sectionElement.removeAttr("Del")
+	rowElement := findOrAddElement(sectionElement, "Row", nil, row, "")
+	rowElement.removeAttr("Del")
+	for _, c := range cells {
+		setCellElement(rowElement, nil, c)
+	}
+	return rowElement
+}
+
+// deleteNamedRow removes a named row from a shape. A row the shape
+// inherits from its master is suppressed with a row marked Del="1".
+func deleteNamedRow(pkg *vsdxPackage, tree *xmlNode, shapeID, section, row string) error {
+	shape, err := shapeElement(tree, shapeID)
+	if err != nil {
+		return err
+	}
+
+	removed := false
+	for _, sectionElement := range shape.elements("Section") {
+		if sectionElement.attr("N") != section {
+			continue
+		}
+		for _, rowElement := range sectionElement.elements("Row") {
+			if strings.EqualFold(rowElement.attr("N"), row) {
+				sectionElement.removeChild(rowElement)
+				removed = true
+			}
+		}
+		if len(sectionElement.elements("Row")) == 0 && len(sectionElement.elements("Cell")) == 0 {
+			shape.removeChild(sectionElement)
+		}
+	}
+
+	effective, err := effectiveShape(pkg, tree, shapeID)
+	if err != nil {
+		return err
+	}
+	if inherited, ok := findEffectiveRow(effective, section, row); ok {
+		sectionElement := findOrAddElement(shape, "Section", beforeShapeSection, section, "")
+		sectionElement.addElement("Row", nil, "N", inherited.N, "Del", "1")
+		return nil
+	}
+	if !removed {
+		return fmt.Errorf("%s row not found: %s", section, row)
+	}
+	return nil
+}
+
+// findEffectiveRow returns a named row of a shape
+func findEffectiveRow(shape *xmlShape, section, row string) (*xmlRow, bool) {
+	for i := range shape.Sections {
+		if shape.Sections[i].N != section {
+			continue
+		}
+		if r := findNamedRow(&shape.Sections[i], row); r != nil {
+			return r, true
+		}
+	}
+	return nil, false
+}
+
+// nextRowName returns the first unused row name of the form Row_N, the
+// names Visio gives to rows added through the user interface
+func nextRowName(shape *xmlShape, section string) string {
+	next := 1
+	for _, s := range shape.Sections {
+		if s.N != section {
+			continue
+		}
+		for _, row := range s.Rows {
+			if n, err := strconv.Atoi(strings.TrimPrefix(row.N, "Row_")); err == nil && strings.HasPrefix(row.N, "Row_") && n >= next {
+				next = n + 1
+			}
+		}
+	}
+	return "Row_" + strconv.Itoa(next)
+}
+
+// boolCellValue formats a boolean cell value
+func boolCellValue(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
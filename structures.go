@@ -0,0 +1,194 @@
+package visio
+
+import (
+	"slices"
+	"strings"
+)
+
+// Relationship types of the DEPENDSON function in a Relationships cell. Each
+// relationship is recorded on both shapes: a member refers to its container
+// with relContainerMember and the container to its members with
+// relContainer.
+const (
+	relContainerMember = 1
+	relListMember      = 2
+	relCallout         = 3
+	relContainer       = 4
+	relList            = 5
+	relCalloutTarget   = 6
+)
+
+// shapeRelationship is a DEPENDSON term of a Relationships cell: the shape
+// it is written on relates to the shapes it references
+type shapeRelationship struct {
+	shapeID string
+	kind    int
+	targets []string
+}
+
+// parseStructures reports the containers, lists and callouts of a page.
+// Shapes are classified by User.msvStructureType; their members and callout
+// targets come from the Relationships cells of the page contents, which are
+// read without master inheritance because their sheet references address
+// shapes of the page.
+func parseStructures(shapes []Shape, contents *xmlPageContents) []Structure {
+	structures := make([]Structure, 0)
+	index := make(map[string]int)
+	for _, shape := range FlattenShapes(shapes) {
+		kind := structureType(shape.User)
+		if kind == "" {
+			continue
+		}
+		structure := Structure{
+			ShapeID:    shape.ID,
+			Name:       shape.Name,
+			Text:       shape.Text,
+			Type:       kind,
+			Categories: shapeCategories(shape.User),
+			Members:    make([]string, 0),
+			Containers: make([]string, 0),
+		}
+		for _, category := range structure.Categories {
+			if kind == "Container" && strings.EqualFold(category, "Swimlane") {
+				structure.Type = "Swimlane"
+			}
+		}
+		index[shape.ID] = len(structures)
+		structures = append(structures, structure)
+	}
+	if len(structures) == 0 {
+		return structures
+	}
+
+	// Relationships are recorded on both ends, so each link is added from
+	// whichever side is found first and duplicates are skipped
+	addMember := func(containerID, memberID string) {
+		if i, ok := index[containerID]; ok && !slices.Contains(structures[i].Members, memberID) {
+			structures[i].Members = append(structures[i].Members, memberID)
+		}
+		if i, ok := index[memberID]; ok && !slices.Contains(structures[i].Containers, containerID) {
+			structures[i].Containers = append(structures[i].Containers, containerID)
+		}
+	}
+	setTarget := func(calloutID, targetID string) {
+		if i, ok := index[calloutID]; ok && structures[i].Target == "" {
+			structures[i].Target = targetID
+		}
+	}
+	for _, rel := range pageRelationships(contents) {
+		for _, target := range rel.targets {
+			switch rel.kind {
+			case relContainerMember, relListMember:
+				addMember(target, rel.shapeID)
+			case relContainer, relList:
+				addMember(rel.shapeID, target)
+			case relCallout:
+				setTarget(rel.shapeID, target)
+			case relCalloutTarget:
+				setTarget(target, rel.shapeID)
+			}
+		}
+	}
+	return structures
+}
+
+// structureType returns the structure a shape forms according to its
+// User.msvStructureType cell, or an empty string
+func structureType(cells []UserCell) string {
+	for _, cell := range cells {
+		if cell.Name != "msvStructureType" {
+			continue
+		}
+		switch value := strings.Trim(cell.Value, `"`); {
+		case strings.EqualFold(value, "Container"):
+			return "Container"
+		case strings.EqualFold(value, "List"):
+			return "List"
+		case strings.EqualFold(value, "Callout"):
+			return "Callout"
+		}
+	}
+	return ""
+}
+
+// shapeCategories returns the semicolon-separated categories of a shape's
+// User.msvShapeCategories cell
+func shapeCategories(cells []UserCell) []string {
+	categories := make([]string, 0)
+	for _, cell := range cells {
+		if cell.Name != "msvShapeCategories" {
+			continue
+		}
+		for _, category := range strings.Split(strings.Trim(cell.Value, `"`), ";") {
+			if category = strings.TrimSpace(category); category != "" {
+				categories = append(categories, category)
+			}
+		}
+	}
+	return categories
+}
+
+// pageRelationships collects the DEPENDSON terms of the Relationships cells
+// of all shapes of a page, resolving sheet references to shape IDs.
+// Formulas that cannot be parsed are skipped.
+func pageRelationships(contents *xmlPageContents) []shapeRelationship {
+	all := make([]*xmlShape, 0)
+	var walk func(shapes []xmlShape)
+	walk = func(shapes []xmlShape) {
+		for i := range shapes {
+			all = append(all, &shapes[i])
+			walk(shapes[i].Shapes)
+		}
+	}
+	walk(contents.Shapes)
+
+	names := make(map[string]string, len(all))
+	for _, xs := range all {
+		for _, name := range []string{xs.NameU, xs.Name} {
+			if name != "" {
+				names[strings.ToLower(name)] = xs.ID
+			}
+		}
+	}
+	sheetID := func(qualifier string) string {
+		if len(qualifier) > 6 && strings.EqualFold(qualifier[:6], "Sheet.") {
+			return qualifier[6:]
+		}
+		return names[strings.ToLower(qualifier)]
+	}
+
+	relationships := make([]shapeRelationship, 0)
+	for _, xs := range all {
+		c, ok := xs.cell("Relationships")
+		if !ok || c.formula() == "" {
+			continue
+		}
+		expr, err := parseFormula(c.formula())
+		if err != nil {
+			continue
+		}
+		var visit func(e *formulaExpr)
+		visit = func(e *formulaExpr) {
+			if e.op == "call" && e.name == "DEPENDSON" {
+				if len(e.args) > 1 && e.args[0].op == "num" {
+					rel := shapeRelationship{shapeID: xs.ID, kind: int(e.args[0].value.num)}
+					for _, arg := range e.args[1:] {
+						if arg.sheet == "" {
+							continue
+						}
+						if id := sheetID(arg.sheet); id != "" {
+							rel.targets = append(rel.targets, id)
+						}
+					}
+					relationships = append(relationships, rel)
+				}
+				return
+			}
+			for _, arg := range e.args {
+				visit(arg)
+			}
+		}
+		visit(expr)
+	}
+	return relationships
+}
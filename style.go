@@ -0,0 +1,371 @@
+package visio
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// xmlTheme is the root element of a DrawingML theme part (visio/theme/themeN.xml)
+type xmlTheme struct {
+	Name        string         `xml:"name,attr"`
+	ColorScheme xmlColorScheme `xml:"themeElements>clrScheme"`
+	MajorFont   xmlThemeFont   `xml:"themeElements>fontScheme>majorFont"`
+	MinorFont   xmlThemeFont   `xml:"themeElements>fontScheme>minorFont"`
+}
+
+// xmlColorScheme lists the theme colors
+type xmlColorScheme struct {
+	Dark1    xmlThemeColor `xml:"dk1"`
+	Light1   xmlThemeColor `xml:"lt1"`
+	Dark2    xmlThemeColor `xml:"dk2"`
+	Light2   xmlThemeColor `xml:"lt2"`
+	Accent1  xmlThemeColor `xml:"accent1"`
+	Accent2  xmlThemeColor `xml:"accent2"`
+	Accent3  xmlThemeColor `xml:"accent3"`
+	Accent4  xmlThemeColor `xml:"accent4"`
+	Accent5  xmlThemeColor `xml:"accent5"`
+	Accent6  xmlThemeColor `xml:"accent6"`
+	Hlink    xmlThemeColor `xml:"hlink"`
+	FolHlink xmlThemeColor `xml:"folHlink"`
+}
+
+// xmlThemeColor is a theme color given either as RGB or as a system color
+// with its last known RGB value
+type xmlThemeColor struct {
+	RGB struct {
+		Val string `xml:"val,attr"`
+	} `xml:"srgbClr"`
+	System struct {
+		LastColor string `xml:"lastClr,attr"`
+	} `xml:"sysClr"`
+}
+
+// xmlThemeFont is a major or minor theme font
+type xmlThemeFont struct {
+	Latin struct {
+		Typeface string `xml:"typeface,attr"`
+	} `xml:"latin"`
+}
+
+// rgb returns the color as #RRGGBB, or an empty string
+func (c xmlThemeColor) rgb() string {
+	if c.RGB.Val != "" {
+		return "#" + strings.ToUpper(c.RGB.Val)
+	}
+	if c.System.LastColor != "" {
+		return "#" + strings.ToUpper(c.System.LastColor)
+	}
+	return ""
+}
+
+// defaultColors is the Visio color table used when a document does not
+// override an entry
+var defaultColors = []string{
+	"#000000", "#FFFFFF", "#FF0000", "#00FF00", "#0000FF", "#FFFF00",
+	"#FF00FF", "#00FFFF", "#800000", "#008000", "#000080", "#808000",
+	"#800080", "#008080", "#C0C0C0", "#E6E6E6", "#CDCDCD", "#B3B3B3",
+	"#9A9A9A", "#808080", "#666666", "#4D4D4D", "#333333", "#1A1A1A",
+}
+
+// Character style bits of the Style cell
+const (
+	charStyleBold      = 1
+	charStyleItalic    = 2
+	charStyleUnderline = 4
+)
+
+// styleCategory selects which style sheet attribute a cell inherits through
+type styleCategory int
+
+const (
+	lineCategory styleCategory = iota
+	fillCategory
+	textCategory
+)
+
+// styleResolver computes effective formatting by walking a shape (already
+// overlaid on its master shape), its style sheets and the document theme
+type styleResolver struct {
+	styles   map[string]*xmlStyleSheet
+	defaults xmlDocumentSettings
+	colors   map[string]string
+	theme    *xmlTheme
+}
+
+// newStyleResolver builds a resolver from the document part and theme
+func newStyleResolver(doc *xmlVisioDocument, theme *xmlTheme) *styleResolver {
+	resolver := &styleResolver{
+		styles:   make(map[string]*xmlStyleSheet, len(doc.StyleSheets)),
+		defaults: doc.Settings,
+		colors:   make(map[string]string, len(doc.Colors)),
+		theme:    theme,
+	}
+	for i := range doc.StyleSheets {
+		resolver.styles[doc.StyleSheets[i].ID] = &doc.StyleSheets[i]
+	}
+	for _, entry := range doc.Colors {
+		resolver.colors[entry.IX] = strings.ToUpper(entry.RGB)
+	}
+	return resolver
+}
+
+// resolve returns the effective line, fill and text formatting of a shape
+func (s *styleResolver) resolve(shape *xmlShape) ShapeStyle {
+	style := ShapeStyle{
+		LineStyle:   s.styleName(s.styleID(&shape.xmlSheet, lineCategory)),
+		FillStyle:   s.styleName(s.styleID(&shape.xmlSheet, fillCategory)),
+		TextStyle:   s.styleName(s.styleID(&shape.xmlSheet, textCategory)),
+		LineColor:   s.color(shape, lineCategory, "LineColor", "QuickStyleLineColor"),
+		FillForegnd: s.color(shape, fillCategory, "FillForegnd", "QuickStyleFillColor"),
+		FillBkgnd:   s.color(shape, fillCategory, "FillBkgnd", "QuickStyleFillColor"),
+	}
+
+	if c, ok := s.lookupCell(shape, lineCategory, "LineWeight"); ok {
+		style.LineWeight = c.float()
+	}
+	if c, ok := s.lookupCell(shape, lineCategory, "LinePattern"); ok {
+		style.LinePattern = int(c.float())
+	}
+	if c, ok := s.lookupCell(shape, fillCategory, "FillPattern"); ok {
+		style.FillPattern = int(c.float())
+	}
+
+	char := s.charFormat(shape, "0")
+	style.FontName = char.Font
+	style.FontColor = char.Color
+	style.FontSize = char.Size
+	style.Bold = char.Bold
+	style.Italic = char.Italic
+	style.Underline = char.Underline
+
+	return style
+}
+
+// styleID returns the style sheet a sheet inherits the category from,
+// falling back to the document default for unstyled shapes
+func (s *styleResolver) styleID(sheet *xmlSheet, category styleCategory) string {
+	id, fallback := sheet.LineStyle, s.defaults.DefaultLineStyle
+	switch category {
+	case fillCategory:
+		id, fallback = sheet.FillStyle, s.defaults.DefaultFillStyle
+	case textCategory:
+		id, fallback = sheet.TextStyle, s.defaults.DefaultTextStyle
+	}
+	if id == "" {
+		return fallback
+	}
+	return id
+}
+
+// styleName returns the display name of a style sheet
+func (s *styleResolver) styleName(id string) string {
+	style, ok := s.styles[id]
+	if !ok {
+		return ""
+	}
+	if style.Name != "" {
+		return style.Name
+	}
+	return style.NameU
+}
+
+// lookupCell finds a cell on the shape or, failing that, along its chain of
+// style sheets for the category
+func (s *styleResolver) lookupCell(shape *xmlShape, category styleCategory, name string) (xmlCell, bool) {
+	if c, ok := shape.cell(name); ok {
+		return c, true
+	}
+	return s.lookupStyles(&shape.xmlSheet, category, func(sheet *xmlSheet) (xmlCell, bool) {
+		return sheet.cell(name)
+	})
+}
+
+// lookupRowCell finds a cell of a Character or Paragraph row on the shape,
+// falling back to the first row of the section along the chain of text
+// style sheets
+func (s *styleResolver) lookupRowCell(shape *xmlShape, section, ix, name string) (xmlCell, bool) {
+	if row, ok := shape.row(section, ix); ok {
+		if c, ok := row.cell(name); ok {
+			return c, true
+		}
+	}
+	return s.lookupStyles(&shape.xmlSheet, textCategory, func(sheet *xmlSheet) (xmlCell, bool) {
+		row, ok := sheet.row(section, "0")
+		if !ok {
+			return xmlCell{}, false
+		}
+		return row.cell(name)
+	})
+}
+
+// charFormat returns the effective formatting of a Character row
+func (s *styleResolver) charFormat(shape *xmlShape, ix string) *CharacterFormat {
+	format := &CharacterFormat{}
+	format.Index, _ = strconv.Atoi(ix)
+
+	if c, ok := s.lookupRowCell(shape, "Character", ix, "Font"); ok {
+		format.Font = c.V
+		if c.V == "Themed" || c.V == "" {
+			format.Font = s.themeFont()
+		}
+	}
+	if c, ok := s.lookupRowCell(shape, "Character", ix, "Color"); ok {
+		format.Color = s.colorValue(c, func() (xmlCell, bool) {
+			return s.lookupCell(shape, textCategory, "QuickStyleFontColor")
+		})
+	}
+	if c, ok := s.lookupRowCell(shape, "Character", ix, "Size"); ok {
+		format.Size = c.float()
+	}
+	if c, ok := s.lookupRowCell(shape, "Character", ix, "Style"); ok {
+		bits := int(c.float())
+		format.Bold = bits&charStyleBold != 0
+		format.Italic = bits&charStyleItalic != 0
+		format.Underline = bits&charStyleUnderline != 0
+	}
+	return format
+}
+
+// paragraphFormat returns the effective formatting of a Paragraph row
+func (s *styleResolver) paragraphFormat(shape *xmlShape, ix string) *ParagraphFormat {
+	format := &ParagraphFormat{}
+	format.Index, _ = strconv.Atoi(ix)
+
+	float := func(name string) float64 {
+		c, _ := s.lookupRowCell(shape, "Paragraph", ix, name)
+		return c.float()
+	}
+	format.HorzAlign = int(float("HorzAlign"))
+	format.IndFirst = float("IndFirst")
+	format.IndLeft = float("IndLeft")
+	format.IndRight = float("IndRight")
+	format.SpLine = float("SpLine")
+	format.SpBefore = float("SpBefore")
+	format.SpAfter = float("SpAfter")
+	format.Bullet = int(float("Bullet"))
+	if c, ok := s.lookupRowCell(shape, "Paragraph", ix, "BulletStr"); ok {
+		format.BulletStr = c.V
+	}
+	return format
+}
+
+// lookupStyles walks the style sheet chain starting from the sheet's style
+// for the category until find succeeds
+func (s *styleResolver) lookupStyles(sheet *xmlSheet, category styleCategory, find func(*xmlSheet) (xmlCell, bool)) (xmlCell, bool) {
+	visited := make(map[string]bool)
+	id := s.styleID(sheet, category)
+	for id != "" && !visited[id] {
+		visited[id] = true
+		style, ok := s.styles[id]
+		if !ok {
+			break
+		}
+		if c, ok := find(&style.xmlSheet); ok {
+			return c, true
+		}
+		switch category {
+		case lineCategory:
+			id = style.LineStyle
+		case fillCategory:
+			id = style.FillStyle
+		default:
+			id = style.TextStyle
+		}
+	}
+	return xmlCell{}, false
+}
+
+// color resolves a color cell, consulting the theme through the given
+// quick style cell when the value is themed
+func (s *styleResolver) color(shape *xmlShape, category styleCategory, name, quickStyle string) string {
+	c, ok := s.lookupCell(shape, category, name)
+	if !ok {
+		return ""
+	}
+	return s.colorValue(c, func() (xmlCell, bool) {
+		return s.lookupCell(shape, category, quickStyle)
+	})
+}
+
+// colorValue converts a color cell to #RRGGBB. Values may be RGB strings,
+// indices into the document color table, or "Themed".
+func (s *styleResolver) colorValue(c xmlCell, quickStyle func() (xmlCell, bool)) string {
+	v := strings.TrimSpace(c.V)
+	switch {
+	case strings.HasPrefix(v, "#"):
+		return strings.ToUpper(v)
+	case v == "Themed" || v == "":
+		if qs, ok := quickStyle(); ok {
+			return s.themeColor(int(qs.float()))
+		}
+		return ""
+	}
+
+	if _, err := strconv.Atoi(v); err != nil {
+		return ""
+	}
+	if rgb, ok := s.colors[v]; ok {
+		return rgb
+	}
+	ix, _ := strconv.Atoi(v)
+	if ix >= 0 && ix < len(defaultColors) {
+		return defaultColors[ix]
+	}
+	return ""
+}
+
+// themeColor maps a quick style color index to a theme color: 0 and 1 are
+// the dark and light colors, 2 to 7 the accent colors
+func (s *styleResolver) themeColor(index int) string {
+	if s.theme == nil {
+		return ""
+	}
+	scheme := s.theme.ColorScheme
+	colors := []xmlThemeColor{
+		scheme.Dark1, scheme.Light1,
+		scheme.Accent1, scheme.Accent2, scheme.Accent3,
+		scheme.Accent4, scheme.Accent5, scheme.Accent6,
+	}
+	if index < 0 || index >= len(colors) {
+		return ""
+	}
+	return colors[index].rgb()
+}
+
+// themeFont returns the theme body font
+func (s *styleResolver) themeFont() string {
+	if s.theme == nil {
+		return ""
+	}
+	return s.theme.MinorFont.Latin.Typeface
+}
+
+// loadStyles reads the style sheets, color table and theme of the document.
+// A document without a theme part resolves themed values to empty strings.
+func (p *vsdxPackage) loadStyles() (*styleResolver, error) {
+	if p.styles != nil {
+		return p.styles, nil
+	}
+
+	doc, err := p.loadDocument()
+	if err != nil {
+		return nil, err
+	}
+
+	var theme *xmlTheme
+	if themePart, ok := relationshipByType(p.documentRels, relTypeTheme); ok && findZipFile(p.zip, themePart) != nil {
+		data, err := readZipFile(p.zip, themePart)
+		if err != nil {
+			return nil, err
+		}
+		theme = &xmlTheme{}
+		if err := xml.Unmarshal(data, theme); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", themePart, err)
+		}
+	}
+
+	p.styles = newStyleResolver(doc, theme)
+	return p.styles, nil
+}
@@ -0,0 +1,140 @@
+package visio
+
+import (
+	"encoding/xml"
+	"strconv"
+	"strings"
+)
+
+// xmlText is the text element of a shape. Its mixed content interleaves
+// character data with empty cp, pp and tp markers, which select the
+// Character, Paragraph and Tabs rows applying to the following text, and
+// fld elements, which insert the value of a Field row.
+type xmlText struct {
+	Items []xmlTextItem
+}
+
+// xmlTextItem is either a run of character data or a marker
+type xmlTextItem struct {
+	Marker string // cp, pp, tp or fld; empty for character data
+	IX     string
+	Text   string // Character data, or the displayed value of a field
+}
+
+// UnmarshalXML collects the text content and markers in document order
+func (t *xmlText) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch token := token.(type) {
+		case xml.CharData:
+			t.Items = append(t.Items, xmlTextItem{Text: string(token)})
+		case xml.StartElement:
+			item := xmlTextItem{Marker: token.Name.Local}
+			for _, attr := range token.Attr {
+				if attr.Name.Local == "IX" {
+					item.IX = attr.Value
+				}
+			}
+			content := struct {
+				Value string `xml:",chardata"`
+			}{}
+			if err := d.DecodeElement(&content, &token); err != nil {
+				return err
+			}
+			item.Text = content.Value
+			t.Items = append(t.Items, item)
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// parseTextRuns splits the shape text into runs of uniform character,
+// paragraph and tab formatting. Each field becomes a run of its own.
+func parseTextRuns(shape *xmlShape, styles *styleResolver) []TextRun {
+	runs := make([]TextRun, 0)
+	if shape.Text == nil {
+		return runs
+	}
+
+	cp, pp, tp := "0", "0", "0"
+	current := strings.Builder{}
+	flush := func() {
+		if current.Len() == 0 {
+			return
+		}
+		runs = append(runs, newTextRun(shape, styles, current.String(), cp, pp, tp))
+		current.Reset()
+	}
+
+	for _, item := range shape.Text.Items {
+		switch item.Marker {
+		case "":
+			current.WriteString(item.Text)
+		case "cp":
+			flush()
+			cp = item.IX
+		case "pp":
+			flush()
+			pp = item.IX
+		case "tp":
+			flush()
+			tp = item.IX
+		case "fld":
+			flush()
+			run := newTextRun(shape, styles, item.Text, cp, pp, tp)
+			run.Field = parseTextField(shape, item.IX)
+			if run.Text == "" {
+				run.Text = run.Field.Value
+			}
+			runs = append(runs, run)
+		}
+	}
+	flush()
+
+	return runs
+}
+
+// newTextRun creates a run with the formatting of the given rows
+func newTextRun(shape *xmlShape, styles *styleResolver, text, cp, pp, tp string) TextRun {
+	run := TextRun{
+		Text:      text,
+		Character: styles.charFormat(shape, cp),
+		Paragraph: styles.paragraphFormat(shape, pp),
+	}
+	run.Tabs, _ = strconv.Atoi(tp)
+	return run
+}
+
+// parseTextField reads the Field row a fld marker refers to
+func parseTextField(shape *xmlShape, ix string) *TextField {
+	field := &TextField{}
+	field.Index, _ = strconv.Atoi(ix)
+	row, ok := shape.row("Field", ix)
+	if !ok {
+		return field
+	}
+	if c, ok := row.cell("Value"); ok {
+		field.Value = c.V
+		field.Formula = c.F
+	}
+	if c, ok := row.cell("Format"); ok {
+		field.Format = c.V
+	}
+	if c, ok := row.cell("Type"); ok {
+		field.Type = int(c.float())
+	}
+	return field
+}
+
+// plainText joins the text of all runs
+func plainText(runs []TextRun) string {
+	text := strings.Builder{}
+	for _, run := range runs {
+		text.WriteString(run.Text)
+	}
+	return strings.TrimSpace(text.String())
+}
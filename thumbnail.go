@@ -0,0 +1,83 @@
+package visio
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"path"
+	"strings"
+)
+
+// DefaultThumbnailSize is the length of the longer side of rendered EMF
+// thumbnails, in pixels
+const DefaultThumbnailSize = 512
+
+// Thumbnail is the preview image Visio stores with a document
+type Thumbnail struct {
+	Part     string // Part holding the stored thumbnail, e.g. docProps/thumbnail.emf
+	MIMEType string // MIME type of Data
+	Data     []byte
+	Rendered bool // Data was rendered to PNG from a metafile
+}
+
+// ReadThumbnail returns the document thumbnail. JPEG, PNG and GIF
+// thumbnails are returned as stored; EMF thumbnails are rendered to a PNG
+// whose longer side is size pixels, or DefaultThumbnailSize when size is 0.
+func (r *Reader) ReadThumbnail(size int) (*Thumbnail, error) {
+	if size <= 0 {
+		size = DefaultThumbnailSize
+	}
+
+	pkg, err := r.openPackage()
+	if err != nil {
+		return nil, err
+	}
+
+	part, ok := pkg.thumbnailPart()
+	if !ok {
+		return nil, fmt.Errorf("document has no thumbnail")
+	}
+	data, err := readZipFile(pkg.zip, part)
+	if err != nil {
+		return nil, err
+	}
+
+	thumbnail := &Thumbnail{Part: part, Data: data}
+	switch strings.ToLower(strings.TrimPrefix(path.Ext(part), ".")) {
+	case "jpg", "jpeg":
+		thumbnail.MIMEType = "image/jpeg"
+	case "png":
+		thumbnail.MIMEType = "image/png"
+	case "gif":
+		thumbnail.MIMEType = "image/gif"
+	case "emf":
+		img, err := renderEMF(data, size)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render thumbnail %s: %w", part, err)
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, fmt.Errorf("failed to encode thumbnail: %w", err)
+		}
+		thumbnail.MIMEType = "image/png"
+		thumbnail.Data = buf.Bytes()
+		thumbnail.Rendered = true
+	default:
+		return nil, fmt.Errorf("unsupported thumbnail format: %s", part)
+	}
+	return thumbnail, nil
+}
+
+// thumbnailPart locates the thumbnail through the package relationships,
+// falling back to the part Visio writes it to
+func (p *vsdxPackage) thumbnailPart() (string, bool) {
+	if part, ok := relationshipByType(p.rootRels, relTypeThumbnail); ok && findZipFile(p.zip, part) != nil {
+		return findZipFile(p.zip, part).Name, true
+	}
+	for _, file := range p.zip.File {
+		if dir, name := path.Split(file.Name); strings.EqualFold(dir, "docProps/") && strings.HasPrefix(strings.ToLower(name), "thumbnail.") {
+			return file.Name, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,277 @@
+package visio
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Units lengths can be reported and accepted in
+const (
+	UnitInches      = "in"
+	UnitMillimeters = "mm"
+	UnitCentimeters = "cm"
+	UnitPoints      = "pt"
+	UnitPixels      = "px"
+)
+
+// unitsPerInch converts inches to the supported units. Pixels are CSS
+// pixels, 96 to the inch.
+var unitsPerInch = map[string]float64{
+	UnitInches:      1,
+	UnitMillimeters: 25.4,
+	UnitCentimeters: 2.54,
+	UnitPoints:      72,
+	UnitPixels:      96,
+}
+
+// Units lists the supported unit names
+func Units() []string {
+	return []string{UnitInches, UnitMillimeters, UnitCentimeters, UnitPoints, UnitPixels}
+}
+
+// UnitConverter converts lengths between Visio's internal units and a
+// caller-selected unit. Internally every length is stored in inches; shape
+// geometry is measured in drawing space, i.e. in real-world units on scaled
+// drawings. In page space, geometry is instead measured on the printed
+// page by applying the page's drawing scale (PageScale / DrawingScale).
+// Line weights, font sizes and text indents are always page measurements.
+type UnitConverter struct {
+	Unit      string
+	PageSpace bool
+
+	paper   float64 // Caller units per inch on the page
+	drawing float64 // Caller units per internal inch of geometry
+}
+
+// NewUnitConverter creates a converter for the given unit, which defaults
+// to inches, and the drawing scale of a page
+func NewUnitConverter(unit string, pageSpace bool, pageScale, drawingScale float64) (UnitConverter, error) {
+	if unit == "" {
+		unit = UnitInches
+	}
+	factor, ok := unitsPerInch[strings.ToLower(unit)]
+	if !ok {
+		return UnitConverter{}, fmt.Errorf("unsupported unit %q, expected one of %s", unit, strings.Join(Units(), ", "))
+	}
+	converter := UnitConverter{
+		Unit:      strings.ToLower(unit),
+		PageSpace: pageSpace,
+		paper:     factor,
+		drawing:   factor,
+	}
+	if pageSpace && pageScale > 0 && drawingScale > 0 {
+		converter.drawing = factor * pageScale / drawingScale
+	}
+	return converter, nil
+}
+
+// FromInches converts a geometry length from internal units
+func (c UnitConverter) FromInches(v float64) float64 {
+	return roundLength(v * c.drawing)
+}
+
+// ToInches converts a geometry length to internal units
+func (c UnitConverter) ToInches(v float64) float64 {
+	return v / c.drawing
+}
+
+// fromPaperInches converts a page measurement from inches
+func (c UnitConverter) fromPaperInches(v float64) float64 {
+	return roundLength(v * c.paper)
+}
+
+// roundLength drops the floating point noise left by unit conversions
+func roundLength(v float64) float64 {
+	rounded, err := strconv.ParseFloat(strconv.FormatFloat(v, 'g', 12, 64), 64)
+	if err != nil {
+		return v
+	}
+	return rounded
+}
+
+// ConvertPageInfo converts the page size to the selected unit. The scale
+// lengths are converted as page measurements, so their ratio is kept.
+func (c UnitConverter) ConvertPageInfo(info *PageInfo) {
+	info.Width = c.FromInches(info.Width)
+	info.Height = c.FromInches(info.Height)
+	info.PageScale = c.fromPaperInches(info.PageScale)
+	info.DrawingScale = c.fromPaperInches(info.DrawingScale)
+}
+
+// ConvertPage converts the page size and all shapes of a page
+func (c UnitConverter) ConvertPage(page *Page) {
+	page.Width = c.FromInches(page.Width)
+	page.Height = c.FromInches(page.Height)
+	page.PageScale = c.fromPaperInches(page.PageScale)
+	page.DrawingScale = c.fromPaperInches(page.DrawingScale)
+	c.ConvertShapes(page.Shapes)
+}
+
+// ConvertShapes converts the geometry, formatting and length cells of a
+// shape tree in place
+func (c UnitConverter) ConvertShapes(shapes []Shape) {
+	for i := range shapes {
+		c.convertShape(&shapes[i])
+	}
+}
+
+// convertShape converts a single shape and its members
+func (c UnitConverter) convertShape(shape *Shape) {
+	for _, v := range []*float64{
+		&shape.PinX, &shape.PinY, &shape.PageX, &shape.PageY,
+		&shape.Width, &shape.Height, &shape.LocPinX, &shape.LocPinY,
+	} {
+		*v = c.FromInches(*v)
+	}
+
+	shape.Style.LineWeight = c.fromPaperInches(shape.Style.LineWeight)
+	shape.Style.FontSize = c.fromPaperInches(shape.Style.FontSize)
+	for i := range shape.TextRuns {
+		c.convertTextRun(&shape.TextRuns[i])
+	}
+
+	for i := range shape.Geometry {
+		segments := shape.Geometry[i].Segments
+		for j := range segments {
+			c.convertSegment(&segments[j])
+		}
+	}
+
+	for i := range shape.Cells {
+		c.convertCell(&shape.Cells[i], "", "")
+	}
+	for i := range shape.Sections {
+		section := &shape.Sections[i]
+		for j := range section.Cells {
+			c.convertCell(&section.Cells[j], section.Name, "")
+		}
+		for j := range section.Rows {
+			row := &section.Rows[j]
+			for k := range row.Cells {
+				c.convertCell(&row.Cells[k], section.Name, row.Type)
+			}
+		}
+	}
+
+	c.ConvertShapes(shape.Children)
+}
+
+// convertTextRun converts the font size and paragraph spacing of a run.
+// Runs share their format structs, so each is converted by a copy.
+func (c UnitConverter) convertTextRun(run *TextRun) {
+	if run.Character != nil {
+		character := *run.Character
+		character.Size = c.fromPaperInches(character.Size)
+		run.Character = &character
+	}
+	if run.Paragraph != nil {
+		paragraph := *run.Paragraph
+		paragraph.IndFirst = c.fromPaperInches(paragraph.IndFirst)
+		paragraph.IndLeft = c.fromPaperInches(paragraph.IndLeft)
+		paragraph.IndRight = c.fromPaperInches(paragraph.IndRight)
+		paragraph.SpBefore = c.fromPaperInches(paragraph.SpBefore)
+		paragraph.SpAfter = c.fromPaperInches(paragraph.SpAfter)
+		// Negative line spacing is a percentage of the font size
+		if paragraph.SpLine > 0 {
+			paragraph.SpLine = c.fromPaperInches(paragraph.SpLine)
+		}
+		run.Paragraph = &paragraph
+	}
+}
+
+// convertSegment converts the coordinates of a geometry segment
+func (c UnitConverter) convertSegment(segment *PathSegment) {
+	segment.X = c.FromInches(segment.X)
+	segment.Y = c.FromInches(segment.Y)
+	segment.Bow = c.FromInches(segment.Bow)
+	for _, points := range [][]Point{segment.Control, segment.Points} {
+		for i := range points {
+			points[i].X = c.FromInches(points[i].X)
+			points[i].Y = c.FromInches(points[i].Y)
+		}
+	}
+}
+
+// convertCell converts the value of a ShapeSheet cell that holds a length
+func (c UnitConverter) convertCell(cell *Cell, section, rowType string) {
+	factor, ok := c.cellFactor(cell, section, rowType)
+	if !ok {
+		return
+	}
+	v, err := strconv.ParseFloat(cell.Value, 64)
+	if err != nil {
+		return
+	}
+	cell.Value = formatFormulaNumber(roundLength(v * factor))
+}
+
+// Units written in U attributes, grouped by whether the length is measured
+// in drawing space or on the page
+var (
+	drawingLengthUnits = map[string]bool{
+		"DL": true, "IN": true, "IN_F": true, "FT": true, "FT_I": true, "YD": true,
+		"MI": true, "MI_F": true, "MM": true, "CM": true, "M": true, "KM": true,
+	}
+	pageLengthUnits = map[string]bool{
+		"DP": true, "PT": true, "P_PT": true, "C_D": true, "D_PT": true,
+	}
+)
+
+// drawingLengthCells are the shape cells holding drawing-space lengths
+// that are usually written without a U attribute
+var drawingLengthCells = map[string]bool{
+	"PinX": true, "PinY": true, "Width": true, "Height": true,
+	"LocPinX": true, "LocPinY": true,
+	"BeginX": true, "BeginY": true, "EndX": true, "EndY": true,
+	"TxtPinX": true, "TxtPinY": true, "TxtWidth": true, "TxtHeight": true,
+	"TxtLocPinX": true, "TxtLocPinY": true,
+	"PageWidth": true, "PageHeight": true,
+}
+
+// cellFactor returns the conversion factor for a cell's value, if the
+// cell holds a length
+func (c UnitConverter) cellFactor(cell *Cell, section, rowType string) (float64, bool) {
+	switch {
+	case drawingLengthUnits[cell.Unit]:
+		return c.drawing, true
+	case pageLengthUnits[cell.Unit]:
+		return c.paper, true
+	case cell.Unit != "":
+		return 0, false
+	case section == "":
+		return c.drawing, drawingLengthCells[cell.Name]
+	case section == "Geometry" && rowType != "" && !strings.HasPrefix(rowType, "Rel"):
+		return c.drawing, geometryLengthCell(rowType, cell.Name)
+	case section == "Connection" || section == "Control":
+		return c.drawing, cell.Name == "X" || cell.Name == "Y"
+	}
+	return 0, false
+}
+
+// geometryLengthCell reports whether a cell of an absolute geometry row
+// holds a coordinate
+func geometryLengthCell(rowType, name string) bool {
+	switch name {
+	case "X", "Y":
+		return true
+	case "A":
+		return rowType == "ArcTo" || rowType == "EllipticalArcTo" || rowType == "Ellipse" || rowType == "InfiniteLine"
+	case "B":
+		return rowType == "EllipticalArcTo" || rowType == "Ellipse" || rowType == "InfiniteLine"
+	case "C", "D":
+		return rowType == "Ellipse"
+	}
+	return false
+}
+
+// ConvertShapeData converts the position and size of shape data given in
+// the selected unit to internal units
+func (c UnitConverter) ConvertShapeData(data *ShapeData) {
+	for _, v := range []**float64{&data.PinX, &data.PinY, &data.Width, &data.Height} {
+		if *v != nil {
+			inches := c.ToInches(**v)
+			*v = &inches
+		}
+	}
+}
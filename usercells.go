@@ -0,0 +1,62 @@
+package visio
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// parseUserCells reads the rows of the User section
+func parseUserCells(sheet *xmlSheet) []UserCell {
+	cells := make([]UserCell, 0)
+	for _, section := range sheet.Sections {
+		if section.N != "User" {
+			continue
+		}
+		for i := range section.Rows {
+			row := &section.Rows[i]
+			cell := UserCell{
+				Name: row.N,
+			}
+			if c, ok := row.cell("Value"); ok {
+				cell.Value = c.V
+				cell.Unit = c.U
+				cell.Formula = c.F
+			}
+			if c, ok := row.cell("Prompt"); ok {
+				cell.Prompt = c.V
+			}
+			cells = append(cells, cell)
+		}
+	}
+	return cells
+}
+
+// applyUserCellEdits sets and deletes rows of a shape's User section
+func applyUserCellEdits(pkg *vsdxPackage, tree *xmlNode, shapeID string, edits []UserCellEdit) error {
+	for _, edit := range edits {
+		if edit.Name == "" {
+			return fmt.Errorf("user cell name is required")
+		}
+		if edit.Delete {
+			if err := deleteNamedRow(pkg, tree, shapeID, "User", edit.Name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		shape, err := shapeElement(tree, shapeID)
+		if err != nil {
+			return err
+		}
+		value := xmlCell{N: "Value", V: edit.Value, F: edit.Formula}
+		if _, err := strconv.ParseFloat(edit.Value, 64); err != nil && edit.Formula == "" {
+			value.U = "STR"
+		}
+		cells := []xmlCell{value}
+		if edit.Prompt != "" {
+			cells = append(cells, xmlCell{N: "Prompt", V: edit.Prompt})
+		}
+		setNamedRow(shape, "User", edit.Name, cells)
+	}
+	return nil
+}
@@ -0,0 +1,526 @@
+package visio
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// The functions below convert a Visio 2003 XML drawing (.vdx, DatadiagramML)
+// to a VSDX package. Both formats describe the same ShapeSheet, but a VDX
+// file is a single XML document: cells are elements named after the cell,
+// grouped by section (<XForm><PinX F="...">1</PinX></XForm>), rows of
+// indexed sections repeat the section element (<Char IX="0">), and foreign
+// data is embedded as base64 text.
+
+// vdxSections maps the VDX elements holding a row of a section to the name
+// of the section in VSDX. Each Geom element is a section of its own.
+var vdxSections = map[string]string{
+	"Char":        "Character",
+	"Para":        "Paragraph",
+	"Layer":       "Layer",
+	"Connection":  "Connection",
+	"Field":       "Field",
+	"Control":     "Control",
+	"Scratch":     "Scratch",
+	"Prop":        "Property",
+	"User":        "User",
+	"Hyperlink":   "Hyperlink",
+	"Act":         "Actions",
+	"SmartTagDef": "ActionTag",
+	"Annotation":  "Annotation",
+	"Reviewer":    "Reviewer",
+}
+
+// vdxFontCells are the Character cells that refer to a face name by ID
+var vdxFontCells = map[string]bool{
+	"Font":              true,
+	"AsianFont":         true,
+	"ComplexScriptFont": true,
+}
+
+// vdxCoreProperties and vdxExtendedProperties map the elements of the VDX
+// DocumentProperties to core and extended properties
+var (
+	vdxCoreProperties = map[string]string{
+		"Title":       "title",
+		"Subject":     "subject",
+		"Creator":     "creator",
+		"Keywords":    "keywords",
+		"Desc":        "description",
+		"Category":    "category",
+		"TimeCreated": "created",
+		"TimeSaved":   "modified",
+		"TimePrinted": "lastPrinted",
+	}
+	vdxExtendedProperties = map[string]string{
+		"Manager":       "Manager",
+		"Company":       "Company",
+		"Template":      "Template",
+		"HyperlinkBase": "HyperlinkBase",
+	}
+)
+
+// vdxCustomPropertyTypes maps the PropType of a VDX custom property to a
+// custom property type
+var vdxCustomPropertyTypes = map[string]string{
+	"String":  "string",
+	"Number":  "number",
+	"Date":    "date",
+	"Boolean": "boolean",
+}
+
+// vdxConverter carries the state of a conversion
+type vdxConverter struct {
+	e     *packageEdit
+	fonts map[string]string // Face names by ID
+	media int               // Number of foreign data parts added
+}
+
+// isVDX reports whether data is a Visio XML drawing: an XML document whose
+// root element is VisioDocument
+func isVDX(data []byte) bool {
+	trimmed := bytes.TrimLeft(bytes.TrimPrefix(data, []byte("\xEF\xBB\xBF")), " \t\r\n")
+	if !bytes.HasPrefix(trimmed, []byte("<")) {
+		return false
+	}
+	decoder := xml.NewDecoder(bytes.NewReader(trimmed))
+	for {
+		token, err := decoder.RawToken()
+		if err != nil {
+			return false
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local == "VisioDocument"
+		}
+	}
+}
+
+// convertVDX converts a Visio XML drawing to a VSDX package holding its
+// document properties, styles, masters and pages
+func convertVDX(data []byte) ([]byte, error) {
+	tree, err := parseXMLTree(bytes.TrimPrefix(data, []byte("\xEF\xBB\xBF")))
+	if err != nil {
+		return nil, err
+	}
+	src := tree.root()
+	if src == nil || src.name.Local != "VisioDocument" {
+		return nil, fmt.Errorf("not a Visio XML drawing")
+	}
+	if len(vdxChildren(src, "Pages", "Page")) == 0 {
+		return nil, fmt.Errorf("no pages found in Visio XML drawing")
+	}
+
+	return buildPackage(func(e *packageEdit) error {
+		c := &vdxConverter{e: e, fonts: make(map[string]string)}
+		document := newXMLElement("VisioDocument", "xmlns", visioNamespace, "xmlns:r", officeRelationshipsNamespace)
+		if _, err := e.addPart("visio/document.xml", documentContentType, "", relTypeDocument, newXMLDocument(document)); err != nil {
+			return err
+		}
+		c.document(src, document)
+		if err := c.properties(src.child("DocumentProperties")); err != nil {
+			return err
+		}
+		if err := c.masters(vdxChildren(src, "Masters", "Master")); err != nil {
+			return err
+		}
+		return c.pages(vdxChildren(src, "Pages", "Page"))
+	})
+}
+
+// document converts the document settings, colors, face names, styles and
+// document sheet
+func (c *vdxConverter) document(src, dst *xmlNode) {
+	if settings := src.child("DocumentSettings"); settings != nil {
+		dst.appendChild(vdxClone(settings))
+	}
+	if colors := vdxChildren(src, "Colors", "ColorEntry"); len(colors) > 0 {
+		list := dst.addElement("Colors", nil)
+		for _, color := range colors {
+			list.addElement("ColorEntry", nil, "IX", color.attr("IX"), "RGB", color.attr("RGB"))
+		}
+	}
+	if faces := vdxChildren(src, "FaceNames", "FaceName"); len(faces) > 0 {
+		list := dst.addElement("FaceNames", nil)
+		for _, face := range faces {
+			c.fonts[face.attr("ID")] = face.attr("Name")
+			list.addElement("FaceName", nil,
+				"NameU", face.attr("Name"),
+				"UnicodeRanges", face.attr("UnicodeRanges"),
+				"CharSets", face.attr("CharSets"),
+				"Panos", face.attr("Panos"),
+				"Flags", face.attr("Flags"),
+			)
+		}
+	}
+	if styles := vdxChildren(src, "StyleSheets", "StyleSheet"); len(styles) > 0 {
+		list := dst.addElement("StyleSheets", nil)
+		for _, style := range styles {
+			element := list.addElement("StyleSheet", nil)
+			vdxCopyAttrs(element, style, "ID", "NameU", "IsCustomNameU", "Name", "IsCustomName", "LineStyle", "FillStyle", "TextStyle")
+			c.sheet(style, element)
+		}
+	}
+	if sheet := src.child("DocumentSheet"); sheet != nil {
+		element := dst.addElement("DocumentSheet", nil)
+		vdxCopyAttrs(element, sheet, "NameU", "IsCustomNameU", "Name", "IsCustomName", "LineStyle", "FillStyle", "TextStyle")
+		c.sheet(sheet, element)
+	}
+}
+
+// properties converts the document properties. Dates and custom property
+// values that cannot be read are skipped or kept as text.
+func (c *vdxConverter) properties(src *xmlNode) error {
+	if src == nil {
+		return nil
+	}
+	core := make(map[string]string)
+	extended := make(map[string]string)
+	for _, element := range vdxElements(src) {
+		value := strings.TrimSpace(element.textContent())
+		if value == "" {
+			continue
+		}
+		if name, ok := vdxCoreProperties[element.name.Local]; ok {
+			if name == "created" || name == "modified" {
+				if _, err := formatPropertyDate(value); err != nil {
+					continue
+				}
+			}
+			core[name] = value
+		}
+		if name, ok := vdxExtendedProperties[element.name.Local]; ok {
+			extended[name] = value
+		}
+	}
+	edits := make([]CustomPropertyEdit, 0)
+	for _, prop := range vdxChildren(src, "CustomProps", "CustomProp") {
+		name := prop.attr("Name")
+		if name == "" {
+			name = prop.attr("NameU")
+		}
+		if name == "" {
+			continue
+		}
+		edit := CustomPropertyEdit{Name: name, Type: vdxCustomPropertyTypes[prop.attr("PropType")], Value: prop.textContent()}
+		if _, err := formatCustomPropertyValue(edit.Type, edit.Value); err != nil || edit.Type == "" {
+			edit.Type = "string"
+		}
+		edits = append(edits, edit)
+	}
+
+	if len(core) > 0 {
+		if err := setCoreProperties(c.e, core); err != nil {
+			return err
+		}
+	}
+	if len(extended) > 0 {
+		if err := setExtendedProperties(c.e, extended); err != nil {
+			return err
+		}
+	}
+	if len(edits) > 0 {
+		return setCustomProperties(c.e, edits)
+	}
+	return nil
+}
+
+// masters converts the masters to the masters part and a part per master
+func (c *vdxConverter) masters(masters []*xmlNode) error {
+	if len(masters) == 0 {
+		return nil
+	}
+	list := newXMLElement("Masters", "xmlns", visioNamespace, "xmlns:r", officeRelationshipsNamespace)
+	if _, err := c.e.addPart("visio/masters/masters.xml", mastersContentType, "visio/document.xml", relTypeMasters, newXMLDocument(list)); err != nil {
+		return err
+	}
+	for i, master := range masters {
+		part := fmt.Sprintf("visio/masters/master%d.xml", i+1)
+		contents := c.contents("MasterContents", master, part)
+		relID, err := c.e.addPart(part, masterContentType, "visio/masters/masters.xml", relTypeMaster, contents)
+		if err != nil {
+			return err
+		}
+		element := list.addElement("Master", nil)
+		vdxCopyAttrs(element, master, "ID", "NameU", "IsCustomNameU", "Name", "IsCustomName", "Prompt", "IconSize",
+			"AlignName", "MatchByName", "IconUpdate", "UniqueID", "BaseID", "PatternFlags", "Hidden")
+		if sheet := master.child("PageSheet"); sheet != nil {
+			c.sheet(sheet, element.addElement("PageSheet", nil))
+		}
+		element.addElement("Rel", nil, "r:id", relID)
+	}
+	return nil
+}
+
+// pages converts the pages to the pages part and a part per page
+func (c *vdxConverter) pages(pages []*xmlNode) error {
+	list := newXMLElement("Pages", "xmlns", visioNamespace, "xmlns:r", officeRelationshipsNamespace)
+	if _, err := c.e.addPart("visio/pages/pages.xml", pagesContentType, "visio/document.xml", relTypePages, newXMLDocument(list)); err != nil {
+		return err
+	}
+	for i, page := range pages {
+		part := fmt.Sprintf("visio/pages/page%d.xml", i+1)
+		contents := c.contents("PageContents", page, part)
+		relID, err := c.e.addPart(part, pageContentType, "visio/pages/pages.xml", relTypePage, contents)
+		if err != nil {
+			return err
+		}
+		element := list.addElement("Page", nil)
+		vdxCopyAttrs(element, page, "ID", "NameU", "IsCustomNameU", "Name", "IsCustomName", "Background", "BackPage",
+			"ViewScale", "ViewCenterX", "ViewCenterY", "ReviewerID", "AssociatedPage")
+		if sheet := page.child("PageSheet"); sheet != nil {
+			sheetElement := element.addElement("PageSheet", nil)
+			vdxCopyAttrs(sheetElement, sheet, "LineStyle", "FillStyle", "TextStyle")
+			c.sheet(sheet, sheetElement)
+		}
+		element.addElement("Rel", nil, "r:id", relID)
+	}
+	return nil
+}
+
+// contents builds the contents part of a page or master from its shapes
+// and connects. part is the name of the contents part, which foreign data
+// parts are related to.
+func (c *vdxConverter) contents(rootName string, src *xmlNode, part string) *xmlNode {
+	root := newXMLElement(rootName, "xmlns", visioNamespace, "xmlns:r", officeRelationshipsNamespace)
+	if shapes := src.child("Shapes"); shapes != nil {
+		c.shapes(shapes, root, part)
+	}
+	if connects := vdxChildren(src, "Connects", "Connect"); len(connects) > 0 {
+		list := root.addElement("Connects", nil)
+		for _, connect := range connects {
+			element := list.addElement("Connect", nil)
+			vdxCopyAttrs(element, connect, "FromSheet", "FromCell", "FromPart", "ToSheet", "ToCell", "ToPart")
+		}
+	}
+	return newXMLDocument(root)
+}
+
+// shapes converts a Shapes element and the shapes it holds
+func (c *vdxConverter) shapes(src, parent *xmlNode, part string) {
+	list := parent.addElement("Shapes", nil)
+	for _, shape := range src.elements("Shape") {
+		element := list.addElement("Shape", nil)
+		vdxCopyAttrs(element, shape, "ID", "Type", "NameU", "IsCustomNameU", "Name", "IsCustomName", "Master", "MasterShape",
+			"UniqueID", "LineStyle", "FillStyle", "TextStyle", "Del")
+		c.sheet(shape, element)
+		if text := shape.child("Text"); text != nil {
+			element.appendChild(vdxClone(text))
+		}
+		if foreign := shape.child("ForeignData"); foreign != nil {
+			c.foreignData(foreign, element, part)
+		}
+		if members := shape.child("Shapes"); members != nil {
+			c.shapes(members, element, part)
+		}
+	}
+}
+
+// sheet converts the cells and sections of a shape, style, page or
+// document sheet. Cell groups such as XForm become the sheet's cells; rows
+// of indexed and named sections are collected into Section elements.
+func (c *vdxConverter) sheet(src, dst *xmlNode) {
+	cells := make([]*xmlNode, 0)
+	sections := make([]*xmlNode, 0)
+	byName := make(map[string]*xmlNode)
+	for _, child := range vdxElements(src) {
+		name := child.name.Local
+		switch {
+		case name == "Geom":
+			section := newXMLElement("Section", "N", "Geometry", "IX", child.attr("IX"), "Del", child.attr("Del"))
+			for _, element := range vdxElements(child) {
+				if element.attr("IX") == "" {
+					section.appendChild(c.cell(element, ""))
+					continue
+				}
+				row := section.addElement("Row", nil, "T", element.name.Local, "IX", element.attr("IX"), "Del", element.attr("Del"))
+				for _, cell := range vdxElements(element) {
+					row.appendChild(c.cell(cell, ""))
+				}
+			}
+			sections = append(sections, section)
+		case vdxSections[name] != "":
+			section, ok := byName[name]
+			if !ok {
+				section = newXMLElement("Section", "N", vdxSections[name])
+				byName[name] = section
+				sections = append(sections, section)
+			}
+			row := section.addElement("Row", nil, "Del", child.attr("Del"))
+			if rowName := vdxRowName(child); rowName != "" {
+				row.setAttr("N", rowName)
+			} else {
+				row.setAttr("IX", child.attr("IX"))
+			}
+			for _, cell := range vdxElements(child) {
+				row.appendChild(c.cell(cell, vdxSections[name]))
+			}
+		case name == "Text" || name == "Shapes" || name == "PageSheet":
+			// Converted by the caller
+		default:
+			// A group of cells; elements without cells, such as Data1 or
+			// ForeignData, are not part of the ShapeSheet
+			for _, cell := range vdxElements(child) {
+				if len(vdxElements(cell)) == 0 && cell.attr("IX") == "" {
+					cells = append(cells, c.cell(cell, ""))
+				}
+			}
+		}
+	}
+	for _, node := range append(cells, sections...) {
+		dst.appendChild(node)
+	}
+}
+
+// vdxRowName returns the name of a row of a named section, such as a shape
+// data property or user-defined cell
+func vdxRowName(row *xmlNode) string {
+	if name := row.attr("NameU"); name != "" {
+		return name
+	}
+	return row.attr("Name")
+}
+
+// cell converts a cell element. Font cells of the Character section hold a
+// face name ID in VDX and the face name in VSDX.
+func (c *vdxConverter) cell(src *xmlNode, section string) *xmlNode {
+	value := src.textContent()
+	if section == "Character" && vdxFontCells[src.name.Local] {
+		if name, ok := c.fonts[value]; ok && name != "" {
+			value = name
+		}
+	}
+	element := newXMLElement("Cell", "N", src.name.Local)
+	element.setAttr("V", value)
+	for _, a := range [][2]string{{"U", "Unit"}, {"F", "F"}, {"E", "Err"}} {
+		if v := src.attr(a[1]); v != "" {
+			element.setAttr(a[0], v)
+		}
+	}
+	return element
+}
+
+// foreignData stores the embedded data of a foreign shape in a part of its
+// own and adds a ForeignData element referring to it
+func (c *vdxConverter) foreignData(src, shape *xmlNode, part string) {
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(src.textContent()), ""))
+	if err != nil || len(data) == 0 {
+		return
+	}
+	ext, relType := vdxForeignExtension(src.attr("ForeignType"), src.attr("CompressionType"))
+	if ext == "bmp" {
+		data = bitmapFile(data)
+	}
+	c.media++
+	name := fmt.Sprintf("visio/media/image%d.%s", c.media, ext)
+	if relType == relTypeOLEObject {
+		name = fmt.Sprintf("visio/embeddings/oleObject%d.%s", c.media, ext)
+	}
+	relID, err := c.e.addBinaryPart(name, foreignMIMETypes[ext], part, relType, data)
+	if err != nil {
+		return
+	}
+	element := shape.addElement("ForeignData", nil)
+	vdxCopyAttrs(element, src, "ForeignType", "CompressionType", "CompressionLevel", "ObjectType", "ShowAsIcon",
+		"ObjectWidth", "ObjectHeight", "MappingMode", "ExtentX", "ExtentY")
+	element.addElement("Rel", nil, "r:id", relID)
+}
+
+// vdxForeignExtension returns the file extension and relationship type of
+// the part holding foreign data of a given type and compression
+func vdxForeignExtension(foreignType, compression string) (string, string) {
+	switch strings.ToLower(foreignType) {
+	case "enhmetafile":
+		return "emf", relTypeImage
+	case "metafile":
+		return "wmf", relTypeImage
+	case "bitmap":
+		switch strings.ToLower(compression) {
+		case "png":
+			return "png", relTypeImage
+		case "jpeg":
+			return "jpg", relTypeImage
+		case "gif":
+			return "gif", relTypeImage
+		case "tiff":
+			return "tiff", relTypeImage
+		}
+		return "bmp", relTypeImage
+	}
+	return "bin", relTypeOLEObject
+}
+
+// bitmapFile prefixes a device-independent bitmap with the file header a
+// .bmp file starts with. Data that already has one is returned as is.
+func bitmapFile(dib []byte) []byte {
+	le := binary.LittleEndian
+	if bytes.HasPrefix(dib, []byte("BM")) || len(dib) < 40 {
+		return dib
+	}
+	headerSize := le.Uint32(dib)
+	bitCount := le.Uint16(dib[14:])
+	colors := le.Uint32(dib[32:])
+	if colors == 0 && bitCount <= 8 {
+		colors = 1 << bitCount
+	}
+	offset := 14 + headerSize + 4*colors
+	if headerSize == 40 && le.Uint32(dib[16:]) == 3 {
+		// Bit field masks follow a BITMAPINFOHEADER
+		offset += 12
+	}
+	header := make([]byte, 14, 14+len(dib))
+	copy(header, "BM")
+	le.PutUint32(header[2:], uint32(14+len(dib)))
+	le.PutUint32(header[10:], offset)
+	return append(header, dib...)
+}
+
+// vdxElements returns the child elements of a node
+func vdxElements(n *xmlNode) []*xmlNode {
+	elements := make([]*xmlNode, 0, len(n.children))
+	for _, child := range n.children {
+		if child.kind == elementNode {
+			elements = append(elements, child)
+		}
+	}
+	return elements
+}
+
+// vdxChildren returns the elements named item within the list element of
+// a node, such as the Page elements of Pages
+func vdxChildren(n *xmlNode, list, item string) []*xmlNode {
+	if element := n.child(list); element != nil {
+		return element.elements(item)
+	}
+	return nil
+}
+
+// vdxCopyAttrs copies the named attributes that are set from src to dst
+func vdxCopyAttrs(dst, src *xmlNode, names ...string) {
+	for _, name := range names {
+		if value := src.attr(name); value != "" {
+			dst.setAttr(name, value)
+		}
+	}
+}
+
+// vdxClone copies an element and its content without namespace prefixes
+// or declarations, so that it takes the namespace of its new parent
+func vdxClone(n *xmlNode) *xmlNode {
+	clone := &xmlNode{kind: n.kind, name: xml.Name{Local: n.name.Local}, text: n.text}
+	for _, a := range n.attrs {
+		if a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns") {
+			continue
+		}
+		clone.attrs = append(clone.attrs, xml.Attr{Name: xml.Name{Local: a.Name.Local}, Value: a.Value})
+	}
+	for _, child := range n.children {
+		if child.kind == elementNode || child.kind == textNode {
+			clone.children = append(clone.children, vdxClone(child))
+		}
+	}
+	return clone
+}
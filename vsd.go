@@ -0,0 +1,505 @@
+package visio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// vsdSignature starts the VisioDocument stream of a binary drawing. The
+// file format version follows at offset 0x1A and the pointer to the
+// trailer stream, the root of the stream tree, at offset 0x24.
+const vsdSignature = "Visio (TM) Drawing\r\n"
+
+// Stream and chunk types of the binary format that are read
+const (
+	vsdTypeText         = 0x0e
+	vsdTypePage         = 0x15
+	vsdTypeColors       = 0x16
+	vsdTypeStencils     = 0x1d
+	vsdTypeNameList     = 0x2c
+	vsdTypeName         = 0x2d
+	vsdTypeNameList2    = 0x32
+	vsdTypeName2        = 0x33
+	vsdTypeShapeGroup   = 0x47
+	vsdTypeShapeShape   = 0x48
+	vsdTypeShapeGuide   = 0x4d
+	vsdTypeShapeForeign = 0x4e
+	vsdTypePageProps    = 0x92
+	vsdTypeXForm        = 0x9b
+	vsdTypeXForm1D      = 0x9d
+	vsdTypeConnect      = 0xb2
+)
+
+// vsdNone marks an absent shape or page reference
+const vsdNone = 0xFFFFFFFF
+
+// vsdMaxDepth bounds the nesting of streams, which a damaged file could
+// make arbitrarily deep
+const vsdMaxDepth = 32
+
+// vsdDocument is the content read from a binary drawing
+type vsdDocument struct {
+	version int
+	pages   []*vsdPage
+}
+
+// vsdPage is a page of a binary drawing. Its name is looked up by index in
+// the names of its sibling streams once the whole tree has been read.
+type vsdPage struct {
+	id           uint32
+	index        uint32
+	names        map[uint32]string
+	name         string
+	backPage     uint32
+	width        float64
+	height       float64
+	pageScale    float64
+	drawingScale float64
+	shapes       []*vsdShape
+}
+
+// vsdShape is a shape of a binary drawing with its transform and text
+type vsdShape struct {
+	id      uint32
+	parent  uint32
+	kind    int
+	pinX    float64
+	pinY    float64
+	width   float64
+	height  float64
+	locPinX float64
+	locPinY float64
+	angle   float64
+	flipX   bool
+	flipY   bool
+	oneD    bool
+	beginX  float64
+	beginY  float64
+	endX    float64
+	endY    float64
+	text    string
+	glue    []vsdGlue
+}
+
+// vsdGlue records that an end point of a one-dimensional shape is glued to
+// another shape. The parts are those of a VSDX Connect: 9 for the begin
+// point and 12 for the end point; 3 for the whole shape and 100 plus the
+// row index for a connection point.
+type vsdGlue struct {
+	fromPart uint16
+	toSheet  uint32
+	toPart   uint16
+}
+
+// vsdPointer locates a stream in the VisioDocument stream. The high nibble
+// of the format tells how the stream is laid out; bit 1 marks compression.
+type vsdPointer struct {
+	kind   uint32
+	offset uint32
+	length uint32
+	format uint16
+}
+
+// vsdParser walks the stream tree of a VisioDocument stream. Records are
+// attributed to the page and shape whose streams or chunks enclose them.
+type vsdParser struct {
+	data    []byte
+	doc     *vsdDocument
+	page    *vsdPage
+	visited map[uint32]bool
+}
+
+// parseVSD reads the pages, shapes, text and glue of a VisioDocument stream.
+// Visio 2000 (version 6) and Visio 2003 to 2010 (version 11) drawings are
+// supported.
+func parseVSD(data []byte) (*vsdDocument, error) {
+	if !bytes.HasPrefix(data, []byte(vsdSignature)) || len(data) < 0x24+18 {
+		return nil, fmt.Errorf("not a Visio drawing")
+	}
+	version := int(data[0x1A])
+	if version != 6 && version != 11 {
+		return nil, fmt.Errorf("unsupported Visio file format version %d; only Visio 2000 to 2010 drawings can be read", version)
+	}
+
+	p := &vsdParser{
+		data:    data,
+		doc:     &vsdDocument{version: version},
+		visited: make(map[uint32]bool),
+	}
+	trailer := readVSDPointer(data[0x24:])
+	stream, shift, err := p.streamData(trailer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trailer stream: %w", err)
+	}
+	p.streams(stream, shift, make(map[uint32]string), nil, 0)
+
+	if len(p.doc.pages) == 0 {
+		return nil, fmt.Errorf("no pages found in Visio drawing")
+	}
+	for i, page := range p.doc.pages {
+		page.name = page.names[page.index]
+		if page.name == "" {
+			page.name = fmt.Sprintf("Page-%d", i+1)
+		}
+	}
+	return p.doc, nil
+}
+
+// readVSDPointer decodes an 18-byte stream pointer
+func readVSDPointer(b []byte) vsdPointer {
+	le := binary.LittleEndian
+	return vsdPointer{
+		kind:   le.Uint32(b) & 0xffff,
+		offset: le.Uint32(b[8:]),
+		length: le.Uint32(b[12:]),
+		format: le.Uint16(b[16:]),
+	}
+}
+
+// streamData returns the contents of a stream, decompressed as needed,
+// and the offset its pointer list is relative to
+func (p *vsdParser) streamData(ptr vsdPointer) ([]byte, int, error) {
+	end := uint64(ptr.offset) + uint64(ptr.length)
+	if end > uint64(len(p.data)) {
+		return nil, 0, fmt.Errorf("stream at %d is out of range", ptr.offset)
+	}
+	raw := p.data[ptr.offset:end]
+	if ptr.format&2 != 0 {
+		return decompressVSD(raw), 4, nil
+	}
+	return raw, 0, nil
+}
+
+// streams handles the streams listed in the pointer list of a stream.
+// Name lists are read first, so the names of sibling streams are known
+// whatever the order of the list.
+func (p *vsdParser) streams(data []byte, shift int, names map[uint32]string, owner *vsdShape, depth int) {
+	le := binary.LittleEndian
+	if shift+4 > len(data) {
+		return
+	}
+	at := int(le.Uint32(data[shift:])) + shift - 4
+	if at < 0 || at+12 > len(data) {
+		return
+	}
+	listSize := int(le.Uint32(data[at:]))
+	count := int(le.Uint32(data[at+4:]))
+	at += 12
+
+	pointers := make([]vsdPointer, 0)
+	for i := 0; i < count && at+18 <= len(data); i++ {
+		pointers = append(pointers, readVSDPointer(data[at:]))
+		at += 18
+	}
+	order := make([]int, 0, len(pointers))
+	if listSize > 1 {
+		for i := 0; i < listSize && at+4 <= len(data); i++ {
+			if n := int(le.Uint32(data[at:])); n < len(pointers) {
+				order = append(order, n)
+			}
+			at += 4
+		}
+	}
+	for i := range pointers {
+		order = append(order, i)
+	}
+
+	handled := make(map[int]bool, len(pointers))
+	for _, nameList := range []bool{true, false} {
+		for _, i := range order {
+			ptr := pointers[i]
+			isNameList := ptr.kind == vsdTypeNameList || ptr.kind == vsdTypeNameList2
+			if handled[i] || ptr.kind == 0 || isNameList != nameList {
+				continue
+			}
+			handled[i] = true
+			p.stream(ptr, uint32(i), names, owner, depth+1)
+		}
+	}
+}
+
+// stream handles a stream. Streams hold either a single record, optionally
+// followed by a pointer list, or a sequence of chunks.
+func (p *vsdParser) stream(ptr vsdPointer, index uint32, names map[uint32]string, owner *vsdShape, depth int) {
+	if depth > vsdMaxDepth || ptr.length == 0 || p.visited[ptr.offset] {
+		return
+	}
+	p.visited[ptr.offset] = true
+	data, shift, err := p.streamData(ptr)
+	if err != nil {
+		return
+	}
+
+	switch ptr.kind {
+	case vsdTypeStencils:
+		// Masters are not read; their shapes must not be taken for page shapes
+		return
+	case vsdTypePage:
+		page := &vsdPage{
+			id:           index,
+			index:        index,
+			names:        names,
+			backPage:     vsdNone,
+			pageScale:    1,
+			drawingScale: 1,
+			shapes:       make([]*vsdShape, 0),
+		}
+		p.doc.pages = append(p.doc.pages, page)
+		previous := p.page
+		p.page = page
+		defer func() { p.page = previous }()
+	}
+
+	switch ptr.format >> 4 {
+	case 0x0, 0x4, 0x5:
+		owner = p.record(int(ptr.kind), index, data, names, owner)
+		if ptr.format>>4 == 0x5 && ptr.kind != vsdTypeColors {
+			// Names in a name list belong to the siblings of the list
+			children := make(map[uint32]string)
+			if ptr.kind == vsdTypeNameList || ptr.kind == vsdTypeNameList2 {
+				children = names
+			}
+			p.streams(data, shift, children, owner, depth)
+		}
+	case 0x8, 0xc, 0xd:
+		p.chunks(data, names, owner)
+	}
+}
+
+// chunks handles a sequence of chunks. Each chunk has a 19-byte header and
+// may be followed by a trailer whose size depends on the chunk type, its
+// level and the file format version. A shape chunk owns the chunks that
+// follow it.
+func (p *vsdParser) chunks(data []byte, names map[uint32]string, owner *vsdShape) {
+	le := binary.LittleEndian
+	for at := 0; at < len(data); {
+		if data[at] == 0 {
+			at++
+			continue
+		}
+		if at+19 > len(data) {
+			return
+		}
+		kind := le.Uint32(data[at:])
+		id := le.Uint32(data[at+4:])
+		list := le.Uint32(data[at+8:])
+		length := int(le.Uint32(data[at+12:]))
+		level := le.Uint16(data[at+16:])
+		unknown := data[at+18]
+		start := at + 19
+		if length < 0 || start+length > len(data) {
+			return
+		}
+		owner = p.record(int(kind), id, data[start:start+length], names, owner)
+		at = start + length + p.chunkTrailer(kind, list, level, unknown)
+	}
+}
+
+// chunkTrailer returns the size of the trailer following a chunk
+func (p *vsdParser) chunkTrailer(kind, list uint32, level uint16, unknown byte) int {
+	trailer := 0
+	switch kind {
+	case 0x2c, 0x64, 0x65, 0x66, 0x69, 0x6a, 0x6b, 0x70, 0x71:
+		trailer = 8
+	default:
+		if list != 0 {
+			trailer = 8
+		}
+	}
+	if p.doc.version == 11 {
+		if list != 0 || (level == 2 && unknown == 0x55) || (level == 2 && unknown == 0x54 && kind == 0xaa) ||
+			(level == 3 && unknown != 0x50 && unknown != 0x54) {
+			trailer += 4
+		}
+		switch kind {
+		case 0x64, 0x65, 0x66, 0x69, 0x6a, 0x6b, 0x6f, 0x71, 0x92, 0xa9, 0xb4, 0xb6, 0xb9, 0xc7:
+			if trailer != 12 && trailer != 4 {
+				trailer += 4
+			}
+		}
+	}
+	switch kind {
+	case 0x1f, 0xc9, 0x2d, 0xd1:
+		trailer = 0
+	}
+	return trailer
+}
+
+// record interprets a record of a page and returns the shape that owns the
+// records that follow: a new shape for shape records, otherwise owner
+func (p *vsdParser) record(kind int, id uint32, data []byte, names map[uint32]string, owner *vsdShape) *vsdShape {
+	r := vsdRecord(data)
+	switch kind {
+	case vsdTypeName:
+		if p.doc.version == 11 {
+			names[id] = decodeUTF16(data)
+		} else {
+			names[id] = strings.TrimRight(decodeCodePage(data, 1252), "\x00")
+		}
+	case vsdTypeName2:
+		end := 0
+		for end+1 < len(data) && (data[end] != 0 || data[end+1] != 0) {
+			end += 2
+		}
+		names[id] = decodeUTF16(data[:end])
+	}
+	if p.page == nil {
+		return owner
+	}
+
+	switch kind {
+	case vsdTypePage:
+		p.page.id = id
+		if background := r.u32(8); background != vsdNone && background != id {
+			p.page.backPage = background
+		}
+	case vsdTypePageProps:
+		p.page.width = r.double(0)
+		p.page.height = r.double(9)
+		if scale, drawing := r.double(36), r.double(45); scale > 0 && drawing > 0 {
+			p.page.pageScale = scale
+			p.page.drawingScale = drawing
+		}
+	case vsdTypeShapeGroup, vsdTypeShapeShape, vsdTypeShapeGuide, vsdTypeShapeForeign:
+		shape := &vsdShape{
+			id:     id,
+			parent: r.u32(10),
+			kind:   kind,
+		}
+		p.page.shapes = append(p.page.shapes, shape)
+		return shape
+	}
+	if owner == nil {
+		return owner
+	}
+
+	switch kind {
+	case vsdTypeXForm:
+		owner.pinX = r.double(0)
+		owner.pinY = r.double(9)
+		owner.width = r.double(18)
+		owner.height = r.double(27)
+		owner.locPinX = r.double(36)
+		owner.locPinY = r.double(45)
+		owner.angle = r.double(54)
+		owner.flipX = r.u8(63) != 0
+		owner.flipY = r.u8(64) != 0
+	case vsdTypeXForm1D:
+		owner.oneD = true
+		owner.beginX = r.double(0)
+		owner.beginY = r.double(9)
+		owner.endX = r.double(18)
+		owner.endY = r.double(27)
+	case vsdTypeConnect:
+		// A connect record follows the 1-D transform of a shape for each
+		// glued end point: the ID of the shape glued to, then the parts
+		owner.glue = append(owner.glue, vsdGlue{
+			toSheet:  r.u32(0),
+			fromPart: r.u16(4),
+			toPart:   r.u16(6),
+		})
+	case vsdTypeText:
+		if len(data) > 8 {
+			if p.doc.version == 11 {
+				owner.text = vsdText(decodeUTF16(data[8:]))
+			} else {
+				owner.text = vsdText(decodeCodePage(data[8:], 1252))
+			}
+		}
+	}
+	return owner
+}
+
+// vsdText normalizes the text of a shape: paragraphs end in line feeds and
+// the placeholders of fields, whose values are not read, are dropped
+func vsdText(s string) string {
+	s = strings.TrimRight(s, "\x00")
+	s = strings.NewReplacer("\r\n", "\n", "\r", "\n", "\u2029", "\n", "\u2028", "\n", "\ufffc", "").Replace(s)
+	return strings.TrimSuffix(s, "\n")
+}
+
+// vsdRecord reads little-endian values from the data of a record, yielding
+// zero past its end
+type vsdRecord []byte
+
+// u8 returns the byte at an offset
+func (r vsdRecord) u8(at int) byte {
+	if at < 0 || at >= len(r) {
+		return 0
+	}
+	return r[at]
+}
+
+// u16 returns the 16-bit value at an offset, or zero past the end
+func (r vsdRecord) u16(at int) uint16 {
+	if at < 0 || at+2 > len(r) {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(r[at:])
+}
+
+// u32 returns the 32-bit value at an offset, or vsdNone past the end
+func (r vsdRecord) u32(at int) uint32 {
+	if at < 0 || at+4 > len(r) {
+		return vsdNone
+	}
+	return binary.LittleEndian.Uint32(r[at:])
+}
+
+// double returns the value of a cell stored at an offset: a unit byte
+// followed by a 64-bit float in internal units (inches or radians)
+func (r vsdRecord) double(at int) float64 {
+	if at < 0 || at+9 > len(r) {
+		return 0
+	}
+	v := math.Float64frombits(binary.LittleEndian.Uint64(r[at+1:]))
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0
+	}
+	return v
+}
+
+// decompressVSD expands a compressed stream. The format is an LZ77 variant
+// with a 4096-byte ring buffer: each flag byte announces eight items, a set
+// bit a literal byte and a clear bit a two-byte back reference holding a
+// 12-bit buffer position and a 4-bit length minus three.
+func decompressVSD(data []byte) []byte {
+	var ring [4096]byte
+	out := make([]byte, 0, len(data)*2)
+	pos := 0
+	for at := 0; at < len(data); {
+		flags := data[at]
+		at++
+		for bit := 0; bit < 8 && at < len(data); bit++ {
+			if flags&(1<<bit) != 0 {
+				ring[pos&4095] = data[at]
+				out = append(out, data[at])
+				at++
+				pos++
+				continue
+			}
+			if at+1 >= len(data) {
+				return out
+			}
+			low, high := int(data[at]), int(data[at+1])
+			at += 2
+			length := high&15 + 3
+			pointer := (high&0xF0)<<4 | low
+			if pointer > 4078 {
+				pointer -= 4078
+			} else {
+				pointer += 18
+			}
+			for j := 0; j < length; j++ {
+				b := ring[(pointer+j)&4095]
+				ring[(pos+j)&4095] = b
+				out = append(out, b)
+			}
+			pos += length
+		}
+	}
+	return out
+}
@@ -0,0 +1,279 @@
+package visio
+
+import (
+	"encoding/binary"
+	"encoding/xml"
+	"math"
+	"testing"
+	"unicode/utf16"
+)
+
+// vsdTestPointer encodes an 18-byte stream pointer
+func vsdTestPointer(kind uint32, offset, length int, format uint16) []byte {
+	b := make([]byte, 18)
+	binary.LittleEndian.PutUint32(b, kind)
+	binary.LittleEndian.PutUint32(b[8:], uint32(offset))
+	binary.LittleEndian.PutUint32(b[12:], uint32(length))
+	binary.LittleEndian.PutUint16(b[16:], format)
+	return b
+}
+
+// vsdTestCells encodes cells as a unit byte followed by a float each
+func vsdTestCells(values ...float64) []byte {
+	b := make([]byte, 0, 9*len(values))
+	for _, v := range values {
+		cell := make([]byte, 9)
+		binary.LittleEndian.PutUint64(cell[1:], math.Float64bits(v))
+		b = append(b, cell...)
+	}
+	return b
+}
+
+// vsdTestUTF16 encodes a string as UTF-16LE
+func vsdTestUTF16(s string) []byte {
+	b := make([]byte, 0)
+	for _, r := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(r), byte(r>>8))
+	}
+	return b
+}
+
+// vsdTestChunk encodes a chunk with a 19-byte header and no trailer
+func vsdTestChunk(kind, id uint32, data []byte) []byte {
+	b := make([]byte, 19, 19+len(data))
+	binary.LittleEndian.PutUint32(b, kind)
+	binary.LittleEndian.PutUint32(b[4:], id)
+	binary.LittleEndian.PutUint32(b[12:], uint32(len(data)))
+	return append(b, data...)
+}
+
+// vsdTestStream encodes a stream holding a record followed by a pointer
+// list
+func vsdTestStream(record []byte, pointers ...[]byte) []byte {
+	at := len(record)
+	if at < 4 {
+		at = 4
+	}
+	b := make([]byte, at, at+12+18*len(pointers))
+	copy(b, record)
+	binary.LittleEndian.PutUint32(b, uint32(at+4))
+	list := make([]byte, 12)
+	binary.LittleEndian.PutUint32(list[4:], uint32(len(pointers)))
+	b = append(b, list...)
+	for _, p := range pointers {
+		b = append(b, p...)
+	}
+	return b
+}
+
+// vsdTestShape encodes a shape record with its parent
+func vsdTestShape(kind, id, parent uint32) []byte {
+	record := make([]byte, 14)
+	binary.LittleEndian.PutUint32(record[10:], parent)
+	return vsdTestChunk(kind, id, record)
+}
+
+// vsdTestGlue encodes a connect record
+func vsdTestGlue(toSheet uint32, fromPart, toPart uint16) []byte {
+	record := make([]byte, 8)
+	binary.LittleEndian.PutUint32(record, toSheet)
+	binary.LittleEndian.PutUint16(record[4:], fromPart)
+	binary.LittleEndian.PutUint16(record[6:], toPart)
+	return vsdTestChunk(vsdTypeConnect, 0, record)
+}
+
+// buildTestVSD builds a Visio 2003 VisioDocument stream with one page
+// named "Flow": two boxes and a connector glued to both
+func buildTestVSD() []byte {
+	data := make([]byte, 0x24+18)
+	copy(data, vsdSignature)
+	data[0x1A] = 11
+	add := func(b []byte) int {
+		at := len(data)
+		data = append(data, b...)
+		return at
+	}
+
+	name := vsdTestUTF16("Flow")
+	nameAt := add(name)
+	names := vsdTestStream(nil, vsdTestPointer(vsdTypeName2, nameAt, len(name), 0))
+	namesAt := add(names)
+
+	props := vsdTestCells(11, 8.5)
+	propsAt := add(props)
+
+	shapes := make([]byte, 0)
+	for _, chunk := range [][]byte{
+		vsdTestShape(vsdTypeShapeShape, 1, vsdNone),
+		vsdTestChunk(vsdTypeXForm, 0, append(vsdTestCells(2, 2, 1, 1, 0.5, 0.5, 0), 0, 0)),
+		vsdTestChunk(vsdTypeText, 0, append(make([]byte, 8), vsdTestUTF16("Start\r")...)),
+		vsdTestShape(vsdTypeShapeShape, 2, vsdNone),
+		vsdTestChunk(vsdTypeXForm, 0, append(vsdTestCells(6, 2, 2, 1, 1, 0.5, 0), 0, 0)),
+		vsdTestShape(vsdTypeShapeShape, 3, vsdNone),
+		vsdTestChunk(vsdTypeXForm, 0, append(vsdTestCells(3.75, 2, 2.5, 0, 1.25, 0, 0), 0, 0)),
+		vsdTestChunk(vsdTypeXForm1D, 0, vsdTestCells(2.5, 2, 5, 2)),
+		vsdTestGlue(1, 9, 3),
+		vsdTestGlue(2, 12, 100),
+		vsdTestGlue(99, 12, 3),
+	} {
+		shapes = append(shapes, chunk...)
+	}
+	shapesAt := add(shapes)
+
+	record := make([]byte, 16)
+	binary.LittleEndian.PutUint32(record[8:], vsdNone)
+	page := vsdTestStream(record,
+		vsdTestPointer(vsdTypePageProps, propsAt, len(props), 0),
+		vsdTestPointer(0x1e, shapesAt, len(shapes), 0xd0),
+	)
+	pageAt := add(page)
+
+	trailer := vsdTestStream(nil,
+		vsdTestPointer(vsdTypePage, pageAt, len(page), 0x50),
+		vsdTestPointer(vsdTypeNameList2, namesAt, len(names), 0x50),
+	)
+	trailerAt := add(trailer)
+	copy(data[0x24:], vsdTestPointer(0x14, trailerAt, len(trailer), 0x50))
+	return data
+}
+
+func TestParseVSD(t *testing.T) {
+	doc, err := parseVSD(buildTestVSD())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.pages) != 1 {
+		t.Fatalf("got %d pages, want 1", len(doc.pages))
+	}
+	page := doc.pages[0]
+	if page.name != "Flow" || page.width != 11 || page.height != 8.5 {
+		t.Errorf("page = %q %vx%v, want \"Flow\" 11x8.5", page.name, page.width, page.height)
+	}
+	if len(page.shapes) != 3 {
+		t.Fatalf("got %d shapes, want 3", len(page.shapes))
+	}
+	if s := page.shapes[0]; s.text != "Start" || s.pinX != 2 || s.width != 1 {
+		t.Errorf("shape 1 = %q pin %v width %v", s.text, s.pinX, s.width)
+	}
+	connector := page.shapes[2]
+	if !connector.oneD || connector.beginX != 2.5 || connector.endX != 5 {
+		t.Errorf("connector = oneD %v begin %v end %v", connector.oneD, connector.beginX, connector.endX)
+	}
+	if len(connector.glue) != 3 {
+		t.Errorf("got %d glue records, want 3", len(connector.glue))
+	}
+}
+
+func TestVSDPageConnects(t *testing.T) {
+	doc, err := parseVSD(buildTestVSD())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var contents xmlPageContents
+	if err := xml.Unmarshal(vsdPageContents(doc.pages[0]).bytes(), &contents); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []xmlConnect{
+		{FromSheet: "3", FromCell: "BeginX", FromPart: "9", ToSheet: "1", ToCell: "PinX", ToPart: "3"},
+		{FromSheet: "3", FromCell: "EndX", FromPart: "12", ToSheet: "2", ToCell: "Connections.X1", ToPart: "100"},
+	}
+	if len(contents.Connects) != len(want) {
+		t.Fatalf("got %d connects, want %d: %+v", len(contents.Connects), len(want), contents.Connects)
+	}
+	for i := range want {
+		if contents.Connects[i] != want[i] {
+			t.Errorf("connect %d = %+v, want %+v", i, contents.Connects[i], want[i])
+		}
+	}
+}
+
+func TestVSDGlueCells(t *testing.T) {
+	tests := []struct {
+		glue     vsdGlue
+		fromCell string
+		toCell   string
+	}{
+		{vsdGlue{fromPart: 9, toPart: 3}, "BeginX", "PinX"},
+		{vsdGlue{fromPart: 12, toPart: 102}, "EndX", "Connections.X3"},
+		{vsdGlue{fromPart: 7, toPart: 3}, "", "PinX"},
+		{vsdGlue{fromPart: 9, toPart: 50}, "BeginX", ""},
+	}
+	for _, tt := range tests {
+		fromCell, toCell := vsdGlueCells(tt.glue)
+		if fromCell != tt.fromCell || toCell != tt.toCell {
+			t.Errorf("vsdGlueCells(%+v) = %q, %q, want %q, %q", tt.glue, fromCell, toCell, tt.fromCell, tt.toCell)
+		}
+	}
+}
+
+func TestParseVSDInvalid(t *testing.T) {
+	valid := buildTestVSD()
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"signature only", []byte(vsdSignature)},
+		{"unsupported version", func() []byte {
+			b := append([]byte(nil), valid...)
+			b[0x1A] = 5
+			return b
+		}()},
+		{"trailer out of range", func() []byte {
+			b := append([]byte(nil), valid...)
+			binary.LittleEndian.PutUint32(b[0x24+8:], uint32(len(b)))
+			return b
+		}()},
+		{"truncated", valid[:len(valid)/2]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseVSD(tt.data); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestDecompressVSD(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"literals", []byte{0xff, 'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h'}, "abcdefgh"},
+		// Back reference to ring position 0 (stored as 4078) of length 3
+		{"back reference", []byte{0x07, 'a', 'b', 'c', 0xee, 0xf0}, "abcabc"},
+		{"truncated reference", []byte{0x00, 0x01}, ""},
+	}
+	for _, tt := range tests {
+		if got := string(decompressVSD(tt.data)); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseVSDDamaged(t *testing.T) {
+	valid := buildTestVSD()
+	// Truncated and corrupted streams must fail or parse, never panic or hang
+	for n := 0; n < len(valid); n++ {
+		parseVSD(valid[:n])
+	}
+	for i := 0x24; i < len(valid); i++ {
+		for _, b := range []byte{0x00, 0x7F, 0xFF} {
+			damaged := append([]byte(nil), valid...)
+			damaged[i] = b
+			parseVSD(damaged)
+		}
+	}
+
+	// A stream listing itself is read once
+	looped := append([]byte(nil), valid...)
+	trailer := readVSDPointer(looped[0x24:])
+	list := int(trailer.offset) + 4 + 12
+	copy(looped[list:], vsdTestPointer(0x14, int(trailer.offset), int(trailer.length), 0x50))
+	if _, err := parseVSD(looped); err == nil {
+		t.Error("expected an error for a drawing without pages")
+	}
+}
@@ -0,0 +1,286 @@
+package visio
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Content types of the drawing parts of a converted package
+const (
+	documentContentType = "application/vnd.ms-visio.drawing.main+xml"
+	pagesContentType    = "application/vnd.ms-visio.pages+xml"
+	pageContentType     = "application/vnd.ms-visio.page+xml"
+	mastersContentType  = "application/vnd.ms-visio.masters+xml"
+	masterContentType   = "application/vnd.ms-visio.master+xml"
+)
+
+// Summary information properties mapped to core and extended properties
+var (
+	vsdCoreProperties = map[uint32]string{
+		2:  "title",
+		3:  "subject",
+		4:  "creator",
+		5:  "keywords",
+		6:  "description",
+		8:  "lastModifiedBy",
+		9:  "revision",
+		11: "lastPrinted",
+		12: "created",
+		13: "modified",
+	}
+	vsdExtendedProperties = map[uint32]string{
+		7:  "Template",
+		18: "Application",
+	}
+	vsdDocumentSummaryProperties = map[uint32]string{
+		14: "Manager",
+		15: "Company",
+	}
+)
+
+// convertVSD converts a legacy binary drawing (.vsd) to a VSDX package
+// holding its pages, shapes, text, connects and document properties
+func convertVSD(data []byte) ([]byte, error) {
+	cf, err := openCompoundFile(data)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := cf.stream("VisioDocument")
+	if err != nil {
+		return nil, err
+	}
+	doc, err := parseVSD(stream)
+	if err != nil {
+		return nil, err
+	}
+
+	return buildPackage(func(e *packageEdit) error {
+		document := newXMLElement("VisioDocument", "xmlns", visioNamespace, "xmlns:r", officeRelationshipsNamespace)
+		if _, err := e.addPart("visio/document.xml", documentContentType, "", relTypeDocument, newXMLDocument(document)); err != nil {
+			return err
+		}
+		if err := setVSDProperties(e, cf); err != nil {
+			return err
+		}
+
+		pages := newXMLElement("Pages", "xmlns", visioNamespace, "xmlns:r", officeRelationshipsNamespace)
+		if _, err := e.addPart("visio/pages/pages.xml", pagesContentType, "visio/document.xml", relTypePages, newXMLDocument(pages)); err != nil {
+			return err
+		}
+		ids := make(map[uint32]bool, len(doc.pages))
+		for _, page := range doc.pages {
+			ids[page.id] = true
+		}
+		backgrounds := make(map[uint32]bool)
+		for _, page := range doc.pages {
+			if ids[page.backPage] {
+				backgrounds[page.backPage] = true
+			}
+		}
+
+		for i, page := range doc.pages {
+			part := fmt.Sprintf("visio/pages/page%d.xml", i+1)
+			relID, err := e.addPart(part, pageContentType, "visio/pages/pages.xml", relTypePage, vsdPageContents(page))
+			if err != nil {
+				return err
+			}
+			element := pages.addElement("Page", nil,
+				"ID", strconv.FormatUint(uint64(page.id), 10),
+				"NameU", page.name,
+				"Name", page.name,
+			)
+			if backgrounds[page.id] {
+				element.setAttr("Background", "1")
+			}
+			if ids[page.backPage] {
+				element.setAttr("BackPage", strconv.FormatUint(uint64(page.backPage), 10))
+			}
+			sheet := element.addElement("PageSheet", nil)
+			for _, c := range []struct {
+				name  string
+				value float64
+			}{
+				{"PageWidth", page.width},
+				{"PageHeight", page.height},
+				{"PageScale", page.pageScale},
+				{"DrawingScale", page.drawingScale},
+			} {
+				sheet.addElement("Cell", nil, "N", c.name, "V", formatFormulaNumber(c.value))
+			}
+			element.addElement("Rel", nil, "r:id", relID)
+		}
+		return nil
+	})
+}
+
+// setVSDProperties copies the summary information of a compound file to
+// the core and extended properties. Files without summary information
+// have no properties.
+func setVSDProperties(e *packageEdit, cf *compoundFile) error {
+	core := make(map[string]string)
+	extended := make(map[string]string)
+	if data, err := cf.stream("\x05SummaryInformation"); err == nil {
+		if values, err := propertySet(data, summaryInformationFormat); err == nil {
+			for id, name := range vsdCoreProperties {
+				if values[id] != "" {
+					core[name] = values[id]
+				}
+			}
+			for id, name := range vsdExtendedProperties {
+				if values[id] != "" {
+					extended[name] = values[id]
+				}
+			}
+		}
+	}
+	if data, err := cf.stream("\x05DocumentSummaryInformation"); err == nil {
+		if values, err := propertySet(data, documentSummaryFormat); err == nil {
+			if values[2] != "" {
+				core["category"] = values[2]
+			}
+			for id, name := range vsdDocumentSummaryProperties {
+				if values[id] != "" {
+					extended[name] = values[id]
+				}
+			}
+		}
+	}
+
+	if len(core) > 0 {
+		if err := setCoreProperties(e, core); err != nil {
+			return err
+		}
+	}
+	if len(extended) > 0 {
+		return setExtendedProperties(e, extended)
+	}
+	return nil
+}
+
+// vsdPageContents builds the contents part of a page. Members of a group
+// are nested under it; shapes whose parent is unknown are placed on the
+// page. Glue to shapes that are not on the page is dropped.
+func vsdPageContents(page *vsdPage) *xmlNode {
+	byID := make(map[uint32]*vsdShape, len(page.shapes))
+	unique := make([]*vsdShape, 0, len(page.shapes))
+	for _, shape := range page.shapes {
+		if _, ok := byID[shape.id]; !ok {
+			byID[shape.id] = shape
+			unique = append(unique, shape)
+		}
+	}
+	top := make([]*vsdShape, 0)
+	members := make(map[uint32][]*vsdShape)
+	for _, shape := range unique {
+		if parent, ok := byID[shape.parent]; ok && parent != shape && parent.kind == vsdTypeShapeGroup {
+			members[parent.id] = append(members[parent.id], shape)
+		} else {
+			top = append(top, shape)
+		}
+	}
+
+	root := newXMLElement("PageContents", "xmlns", visioNamespace, "xmlns:r", officeRelationshipsNamespace)
+	var add func(parent *xmlNode, shapes []*vsdShape)
+	add = func(parent *xmlNode, shapes []*vsdShape) {
+		list := parent.addElement("Shapes", nil)
+		for _, shape := range shapes {
+			element := list.addElement("Shape", nil,
+				"ID", strconv.FormatUint(uint64(shape.id), 10),
+				"Type", vsdShapeType(shape.kind),
+			)
+			for _, c := range shape.cells() {
+				element.addElement("Cell", nil, "N", c.N, "V", c.V)
+			}
+			if shape.text != "" {
+				element.addElement("Text", nil).setText(shape.text)
+			}
+			if len(members[shape.id]) > 0 {
+				add(element, members[shape.id])
+			}
+		}
+	}
+	add(root, top)
+
+	var connects *xmlNode
+	for _, shape := range unique {
+		if !shape.oneD {
+			continue
+		}
+		for _, g := range shape.glue {
+			fromCell, toCell := vsdGlueCells(g)
+			if target, ok := byID[g.toSheet]; !ok || target == shape || fromCell == "" || toCell == "" {
+				continue
+			}
+			if connects == nil {
+				connects = root.addElement("Connects", nil)
+			}
+			connects.addElement("Connect", nil,
+				"FromSheet", strconv.FormatUint(uint64(shape.id), 10),
+				"FromCell", fromCell,
+				"FromPart", strconv.Itoa(int(g.fromPart)),
+				"ToSheet", strconv.FormatUint(uint64(g.toSheet), 10),
+				"ToCell", toCell,
+				"ToPart", strconv.Itoa(int(g.toPart)),
+			)
+		}
+	}
+
+	return newXMLDocument(root)
+}
+
+// vsdGlueCells returns the cells a glue record connects, or empty strings
+// for parts that are not an end point or a glue target
+func vsdGlueCells(g vsdGlue) (string, string) {
+	fromCell := ""
+	switch g.fromPart {
+	case 9:
+		fromCell = "BeginX"
+	case 12:
+		fromCell = "EndX"
+	}
+	toCell := ""
+	switch {
+	case g.toPart == 3:
+		toCell = "PinX"
+	case g.toPart >= 100:
+		toCell = fmt.Sprintf("Connections.X%d", g.toPart-99)
+	}
+	return fromCell, toCell
+}
+
+// vsdShapeType returns the VSDX shape type of a shape record type
+func vsdShapeType(kind int) string {
+	switch kind {
+	case vsdTypeShapeGroup:
+		return "Group"
+	case vsdTypeShapeGuide:
+		return "Guide"
+	case vsdTypeShapeForeign:
+		return "Foreign"
+	}
+	return "Shape"
+}
+
+// cells returns the transform cells of a shape
+func (s *vsdShape) cells() []xmlCell {
+	cells := []xmlCell{
+		{N: "PinX", V: formatFormulaNumber(s.pinX)},
+		{N: "PinY", V: formatFormulaNumber(s.pinY)},
+		{N: "Width", V: formatFormulaNumber(s.width)},
+		{N: "Height", V: formatFormulaNumber(s.height)},
+		{N: "LocPinX", V: formatFormulaNumber(s.locPinX)},
+		{N: "LocPinY", V: formatFormulaNumber(s.locPinY)},
+		{N: "Angle", V: formatFormulaNumber(s.angle)},
+		{N: "FlipX", V: boolCellValue(s.flipX)},
+		{N: "FlipY", V: boolCellValue(s.flipY)},
+	}
+	if s.oneD {
+		cells = append(cells,
+			xmlCell{N: "BeginX", V: formatFormulaNumber(s.beginX)},
+			xmlCell{N: "BeginY", V: formatFormulaNumber(s.beginY)},
+			xmlCell{N: "EndX", V: formatFormulaNumber(s.endX)},
+			xmlCell{N: "EndY", V: formatFormulaNumber(s.endY)},
+		)
+	}
+	return cells
+}
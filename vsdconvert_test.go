@@ -0,0 +1,36 @@
+package visio
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadVSDFile(t *testing.T) {
+	// Padding keeps the stream above the mini stream cutoff
+	stream := append(buildTestVSD(), make([]byte, 4096)...)
+	f := buildTestCompoundFile(stream, []byte("not a property set"))
+	path := filepath.Join(t.TempDir(), "flow.vsd")
+	if err := os.WriteFile(path, f.data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := NewReader(path).ReadPage("Flow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Width != 11 || page.Height != 8.5 {
+		t.Errorf("page size = %vx%v, want 11x8.5", page.Width, page.Height)
+	}
+	if len(page.Shapes) != 3 || page.Shapes[0].Text != "Start" {
+		t.Fatalf("shapes = %+v, want 3 shapes, the first with text Start", page.Shapes)
+	}
+	want := Connection{ConnectorID: "3", FromShapeID: "1", FromGlue: "PinX", ToShapeID: "2", ToGlue: "Connections.X1"}
+	if len(page.Connections) != 1 || page.Connections[0] != want {
+		t.Errorf("connections = %+v, want %+v", page.Connections, want)
+	}
+
+	if err := NewWriter(path).WriteShape("Flow", ShapeData{ID: "1", Text: "Begin"}, false); err == nil {
+		t.Error("writing a .vsd file: expected an error")
+	}
+}
@@ -2,11 +2,10 @@ package visio
 
 import (
 	"archive/zip"
+	"errors"
 	"fmt"
 	"io"
 	"os"
-	"path/filepath"
-	"strings"
 )
 
 // Writer handles writing to Visio files
@@ -21,8 +20,57 @@ func NewWriter(filePath string) *Writer {
 	}
 }
 
-// WriteShape writes or updates a shape on a page
+// errPageNotFound is returned when the page to edit does not exist
+var errPageNotFound = errors.New("page not found")
+
+// WriteShape writes or updates a shape on a page. Shape data with an ID
+// updates that shape; otherwise a new shape is added.
 func (w *Writer) WriteShape(pageName string, shapeData ShapeData, createPage bool) error {
+	err := w.updatePage(pageName, func(pkg *vsdxPackage, tree *xmlNode) error {
+		id := shapeData.ID
+		if id != "" {
+			shape, err := shapeElement(tree, id)
+			if err != nil {
+				return err
+			}
+			w.updateShapeElement(shape, shapeData)
+		} else {
+			root := tree.root()
+			shapes := root.child("Shapes")
+			if shapes == nil {
+				shapes = root.addElement("Shapes", []string{"Connects", "InteractionRules", "Relationships"})
+			}
+			id = nextShapeID(tree)
+			w.addShapeElement(shapes, id, shapeData)
+		}
+		return applyUserCellEdits(pkg, tree, id, shapeData.User)
+	})
+	if errors.Is(err, errPageNotFound) && createPage {
+		// TODO: Create new page
+		return fmt.Errorf("page creation not yet implemented")
+	}
+	return err
+}
+
+// updatePage rewrites the contents part of a page. The edit function
+// changes the parsed part; the page's formulas are then recalculated.
+func (w *Writer) updatePage(pageName string, edit func(pkg *vsdxPackage, tree *xmlNode) error) error {
+	return w.updatePackage(func(e *packageEdit) error {
+		entry, ok := e.pkg.findPage(pageName)
+		if !ok || !e.hasPart(entry.part) {
+			return fmt.Errorf("%w: %s", errPageNotFound, pageName)
+		}
+		if err := w.modifyPage(e, entry, edit); err != nil {
+			return fmt.Errorf("failed to modify page: %w", err)
+		}
+		return nil
+	})
+}
+
+// updatePackage applies an edit to the parts of the file. Parts the edit
+// touched are rewritten, new parts are appended and all other parts are
+// copied as-is; the file is replaced through a temporary copy.
+func (w *Writer) updatePackage(edit func(e *packageEdit) error) error {
 	// Check if file exists
 	if !FileExists(w.filePath) {
 		return fmt.Errorf("file does not exist: %s", w.filePath)
@@ -31,10 +79,22 @@ func (w *Writer) WriteShape(pageName string, shapeData ShapeData, createPage boo
 	// Read existing file
 	zipReader, err := zip.OpenReader(w.filePath)
 	if err != nil {
+		if data, readErr := os.ReadFile(w.filePath); readErr == nil && (isCompoundFile(data) || isVDX(data)) {
+			return fmt.Errorf("VSD and VDX files are read-only; convert the drawing to VSDX to edit it: %s", w.filePath)
+		}
 		return fmt.Errorf("failed to open VSDX file: %w", err)
 	}
 	defer zipReader.Close()
 
+	pkg, err := openPackage(&zipReader.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to open VSDX package: %w", err)
+	}
+	e := newPackageEdit(pkg)
+	if err := edit(e); err != nil {
+		return err
+	}
+
 	// Create temporary file for writing
 	tempFile := w.filePath + ".tmp"
 	outFile, err := os.Create(tempFile)
@@ -46,35 +106,23 @@ func (w *Writer) WriteShape(pageName string, shapeData ShapeData, createPage boo
 	zipWriter := zip.NewWriter(outFile)
 	defer zipWriter.Close()
 
-	pageFound := false
-	pageModified := false
-
-	// Copy existing files and modify target page
+	// Copy existing files, writing edited parts from their trees
 	for _, file := range zipReader.File {
-		if w.isTargetPageFile(file.Name, pageName) {
-			pageFound = true
-			// Modify this page
-			err := w.modifyPageWithShape(file, zipWriter, shapeData)
-			if err != nil {
-				return fmt.Errorf("failed to modify page: %w", err)
-			}
-			pageModified = true
+		if tree, ok := e.updated(file.Name); ok {
+			err = w.writeZipFile(file.Name, tree.bytes(), zipWriter)
 		} else {
-			// Copy file as-is
-			err := w.copyZipFile(file, zipWriter)
-			if err != nil {
-				return fmt.Errorf("failed to copy file: %w", err)
-			}
+			err = w.copyZipFile(file, zipWriter)
+		}
+		if err != nil {
+			os.Remove(tempFile)
+			return fmt.Errorf("failed to write %s: %w", file.Name, err)
 		}
 	}
-
-	if !pageFound && !createPage {
-		return fmt.Errorf("page not found: %s", pageName)
-	}
-
-	if !pageModified && createPage {
-		// TODO: Create new page
-		return fmt.Errorf("page creation not yet implemented")
+	for _, name := range e.added {
+		if err := w.writeZipFile(name, e.addedContents(name), zipWriter); err != nil {
+			os.Remove(tempFile)
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
 	}
 
 	// Close writers
@@ -92,78 +140,104 @@ func (w *Writer) WriteShape(pageName string, shapeData ShapeData, createPage boo
 	return nil
 }
 
-// isTargetPageFile checks if a file is the target page
-func (w *Writer) isTargetPageFile(fileName, pageName string) bool {
-	// Simplified check - should match page by name from document.xml
-	return strings.Contains(fileName, "visio/pages/page") &&
-		strings.HasSuffix(fileName, ".xml") &&
-		!strings.Contains(fileName, "_rels")
-}
-
-// modifyPageWithShape modifies a page file to add/update a shape
-func (w *Writer) modifyPageWithShape(file *zip.File, zipWriter *zip.Writer, shapeData ShapeData) error {
-	// Read original page content
-	rc, err := file.Open()
+// modifyPage applies an edit to a page part and recalculates the formulas
+// of the page
+func (w *Writer) modifyPage(e *packageEdit, entry *pageEntry, edit func(pkg *vsdxPackage, tree *xmlNode) error) error {
+	tree, err := e.part(entry.part)
 	if err != nil {
 		return err
 	}
-	defer rc.Close()
 
-	data, err := io.ReadAll(rc)
-	if err != nil {
+	if err := edit(e.pkg, tree); err != nil {
 		return err
 	}
 
-	content := string(data)
-
-	// Add shape to page XML
-	// This is a simplified implementation
-	// Production code should use proper XML manipulation
-	modifiedContent := w.addShapeToPageXML(content, shapeData)
+	if err := recalculatePage(e.pkg, entry, tree); err != nil {
+		return fmt.Errorf("failed to recalculate page: %w", err)
+	}
+	return nil
+}
 
-	// Write modified content
-	writer, err := zipWriter.Create(file.Name)
-	if err != nil {
-		return err
+// addShapeElement appends a Shape element for shapeData. The local pin is
+// written as a formula of the size, so it follows later resizes.
+func (w *Writer) addShapeElement(shapes *xmlNode, id string, shapeData ShapeData) *xmlNode {
+	shapeType := shapeData.Type
+	if shapeType == "" {
+		shapeType = "Shape"
+	}
+	shape := shapes.addElement("Shape", nil,
+		"ID", id,
+		"Type", shapeType,
+		"Name", shapeData.Name,
+		"NameU", shapeData.Name,
+	)
+
+	cells := []struct{ name, value, formula string }{
+		{"PinX", formatFormulaNumber(floatValue(shapeData.PinX)), ""},
+		{"PinY", formatFormulaNumber(floatValue(shapeData.PinY)), ""},
+		{"Width", formatFormulaNumber(floatValue(shapeData.Width)), ""},
+		{"Height", formatFormulaNumber(floatValue(shapeData.Height)), ""},
+		{"LocPinX", "0", "Width*0.5"},
+		{"LocPinY", "0", "Height*0.5"},
+	}
+	for _, c := range cells {
+		shape.addElement("Cell", nil, "N", c.name, "V", c.value, "F", c.formula)
 	}
 
-	_, err = writer.Write([]byte(modifiedContent))
-	return err
+	if shapeData.Text != "" {
+		shape.addElement("Text", nil).setText(shapeData.Text)
+	}
+	return shape
 }
 
-// addShapeToPageXML adds a shape to page XML content
-func (w *Writer) addShapeToPageXML(content string, shapeData ShapeData) string {
-	// Find the Shapes section
-	shapesEndTag := "</Shapes>"
-	idx := strings.Index(content, shapesEndTag)
+// updateShapeElement writes the fields of shapeData that are set to an
+// existing Shape element. Formulas of the updated cells are replaced.
+func (w *Writer) updateShapeElement(shape *xmlNode, shapeData ShapeData) {
+	if shapeData.Name != "" {
+		shape.setAttr("Name", shapeData.Name)
+		shape.setAttr("NameU", shapeData.Name)
+	}
 
-	if idx == -1 {
-		// No Shapes section, return as-is
-		return content
+	cells := []struct {
+		name  string
+		value *float64
+	}{
+		{"PinX", shapeData.PinX},
+		{"PinY", shapeData.PinY},
+		{"Width", shapeData.Width},
+		{"Height", shapeData.Height},
+	}
+	for _, c := range cells {
+		if c.value != nil {
+			setCellElement(shape, beforeShapeCell, xmlCell{N: c.name, V: formatFormulaNumber(*c.value)})
+		}
 	}
 
-	// Create shape XML
-	shapeXML := w.generateShapeXML(shapeData)
+	if shapeData.Text != "" {
+		text := shape.child("Text")
+		if text == nil {
+			text = shape.addElement("Text", beforeShapeSection[1:])
+		}
+		text.setText(shapeData.Text)
+	}
+}
 
-	// Insert before </Shapes>
-	return content[:idx] + shapeXML + content[idx:]
+// floatValue dereferences an optional number, defaulting to zero
+func floatValue(v *float64) float64 {
+	if v == nil {
+		return 0
+	}
+	return *v
 }
 
-// generateShapeXML generates XML for a shape
-func (w *Writer) generateShapeXML(shapeData ShapeData) string {
-	// Simplified shape XML generation
-	// Production code should use proper XML encoding
-	xml := fmt.Sprintf(`
-    <Shape ID="1" Type="Shape">
-        <Cell N="PinX" V="%.2f"/>
-        <Cell N="PinY" V="%.2f"/>
-        <Cell N="Width" V="%.2f"/>
-        <Cell N="Height" V="%.2f"/>
-        <Text>%s</Text>
-    </Shape>
-`, shapeData.PinX, shapeData.PinY, shapeData.Width, shapeData.Height, shapeData.Text)
-
-	return xml
+// writeZipFile writes a file to the destination zip
+func (w *Writer) writeZipFile(name string, data []byte, zipWriter *zip.Writer) error {
+	writer, err := zipWriter.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(data)
+	return err
 }
 
 // copyZipFile copies a file from source zip to destination zip
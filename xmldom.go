@@ -0,0 +1,345 @@
+package visio
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// xmlNode is a node of a minimal XML tree used to edit parts in place.
+// Parsing uses raw tokens, so namespace prefixes, attribute order and
+// content the typed models do not know about survive a round trip.
+type xmlNode struct {
+	kind     xmlNodeKind
+	name     xml.Name // Name.Space holds the prefix as written, not the namespace URL
+	attrs    []xml.Attr
+	children []*xmlNode
+	text     string // Character data, comment text or processing instruction
+}
+
+// xmlNodeKind distinguishes elements from the other node types
+type xmlNodeKind int
+
+const (
+	documentNode xmlNodeKind = iota
+	elementNode
+	textNode
+	commentNode
+	procInstNode
+	directiveNode
+)
+
+// parseXMLTree parses a part into a document node
+func parseXMLTree(data []byte) (*xmlNode, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	document := &xmlNode{kind: documentNode}
+	stack := []*xmlNode{document}
+
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse XML: %w", err)
+		}
+		parent := stack[len(stack)-1]
+		switch token := token.(type) {
+		case xml.StartElement:
+			node := &xmlNode{
+				kind:  elementNode,
+				name:  token.Name,
+				attrs: append([]xml.Attr(nil), token.Attr...),
+			}
+			parent.children = append(parent.children, node)
+			stack = append(stack, node)
+		case xml.EndElement:
+			if len(stack) == 1 {
+				return nil, fmt.Errorf("failed to parse XML: unexpected </%s>", token.Name.Local)
+			}
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			parent.children = append(parent.children, &xmlNode{kind: textNode, text: string(token)})
+		case xml.Comment:
+			parent.children = append(parent.children, &xmlNode{kind: commentNode, text: string(token)})
+		case xml.ProcInst:
+			parent.children = append(parent.children, &xmlNode{
+				kind: procInstNode,
+				name: xml.Name{Local: token.Target},
+				text: string(token.Inst),
+			})
+		case xml.Directive:
+			parent.children = append(parent.children, &xmlNode{kind: directiveNode, text: string(token)})
+		}
+	}
+	if len(stack) != 1 {
+		return nil, fmt.Errorf("failed to parse XML: unclosed element <%s>", stack[len(stack)-1].name.Local)
+	}
+	return document, nil
+}
+
+// newXMLElement creates an element. Attributes are given as name/value
+// pairs; empty values are omitted.
+func newXMLElement(name string, attrs ...string) *xmlNode {
+	node := &xmlNode{kind: elementNode, name: splitQName(name)}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		if attrs[i+1] != "" {
+			node.setAttr(attrs[i], attrs[i+1])
+		}
+	}
+	return node
+}
+
+// newXMLDocument creates a document node with an XML declaration and the
+// given root element
+func newXMLDocument(root *xmlNode) *xmlNode {
+	return &xmlNode{
+		kind: documentNode,
+		children: []*xmlNode{
+			{kind: procInstNode, name: xml.Name{Local: "xml"}, text: `version="1.0" encoding="UTF-8" standalone="yes"`},
+			{kind: textNode, text: "\n"},
+			root,
+		},
+	}
+}
+
+// splitQName splits a prefixed name such as r:id
+func splitQName(name string) xml.Name {
+	if prefix, local, ok := strings.Cut(name, ":"); ok {
+		return xml.Name{Space: prefix, Local: local}
+	}
+	return xml.Name{Local: name}
+}
+
+// root returns the document element
+func (n *xmlNode) root() *xmlNode {
+	for _, child := range n.children {
+		if child.kind == elementNode {
+			return child
+		}
+	}
+	return nil
+}
+
+// attr returns the value of the attribute with the given local name
+func (n *xmlNode) attr(name string) string {
+	for _, a := range n.attrs {
+		if a.Name.Local == name && a.Name.Space != "xmlns" {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// setAttr sets an attribute, keeping its position if it already exists
+func (n *xmlNode) setAttr(name, value string) {
+	qname := splitQName(name)
+	for i, a := range n.attrs {
+		if a.Name == qname {
+			n.attrs[i].Value = value
+			return
+		}
+	}
+	n.attrs = append(n.attrs, xml.Attr{Name: qname, Value: value})
+}
+
+// removeAttr removes an attribute
+func (n *xmlNode) removeAttr(name string) {
+	qname := splitQName(name)
+	for i, a := range n.attrs {
+		if a.Name == qname {
+			n.attrs = append(n.attrs[:i], n.attrs[i+1:]...)
+			return
+		}
+	}
+}
+
+// child returns the first child element with the given local name
+func (n *xmlNode) child(name string) *xmlNode {
+	for _, c := range n.children {
+		if c.kind == elementNode && c.name.Local == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// elements returns the child elements with the given local name
+func (n *xmlNode) elements(name string) []*xmlNode {
+	elements := make([]*xmlNode, 0)
+	for _, c := range n.children {
+		if c.kind == elementNode && c.name.Local == name {
+			elements = append(elements, c)
+		}
+	}
+	return elements
+}
+
+// findElement returns the first child element with the given local name
+// and attribute value
+func (n *xmlNode) findElement(name, attr, value string) *xmlNode {
+	for _, c := range n.children {
+		if c.kind == elementNode && c.name.Local == name && c.attr(attr) == value {
+			return c
+		}
+	}
+	return nil
+}
+
+// appendChild adds a child at the end
+func (n *xmlNode) appendChild(child *xmlNode) {
+	n.children = append(n.children, child)
+}
+
+// insertChild adds a child before the first child element with one of the
+// given local names, or at the end
+func (n *xmlNode) insertChild(child *xmlNode, before ...string) {
+	for i, c := range n.children {
+		if c.kind != elementNode {
+			continue
+		}
+		for _, name := range before {
+			if c.name.Local == name {
+				n.children = append(n.children[:i], append([]*xmlNode{child}, n.children[i:]...)...)
+				return
+			}
+		}
+	}
+	n.appendChild(child)
+}
+
+// addElement creates a child element with the parent's namespace prefix
+// and inserts it before the first child element with one of the given
+// names, or at the end
+func (n *xmlNode) addElement(name string, before []string, attrs ...string) *xmlNode {
+	child := newXMLElement(name, attrs...)
+	if !strings.Contains(name, ":") {
+		child.name.Space = n.name.Space
+	}
+	n.insertChild(child, before...)
+	return child
+}
+
+// removeChild removes a child node
+func (n *xmlNode) removeChild(child *xmlNode) {
+	for i, c := range n.children {
+		if c == child {
+			n.children = append(n.children[:i], n.children[i+1:]...)
+			return
+		}
+	}
+}
+
+// setText replaces the content of an element with character data
+func (n *xmlNode) setText(text string) {
+	n.children = []*xmlNode{{kind: textNode, text: text}}
+}
+
+// textContent returns the character data of an element and its descendants
+func (n *xmlNode) textContent() string {
+	text := strings.Builder{}
+	var walk func(node *xmlNode)
+	walk = func(node *xmlNode) {
+		for _, c := range node.children {
+			switch c.kind {
+			case textNode:
+				text.WriteString(c.text)
+			case elementNode:
+				walk(c)
+			}
+		}
+	}
+	walk(n)
+	return text.String()
+}
+
+// bytes serializes the tree
+func (n *xmlNode) bytes() []byte {
+	buf := &bytes.Buffer{}
+	n.write(buf)
+	return buf.Bytes()
+}
+
+// write serializes a node and its descendants
+func (n *xmlNode) write(buf *bytes.Buffer) {
+	switch n.kind {
+	case documentNode:
+		for _, c := range n.children {
+			c.write(buf)
+		}
+	case elementNode:
+		buf.WriteString("<")
+		buf.WriteString(qualifiedName(n.name))
+		for _, a := range n.attrs {
+			buf.WriteString(" ")
+			buf.WriteString(qualifiedName(a.Name))
+			buf.WriteString(`="`)
+			escapeXML(buf, a.Value, true)
+			buf.WriteString(`"`)
+		}
+		if len(n.children) == 0 {
+			buf.WriteString("/>")
+			return
+		}
+		buf.WriteString(">")
+		for _, c := range n.children {
+			c.write(buf)
+		}
+		buf.WriteString("</")
+		buf.WriteString(qualifiedName(n.name))
+		buf.WriteString(">")
+	case textNode:
+		escapeXML(buf, n.text, false)
+	case commentNode:
+		buf.WriteString("<!--")
+		buf.WriteString(n.text)
+		buf.WriteString("-->")
+	case procInstNode:
+		buf.WriteString("<?")
+		buf.WriteString(n.name.Local)
+		if n.text != "" {
+			buf.WriteString(" ")
+			buf.WriteString(n.text)
+		}
+		buf.WriteString("?>")
+	case directiveNode:
+		buf.WriteString("<!")
+		buf.WriteString(n.text)
+		buf.WriteString(">")
+	}
+}
+
+// qualifiedName returns prefix:local, or local for unprefixed names
+func qualifiedName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+// escapeXML writes character data or an attribute value with the
+// characters that would change its meaning escaped
+func escapeXML(buf *bytes.Buffer, s string, attr bool) {
+	for _, r := range s {
+		switch {
+		case r == '&':
+			buf.WriteString("&amp;")
+		case r == '<':
+			buf.WriteString("&lt;")
+		case r == '>':
+			buf.WriteString("&gt;")
+		case r == '"' && attr:
+			buf.WriteString("&quot;")
+		case r == '\n' && attr:
+			buf.WriteString("&#xA;")
+		case r == '\r':
+			buf.WriteString("&#xD;")
+		case r == '\t' && attr:
+			buf.WriteString("&#x9;")
+		default:
+			buf.WriteRune(r)
+		}
+	}
+}